@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"time"
+)
+
+// auditRecord is a line of the GOLF_AUDIT_LOG file.
+type auditRecord struct {
+	Time      time.Time     `json:"time"`
+	User      string        `json:"user"`
+	Host      string        `json:"host"`
+	Dir       string        `json:"dir"`
+	Args      []string      `json:"args"` // golf's command line, as given.
+	Begin     []string      `json:"begin,omitempty"`
+	BeginFile []string      `json:"beginfile,omitempty"`
+	Script    []string      `json:"script,omitempty"`
+	EndFile   []string      `json:"endfile,omitempty"`
+	End       []string      `json:"end,omitempty"`
+	Files     []string      `json:"files,omitempty"`
+	Status    int           `json:"status"`
+	Signal    string        `json:"signal,omitempty"`
+	Duration  time.Duration `json:"duration_ns"`
+}
+
+// auditLog appends a record of each golf run to the file named by
+// GOLF_AUDIT_LOG, if set.
+type auditLog struct {
+	f   *os.File
+	rec auditRecord
+}
+
+// openAudit opens the audit log, if one is configured. golf refuses to run
+// if it can't, so that no run goes unrecorded.
+func openAudit(args []string) (*auditLog, error) {
+	name := os.Getenv("GOLF_AUDIT_LOG")
+	if name == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{f: f, rec: auditRecord{Time: time.Now(), Args: args}}
+	if u, err := user.Current(); err == nil {
+		a.rec.User = u.Username
+	} else {
+		a.rec.User = os.Getenv("USER")
+	}
+	a.rec.Host, _ = os.Hostname()
+	a.rec.Dir, _ = os.Getwd()
+	return a, nil
+}
+
+// finish records the outcome of p's run, and closes the log.
+func (a *auditLog) finish(p *prog, status int) error {
+	if a == nil {
+		return nil
+	}
+	a.rec.Begin, a.rec.Script, a.rec.End, a.rec.Files = p.BeginSrc, p.RawSrc, p.EndSrc, p.RawArgs
+	a.rec.BeginFile, a.rec.EndFile = p.BeginFileSrc, p.EndFileSrc
+	a.rec.Status = status
+	if p.reraise != nil {
+		a.rec.Signal = p.reraise.String()
+	}
+	a.rec.Duration = time.Since(a.rec.Time)
+	line, err := json.Marshal(a.rec)
+	if err != nil {
+		a.f.Close()
+		return err
+	}
+	// A single write, so that concurrent runs don't interleave.
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/gaal/golf/prelude"
+)
+
+// checkContainer returns an error if the golf command line can't be run in
+// a container.
+func checkContainer(args []string, remote string, parallel int) error {
+	if remote != "" {
+		return fmt.Errorf("can't be combined with golf remote")
+	}
+	for _, arg := range args {
+		if _, ok := prelude.InputFD(arg); ok {
+			return fmt.Errorf("%s: inherited descriptors are not passed on", arg)
+		}
+	}
+	if parallel > 1 {
+		return fmt.Errorf("-P is not supported")
+	}
+	return nil
+}
+
+// runContainer runs the one-liner binary bin in a container of p.Container,
+// with the current directory mounted at the same path, as the current user.
+// The engine is docker, or the command named by $GOLF_CONTAINER_ENGINE, such
+// as podman.
+func (p *prog) runContainer(bin string) int {
+	engine := os.Getenv("GOLF_CONTAINER_ENGINE")
+	if engine == "" {
+		engine = "docker"
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		prelude.Warn("golf: --in-container: %v", err)
+		return 1
+	}
+	args := []string{"run", "--rm", "-i",
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"-v", wd + ":" + wd, "-w", wd,
+		"-v", bin + ":/golfing:ro",
+		p.Container, "/golfing"}
+	cmd := exec.Command(engine, append(args, p.binArgs(p.RawArgs)...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := relay.run(cmd); err != nil {
+		return p.exitStatus(err)
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import "strings"
+
+// Loop control statements that calls to Next, NextFile and Stop in line mode
+// blocks stand for.
+var (
+	lineControl = map[string]string{"Next": "continue Line", "NextFile": "continue File", "Stop": "break File"}
+	fileControl = map[string]string{"NextFile": "continue File", "Stop": "break File"}
+	pmapControl = map[string]string{"Next": "return"}
+)
+
+// expandControl replaces the calls to the prelude's Next, NextFile and Stop
+// functions in src with the loop control statements in stmts, so that
+// scripts needn't know the labels of the loops golf generates. Calls that
+// have no statement are left to the prelude functions, which die.
+func expandControl(src string, stmts map[string]string) string {
+	var b strings.Builder
+	last := 0
+	scanGo(src, func(i int, c byte, depth int) bool {
+		if i < last || i > 0 && (isIdentByte(src[i-1]) || src[i-1] == '.') {
+			return true
+		}
+		for name, stmt := range stmts {
+			if strings.HasPrefix(src[i:], name+"()") {
+				b.WriteString(src[last:i])
+				b.WriteString(stmt)
+				last = i + len(name) + 2
+				break
+			}
+		}
+		return true
+	})
+	b.WriteString(src[last:])
+	return b.String()
+}
+
+// usesIdent reports whether the Go source src refers to any of the
+// identifiers names, outside string literals and comments, and not as a
+// field or method.
+func usesIdent(src string, names ...string) bool {
+	found := false
+	scanGo(src, func(i int, c byte, depth int) bool {
+		if i > 0 && (isIdentByte(src[i-1]) || src[i-1] == '.') {
+			return true
+		}
+		for _, name := range names {
+			found = strings.HasPrefix(src[i:], name) && (i+len(name) == len(src) || !isIdentByte(src[i+len(name)]))
+			if found {
+				break
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
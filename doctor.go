@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// doctorCheck is one of the checks made by golf doctor. run returns a short
+// description of what it found, and an error if the check failed.
+type doctorCheck struct {
+	name string
+	run  func() (string, error)
+	// fatal checks are ones one-liners can't run without. Others only
+	// affect some flags, and fail with a warning.
+	fatal bool
+	// hint says how to fix a failure.
+	hint string
+}
+
+// runDoctor checks that golf's environment can build and run one-liners,
+// for "golf doctor", and reports what it finds on stdout. It returns the exit
+// status: 1 if a fatal check failed.
+func runDoctor() int {
+	checks := []doctorCheck{
+		{"go toolchain", doctorGo, true, "install Go from https://go.dev/dl/ and put its bin directory on PATH"},
+		{"goimports", doctorGoimports, false, "-g needs it: go install golang.org/x/tools/cmd/goimports@latest"},
+		{"build cache", doctorCache, true, "set GOCACHE to a writable directory"},
+		{"temp dir", doctorTmpdir, true, "set GOLF_TMPDIR, or pass --tmpdir, naming a writable directory"},
+		{"module proxy", doctorProxy, false, "-M of modules outside the standard library needs it: check GOPROXY, and any HTTPS_PROXY"},
+		{"canary: script", doctorCanary("ok\n", "", "-le", `Print("ok")`), true, "see the build errors above"},
+		{"canary: line mode", doctorCanary("b\n", "a b\n", "-lane", `Print(Field(2))`), true, "see the build errors above"},
+	}
+	status := 0
+	for _, c := range checks {
+		what, err := c.run()
+		switch {
+		case err == nil:
+			fmt.Printf("ok    %s: %s\n", c.name, what)
+			continue
+		case c.fatal:
+			fmt.Printf("FAIL  %s: %v\n", c.name, err)
+			status = 1
+		default:
+			fmt.Printf("warn  %s: %v\n", c.name, err)
+		}
+		fmt.Printf("      %s\n", c.hint)
+	}
+	return status
+}
+
+// doctorGo checks that the go command runs, and is recent enough for the
+// go.mod golf writes.
+func doctorGo() (string, error) {
+	out, err := exec.Command("go", "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("go version: %v", err)
+	}
+	v := strings.TrimSpace(string(out))
+	f := strings.Fields(v)
+	if len(f) < 3 || !strings.HasPrefix(f[2], "go") {
+		return v, nil // A development version, presumably.
+	}
+	if !goVersionAtLeast(strings.TrimPrefix(f[2], "go"), *goVer) {
+		return "", fmt.Errorf("%s is older than go %s, which one-liners declare (see -goVer)", f[2], *goVer)
+	}
+	return v, nil
+}
+
+// goVersionAtLeast reports whether Go version have, such as 1.21.5 or
+// 1.22rc1, is at least want, such as 1.17. Only major and minor versions
+// are compared.
+func goVersionAtLeast(have, want string) bool {
+	parse := func(v string) (int, int) {
+		f := strings.SplitN(v, ".", 3)
+		major, _ := strconv.Atoi(f[0])
+		minor := 0
+		if len(f) > 1 {
+			end := 0
+			for end < len(f[1]) && f[1][end] >= '0' && f[1][end] <= '9' {
+				end++
+			}
+			minor, _ = strconv.Atoi(f[1][:end])
+		}
+		return major, minor
+	}
+	hMajor, hMinor := parse(have)
+	wMajor, wMinor := parse(want)
+	return hMajor > wMajor || hMajor == wMajor && hMinor >= wMinor
+}
+
+func doctorGoimports() (string, error) {
+	return exec.LookPath("goimports")
+}
+
+// doctorCache checks that the go build cache is writable.
+func doctorCache() (string, error) {
+	out, err := exec.Command("go", "env", "GOCACHE").Output()
+	if err != nil {
+		return "", fmt.Errorf("go env: %v", err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" || dir == "off" {
+		return "", fmt.Errorf("GOCACHE is off")
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "golf-doctor-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return dir, nil
+}
+
+// doctorTmpdir checks that one-liners can be built in the build directory.
+func doctorTmpdir() (string, error) {
+	parent := os.Getenv("GOLF_TMPDIR")
+	dir, err := os.MkdirTemp(parent, "golf-doctor-")
+	if err != nil {
+		return "", err
+	}
+	os.RemoveAll(dir)
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	return parent, nil
+}
+
+// doctorProxy checks that modules can be looked up, by asking the go command
+// for the latest version of one, so that its own proxy settings apply.
+func doctorProxy() (string, error) {
+	dir, err := os.MkdirTemp(os.Getenv("GOLF_TMPDIR"), "golf-doctor-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "go", "list", "-m", "golang.org/x/tools@latest")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("go list -m: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	proxy, _ := exec.Command("go", "env", "GOPROXY").Output()
+	return strings.TrimSpace(string(proxy)), nil
+}
+
+// doctorCanary returns a check that runs golf itself with args and input,
+// and compares its output with want.
+func doctorCanary(want, input string, args ...string) func() (string, error) {
+	return func() (string, error) {
+		self, err := os.Executable()
+		if err != nil {
+			return "", err
+		}
+		cmd := exec.Command(self, args...)
+		cmd.Stdin = strings.NewReader(input)
+		cmd.Stderr = os.Stderr
+		start := time.Now()
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("golf %s: %v", strings.Join(args, " "), err)
+		}
+		if string(out) != want {
+			return "", fmt.Errorf("golf %s: output %q, want %q", strings.Join(args, " "), out, want)
+		}
+		return fmt.Sprintf("built and ran in %v", time.Since(start).Round(10*time.Millisecond)), nil
+	}
+}
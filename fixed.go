@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compileFixed turns a --fixed spec such as "1-8,9-16,17-" into a Go
+// statement that splits Line into Fields at those columns.
+//
+// The spec is a comma-separated list of 1-based, inclusive column ranges,
+// like cut -c: N-M, N- (to the end of the line), -M (from the start), and N
+// for a single column. Columns count characters, not bytes.
+func compileFixed(spec string) (string, error) {
+	var spans []string
+	for _, item := range strings.Split(spec, ",") {
+		from, to := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			from, to = item[:i], item[i+1:]
+			if from == "" {
+				from = "1"
+			}
+			if to == "" {
+				to = "-1" // to the end.
+			}
+		}
+		f, err := strconv.Atoi(from)
+		if err != nil || f < 1 {
+			return "", fmt.Errorf("bad column %q in %q", item, spec)
+		}
+		t, err := strconv.Atoi(to)
+		if err != nil || (t < f && t != -1) {
+			return "", fmt.Errorf("bad column range %q in %q", item, spec)
+		}
+		spans = append(spans, fmt.Sprintf("{%d, %d}", f, t))
+	}
+	return fmt.Sprintf("golfFixedSplit([]golfSpan{%s})", strings.Join(spans, ", ")), nil
+}
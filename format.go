@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// compileFormat turns a --format template into a Go statement that prints
+// one record.
+//
+// Most formats only refer to fields and a few other record properties, as in
+// '{{.F 3}}\t{{.F 1}}'. These are compiled directly into a string
+// concatenation, which is much faster than executing the template. Other
+// formats are executed with text/template at run time; in that case fast is
+// false and the statement expects the program to have set up _golfFormat.
+func compileFormat(format string) (src string, fast bool, err error) {
+	t, err := template.New("format").Parse(format)
+	if err != nil {
+		return "", false, err
+	}
+	var parts []string
+	for _, n := range t.Tree.Root.Nodes {
+		part, ok := compileFormatNode(n)
+		if !ok {
+			return `_golfFormatOut.Reset()
+			if err := _golfFormat.Execute(&_golfFormatOut, golfRecord{}); err != nil {
+				Die("golf: --format: %v", err)
+			}
+			Print(_golfFormatOut.Bytes())`, false, nil
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		parts = []string{`""`}
+	}
+	return fmt.Sprintf("Print(%s)", strings.Join(parts, " + ")), true, nil
+}
+
+// compileFormatNode returns a Go string expression equivalent to n, if n is
+// simple enough.
+func compileFormatNode(n parse.Node) (string, bool) {
+	switch n := n.(type) {
+	case *parse.TextNode:
+		return strconv.Quote(string(n.Text)), true
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 || len(n.Pipe.Cmds) != 1 {
+			return "", false
+		}
+		args := n.Pipe.Cmds[0].Args
+		f, ok := args[0].(*parse.FieldNode)
+		if !ok || len(f.Ident) != 1 {
+			return "", false
+		}
+		switch {
+		case len(args) == 1 && f.Ident[0] == "Line":
+			return "Line", true
+		case len(args) == 1 && f.Ident[0] == "Filename":
+			return "Filename", true
+		case len(args) == 1 && f.Ident[0] == "LineNum":
+			return "strconv.Itoa(LineNum)", true
+		case len(args) == 2 && f.Ident[0] == "F":
+			if num, ok := args[1].(*parse.NumberNode); ok && num.IsInt {
+				return fmt.Sprintf("Field(%d)", num.Int64), true
+			}
+		}
+	}
+	return "", false
+}
@@ -2,4 +2,9 @@ module github.com/gaal/golf
 
 go 1.17
 
-require github.com/google/go-cmp v0.5.6
+require (
+	github.com/google/go-cmp v0.5.6
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
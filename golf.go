@@ -33,9 +33,18 @@ Try these on your command line.
   # Use prelude Die function (takes raw error or fmtstring+args)
   golf -l -e 'if data, err := os.ReadFile("MYFILE"); err != nil { Die(err) }; Print(len(data))'
 
+  # Dief exits with a status of your choosing.
+  golf -l -e 'if _, err := os.Stat("MYFILE"); err != nil { Dief(2, "no file: %v", err) }'
+
   # head MYFILE
   golf -p -e 'if LineNum == 10 {break File}' MYFILE
 
+  # tail -1 MYFILE. Eof reports whether this is the file's last line.
+  golf -ne 'if Eof() { Print(Line) }' MYFILE
+
+  # Lines from BEGIN CERT to END CERT, like perl -ne 'print if /a/../b/'.
+  golf -ne 'Between("BEGIN CERT", "END CERT") { Print(Line) }' MYFILE
+
   # -a mode (which implies -n) automatically splits input fields.
   # These can be accessed from the Fields slice, or using
   # the convenient Field accessor (supports 1-based and negative indexes).
@@ -44,6 +53,17 @@ Try these on your command line.
   # Prints "and". Could also say "Field(-2)".
   echo "tom, dick, and harry" | golf -ape 'Line = Field(3)'
 
+  # SetField changes a field and rebuilds Line, like awk's $3 = "x".
+  echo "tom, dick, and harry" | golf -ape 'SetField(-1, "sally")'
+
+  # Or, with --autojoin, -p prints Fields joined with OFS if the script
+  # changed them but not Line.
+  echo "tom, dick, and harry" | golf --autojoin -ape 'Fields[0] = "jane,"'
+
+  # NFields is the number of fields, like awk's NF. It stands for
+  # len(Fields), so it stays right if the script changes Fields.
+  golf -ale 'if NFields < 5 { Print(LineNum, "is short") }' data.txt
+
   # Input field separation uses strings.Fields by default.
   # Supply the -F flag to override (-F implicitly means -a and -n).
   # Can also be a regexp; see docs for prelude.GSplit.
@@ -51,6 +71,10 @@ Try these on your command line.
   # All users on the system.
   golf -F : -e 'Print(Field(1))' /etc/passwd
 
+  # --maxsplit N stops splitting at N fields, the last one holding the rest
+  # of the line, separators included. Values here may contain ":".
+  golf -F : --maxsplit 2 -le 'Print(Field(2))' config.txt
+
   # Convert TSV to CSV.
   golf -F '/\t/' -ple 'for i, v := range Fields { Fields[i] = strconv.Quote(v) }; Line = Join(Fields, ",")'
 
@@ -64,18 +88,82 @@ golf mimics perl's flags, but not perfectly so.
 You can cluster one-letter flags, so -lane means the same as
 -l -a -n -e as it does in perl.
 
+-l trims the input newline in line mode and makes Print add the output
+record separator, ORS. As in perl, -l may be followed by an octal character
+code to set ORS; this is handy for emitting NUL-terminated records:
+
+  find . -name \*.go | golf -l0pe ''
+
+-l=STR sets ORS to a literal string.
+
+-Z is short for -l0, for feeding xargs -0 like find -print0, so that names
+with spaces or newlines in them get through:
+
+  golf -Zne 'if strings.HasSuffix(Line, ".bak") { Print() }' list | xargs -0 rm
+
+-w collects warnings about accesses to undefined fields, failed GAtoi
+conversions and so on, and prints a summary of each kind, with its count and
+first occurrence, when the program exits. The summary is also available to -E
+blocks through WarningReport.
+
+-strict makes conversion helpers such as GAtoi die on input they can't
+convert, instead of quietly returning 0. Use it when a stray "N/A" in a column
+you are summing should be an error rather than a silent undercount.
+
 The -b and -E flags act as replacements for awk and Perl's BEGIN and END blocks.
 They are inserted before and after the -e snippet and only run once each. They
 are inserted in the same scope as the -e script, so variables declared in BEGIN
 are available for later blocks. -BEGIN and -END are aliases for -b and -E
 respectively.
 
+-bf and -Ef, or -BEGINFILE and -ENDFILE, are gawk's BEGINFILE and ENDFILE:
+in line mode, they run as each input file is opened, and when the last of its
+records was processed, with Filename set. -bf code shares the scope of the -e
+script, so it can declare per-file variables, and -Ef can read them. In -i
+mode, what they print goes into the file. A file left with continue File
+skips its -Ef blocks. Both imply -n.
+
+  golf -bf 'n := 0' -ne 'n++' -Ef 'Printf("%s: %d\n", Filename, n)' *.log
+  golf -i -bf 'Print("// Code generated. DO NOT EDIT.\n")' -pe '' *.go
+
+Code in -b may also register functions for line mode to call: OnLine(fn) on
+each record before the -e script, where returning false skips the record,
+OnFile(fn) as each input file is opened, and AtExit(fn) when the program
+exits. Hooks run in the order registered. They keep reusable behavior out of
+-e, for example:
+
+  golf -b 't := time.Now(); AtExit(func() { Warn("took %v", time.Since(t)) })' \
+    -ne '...' FILE
+
+An -e snippet may also take awk's PATTERN { ACTION } form, where ACTION runs
+only for records PATTERN selects. PATTERN is either /REGEXP/, matched against
+Line (or LineBytes, with -bytes), or a Go expression. Write \/ for a slash in
+REGEXP. Each -e holds at most one such pair.
+
+  golf -lne '/^ERROR/ { n++ }' -b 'n := 0' -E 'Print(n)' app.log
+  golf -lane 'LineNum > 1 && Field(3) != "0" { Print(Field(1)) }' data.txt
+
+--e64 CODE is -e for programs that pass golf its script through something that
+mangles quotes or newlines, such as Kubernetes args or Windows cmd: CODE is
+the script in standard base64, and may be gzip-compressed before encoding.
+Whitespace in CODE is ignored. -e and --e64 blocks may be mixed, and run in
+the order given.
+
+  golf --e64 "$(printf 'Print("hello, world")' | gzip | base64)"
+
 Line mode
 
 -n puts golf in line mode: each command-line argument is treated as a filename,
 which is opened in succession. Its name will populate the Filename variable.
 Lines are then scanned, populating the Line variable. Stdin is read instead of
-a named file if no filenames were provided.
+a named file if no filenames were provided, and where a filename is "-". Its
+Filename is "-", too. When no filenames were provided and stdin is a
+terminal, golf warns that it is waiting for input there, or with
+--no-terminal, fails straight away. A filename of the form fd:N reads the
+inherited file descriptor N, for programs that pass golf several streams.
+
+  golf -ne 'Print(Line)' header.txt - footer.txt
+  golf -ne 'Print(Filename, ": ", Line)' fd:3 fd:4 3<left.out 4<right.out
 
 These do the same thing as the cat example above:
 
@@ -85,18 +173,506 @@ These do the same thing as the cat example above:
   # Unix cat concatenates multiple files. This does, too.
   golf -ne 'Print(Line)' FILE1 FILE2 FILE2
 
-The File and Line labels can be continued/broken from to skip inputs.
+LineNum starts from 1 again in each file, like awk's FNR, while TotalLineNum
+counts the lines of all the inputs, like awk's NR.
+
+  golf -ne 'if LineNum == 1 { Print("==> " + Filename + " <==\n") }; Printf("%d %s", TotalLineNum, Line)' FILE1 FILE2
+
+On Windows, where cmd.exe leaves wildcards alone, golf expands filenames such
+as *.log itself. A name that matches nothing is kept as it is.
+
+Arguments after -- are for the one-liner itself, not input files: they are
+in ARGS. os.Args then holds them after the program name, instead of the
+input files, so package flag can parse them. Name an input file starting
+with - as ./-name.
+
+  golf -lne 'if strings.Contains(Line, ARGS[0]) { Print(Line) }' FILE -- needle
+  golf -le 'n := flag.Int("n", 3, ""); flag.Parse(); Print(*n * 2)' -M flag -- -n 21
+
+-s takes switches for the one-liner from among golf's own flags, like perl
+-s: arguments such as -name=value, or -name, whose names aren't golf flags,
+are set aside, and Opt(name, default) returns their values, "1" for a plain
+-name. Switches end where the input files start, or at --.
+
+  golf -s -lne 'if strings.Contains(Line, Opt("word", "ERROR")) { Print(Line) }' -word=WARN FILE
+
+ARGV holds the input files not yet opened, like perl's @ARGV. Code in -b,
+-bf or -e may change it, to add, drop or reorder inputs; line mode takes the
+next name off it whenever it is done with a file. Names added this way are
+opened as they are: -r doesn't expand them, --confirm-over doesn't count
+them, and URLs among them are only fetched if the command line had one too.
+
+  golf -b 'ARGV = append(ARGV, "extra.log")' -ne 'Print(Line)' main.log
+  golf -b 'sort.Strings(ARGV)' -ne 'Print(Line)' FILES...
+
+The File and Line labels can be continued/broken from to skip inputs. The
+prelude's Next, NextFile and Stop do the same without naming them: Next() is
+continue Line, NextFile() is continue File, and Stop() is break File. golf
+replaces their calls with those statements, so they only work directly in -e
+blocks, and NextFile and Stop in -bf and -Ef blocks, not in function literals.
+
+  golf -ne 'if strings.HasPrefix(Line, "#") { Next() }; Print(Line)' FILE
+  golf -ne 'if LineNum > 5 { NextFile() }; Print(Line)' *.txt  # head -5 of each
+
+Lines may be of any length. Use -maxline N to fail with an error on lines
+longer than N bytes instead, for example to guard against binary input.
+
+Lines end with "\n", "\r\n", or a lone "\r" as in old Mac files, whichever
+comes first, so files with mixed line endings are split as they look. Without
+-l, Line holds the input line exactly as read, including its terminator, which
+is also available in the LineEnding variable ("\n", "\r\n", "\r", or "" on a
+final unterminated line). So golf -pe '' reproduces its input byte-for-byte.
+With -l, the terminator is trimmed from Line and Print adds ORS instead.
+
+On Windows, console input is typed as UTF-16 and arrives as UTF-8 with "\r\n"
+line endings, the same as a text file. Ctrl-Z at the start of a line ends the
+input.
 
 -p implies -n and adds a "Print(Line)" call after each line. So you can
 even say:
 
   golf -pe '' FILE1 FILE2 FILE3
 
+Directories
+
+-r implies -n, and reads the regular files under directories named as inputs,
+recursively and in lexical order, like grep -r. Symbolic links found on the
+way are not followed. --include GLOB only reads the files whose name matches
+GLOB, and --exclude GLOB skips the files and directories whose name matches
+it; both may be repeated. Each file is still its own input, with its own
+Filename, so -r works with -i too, after confirmation; see --yes.
+
+  golf -r --include '*.go' --exclude vendor -ipe 'Line = strings.ReplaceAll(Line, "ioutil.ReadFile", "os.ReadFile")' .
+
+--skip-binary skips inputs that look binary, like grep -I: those with a NUL
+byte in their first 64KiB. A warning names each file skipped. It applies to
+all inputs, not only those found by -r, and guards -i against mangling an
+image or an executable. Gzip-compressed files edited in place are not
+skipped, since golf edits them decompressed.
+
+Parallel files
+
+-P N processes named input files with up to N instances of the one-liner
+running at once, each on a single file, to use more than one core on jobs
+that are independent across files. Each instance's output is held back and
+printed after that of the files before it, so the output is the same as
+without -P.
+
+Since every instance is a separate process, -b and -E blocks run once per
+file, and variables are not shared between files. golf exits with the status
+of the first file whose instance failed.
+
+  golf -P 8 -ne 'if strings.Contains(Line, "ERROR") { Print() }' logs/*.log
+
+Parallel lines
+
+-Pmap N runs the -e body on up to N lines at once, for CPU-heavy per-line
+work such as regexp extraction or hashing. Lines are read in batches, and the
+output of each batch is written in input order, so -Pmap -p prints the same
+as -p would.
+
+The body runs in a function of its own, with private copies of Filename,
+LineNum, TotalLineNum, Line, LineEnding and Fields, and versions of Field, Col,
+SetField, Print and Printf that use them. Use return or Next(), not continue
+Line, to skip a line; under -p, it isn't printed then. The body must not change
+variables shared between lines, such as those declared in -b, nor write to
+os.Stdout directly. -Pmap can't be combined with -bytes, --format or --project.
+
+  golf -Pmap 8 -lpe 'Line = fmt.Sprintf("%x %s", sha256.Sum256([]byte(Line)), Line)' -M crypto/sha256 -M fmt big.txt
+
+Output buffering
+
+Print and Printf write to CurOut through a buffer, which is flushed when
+switching files in -i mode, by Die, and when the program ends. This is much
+faster than writing each line directly. When stdout is a terminal, or with the
+-flush flag, output is flushed after every call instead, which is what you
+want when golfing into a live pipe:
+
+  tail -f access.log | golf -flush -ne 'if strings.Contains(Line, " 500 ") { Print() }' | other
+
+Call Flush before os.Exit, and when mixing Print with direct writes to
+os.Stdout such as fmt.Println.
+
+SetSink switches Print and Printf to another OutputSink, such as one from
+FileSink, or back to StdoutSink. The switch lasts across input files, except
+in -i mode, where each file's replacement becomes the output again. For
+example, to split a log by its first field:
+
+  golf -ane 'SetSink(FileSink(Field(1) + ".log")); Print()' app.log
+
+-o FILE sends standard output to FILE for the whole run, truncating it first,
+and -oa FILE appends to it. Unlike shell redirection, it works the same in
+wrappers that don't go through a shell, and errors writing the output name
+the file. It applies with -P, golf remote and --in-container too.
+
+With -oa, output is written a whole number of records, ending with ORS or a
+newline, at a time, rather than in buffer-sized pieces that may end mid-line.
+So several golf runs can add to the same log or report at once, as appending
+with >> can't promise, without splitting each other's lines. Flush writes out
+a partial record, though, and -P output is copied to FILE in any size pieces.
+
+--tee FILE writes the output to FILE as well as to stdout, like piping golf to
+tee, but keeping golf's exit status. On a terminal, output still shows up as
+it is printed. A failure to write FILE, such as a broken pipe when FILE is a
+pipe whose reader quit, is reported at the end, and makes golf exit 1 if it
+would otherwise have succeeded.
+
+  golf --tee report.txt -lane 'if GAtoi(Field(3)) > 100 { Print() }' data.txt
+
+  golf -oa daily.log -ne 'if strings.Contains(Line, "ERROR") { Print() }' app.log
+
+Validation
+
+--validate EXPR evaluates the Go boolean expression EXPR for each record,
+after autosplitting and before the -e script. Records for which it is false
+are rejected: they are skipped, and written to stderr, or to the file named by
+--errors-to, prefixed by their filename, line number and the failed
+expression. The good records go through the normal pipeline.
+
+  golf -F , --validate 'len(Fields) == 5 && Field(3) != ""' --errors-to bad.txt -pe '' data.csv > good.csv
+
+Formatted output
+
+--format TEMPLATE prints each record using a text/template, so pure
+reformatting needs no code at all. It implies -l and -a. The template can
+use {{.F n}}, which is Field(n), {{.Line}}, {{.LineNum}}, {{.Filename}}, and
+{{.Fields}}. Templates using only the first four (and plain text) are
+compiled to Go; others are executed by text/template for each record.
+
+  golf -F : --format '{{.F 1}} uses {{.F -1}}' /etc/passwd
+
+An -e script, if any, runs before the record is printed, and can skip it with
+continue Line.
+
+Print shows floats in Go's shortest form, such as 0.3333333333333333 or
+2e+21. SetPrecision(n), typically in -b, makes it show n decimals instead,
+like awk's OFMT; conversions with strconv or fmt.Sprint are not affected.
+Round(x, n), Floor(x, n) and Ceil(x, n) round x to n decimal places.
+
+  golf -b 'SetPrecision(2); sum := 0.0' -E 'Print(sum)' \
+    -lane 'x, _ := strconv.ParseFloat(Field(3), 64); sum += x'
+
+GTime parses a timestamp in whichever of the common forms it is in: RFC 3339
+and its variants, common log and syslog times, RFC 1123, date(1) output, and
+epoch seconds or milliseconds, among others. Numbers of fewer than 9 digits,
+such as 20240102 or 2024, are dates or years rather than epoch times. It
+returns the zero time for anything else, with a warning under -w. Epoch(t) and
+FromEpoch(n) convert between times and seconds since the Unix epoch.
+
+  golf -lane 'if time.Since(GTime(Field(1))) < time.Hour { Print() }' app.log
+  golf -lane 'Print(GTime(Field(2)).Format(time.RFC3339), Field(3))' events.log
+
+Projection
+
+--project LIST prints only the listed fields, joined by OFS, like cut -f
+but with reordering and duplication allowed. It implies -l, -a and -p, and is
+applied after the -e script. LIST is a comma-separated list of field numbers
+N, and ranges N-M, N- and -M. Field numbers past the end of a record yield
+empty fields, or the value of --default-field; ranges stop at the last field.
+
+  # awk '{print $3, $1}'
+  golf --project 3,1 FILE
+
+  golf -F : --project 7,1,3- /etc/passwd
+
+  # Make ragged data rectangular.
+  golf --project 1,2,3 --default-field NA FILE
+
+JSON lines
+
+-j parses each line as JSON, for logs and other JSON-lines data. J holds the
+line as a map if it is a JSON object, and JQ(PATH) returns the value at a
+path such as "items[0].id" in it, or nil. Numbers come as json.Number, which
+prints as written. JQS(PATH) returns the value as a string instead, "" for
+nil. A line that isn't JSON leaves J nil, and is counted as a warning under
+-w.
+
+  golf -j -lne 'if JQ("level") == "error" { Print(JQ("ts"), JQ("msg")) }' app.log
+  golf -j -b 'sum := 0' -lne 'sum += GAtoi(JQS("bytes"))' -E 'Print(sum)' app.log
+
+Logs in logfmt, the other common structured format, are parsed with KV,
+which returns the key=value pairs in Line, or in its argument, as a map;
+quoted values are unquoted.
+
+  golf -lne 'if kv := KV(); kv["level"] == "error" { Print(kv["ts"], kv["msg"]) }' app.log
+
+ToJSON and ToJSONPretty encode any value as JSON, and PrintJSON and
+PrintJSONPretty print it, ending with a newline even without -l; they need
+neither -j nor encoding/json, and make it easy to write JSON lines. FromJSON
+decodes a JSON document into a value, typically a struct of the fields a
+script cares about, and FromJSONMap into a map; invalid JSON is fatal, or in
+-w mode a warning, leaving the zero value.
+
+  golf -F : -lne 'PrintJSON(map[string]interface{}{"user": Field(1), "uid": GAtoi(Field(3))})' /etc/passwd
+  golf -b 'var ev struct{ User string; Ms int }' -lane 'FromJSON(Field(2), &ev); Print(ev.User, ev.Ms)' events.log
+
+--json-doc instead reads each input as whole JSON documents, such as API
+responses, which needn't be on one line. If an input is an array, each of its
+elements is a record, read one at a time, and LineNum counts them; otherwise,
+each document is one. Records are set up as with -j, and Line holds them
+compacted to one line, so that -p turns a JSON array into JSON lines. This is
+the json record source, for --source.
+
+  curl -s https://api.example.com/users | golf --json-doc -lne 'Print(JQS("login"))'
+  golf --json-doc -pe '' dump.json > dump.jsonl
+
+YAML
+
+--yaml reads each input as a stream of YAML documents, separated by --- lines,
+as in Kubernetes manifests, and takes each document as a record. Like
+--json-doc, it converts them to JSON on one line, and sets up J and JQ as -j
+does. ToYAML encodes a value as a YAML document, and PrintYAML prints it after
+a --- line, so that printing documents, changed or not, writes a YAML stream;
+FromYAML decodes YAML as FromJSON does JSON. These use gopkg.in/yaml.v3, which
+the go command fetches as for -M the first time. J is written like the document
+it was read from, with its comments, key order and styles, but for what the
+script changed; keys it added come last, sorted. Other values have their keys
+sorted.
+
+  golf --yaml -lne 'Print(JQS("kind"), JQS("metadata.name"))' manifests.yaml
+  golf --yaml -lne 'if JQS("kind") == "Deployment" { J["metadata"].(map[string]interface{})["namespace"] = "prod" }; PrintYAML(J)' manifests.yaml
+
+XML
+
+--xml ELEMENT reads XML inputs as a stream, and takes each element named
+ELEMENT, by its local name, as a record, however large the whole document is.
+Line holds the element as it was written, and X the element decoded into a
+map: attributes are under "@" and their name, and child elements under their
+name, as a string if they only hold text, a map like X otherwise, or a
+[]interface{} of those if they repeat; other text is under "#text". Elements
+named ELEMENT inside one are part of it. --xml can't be combined with -Pmap.
+
+  golf --xml page -lne 'Print(X["title"])' enwiki-pages-articles.xml
+  golf --xml item -lne 'if X["@lang"] == "fr" { Print() }' export.xml
+
+Log formats
+
+ParseAccessLog parses a line of a web server access log, in the common or
+combined log format of Apache and nginx, into an *AccessLog, with the client
+IP, Time, Method, Path, Status, Bytes, Referer and UserAgent. It returns nil
+for other lines, which count as warnings under -w.
+
+  golf -b 'n := map[int]int{}' -lne 'if r := ParseAccessLog(Line); r != nil { n[r.Status]++ }' -E 'Print(n)' access.log
+  golf -lne 'if r := ParseAccessLog(Line); r != nil && r.Status >= 500 { Print(r.Time.Format(time.Kitchen), r.Path) }' access.log
+
+ParseSyslog likewise parses a syslog message, as in the files in /var/log, in
+the BSD format of RFC 3164 or in that of RFC 5424, into a *Syslog, with its
+Time, Host, Tag (the program), PID and Msg, and the Priority, Facility and
+Severity if it has them. BSD timestamps have no year, and are taken to be
+within the last year.
+
+  golf -lne 'if m := ParseSyslog(Line); m != nil && m.Tag == "sshd" { Print(m.Time.Format(time.Stamp), m.Msg) }' /var/log/auth.log
+
+Fixed-width columns
+
+--fixed LIST splits lines into Fields by column rather than at separators, for
+column-aligned text such as reports and the output of ps, where fields may
+hold spaces. LIST is a comma-separated list of 1-based, inclusive column
+ranges, like cut -c: N-M, N- (to the end of the line), -M and N. Columns count
+characters, not bytes. Blanks around each field are trimmed, and columns past
+the end of a short line give empty fields.
+
+  golf --fixed 1-10,11-20,21- -lane 'Print(Field(3))' report.txt
+
+CSV
+
+--csv splits each record into Fields the way encoding/csv reads it, instead
+of at every -F: quoted fields may hold the delimiter, doubled quotes, and line
+breaks, in which case a record spans several lines (LineNum counts records).
+The delimiter is a comma, or -F if that is a single character, such as ';' or
+'\t'. Print with no arguments, and so -p, prints the record as read, unless
+the script changed Fields: then they are written out anew, quoted as needed.
+Field(0) and --project join fields the same way.
+
+  golf --csv -pe 'Fields[2] = strings.ToUpper(Fields[2])' data.csv
+  golf --csv -F '\t' --project 3,1 data.tsv
+
+--tsv instead reads the common TSV convention, as written by databases: one
+line is one record, fields are separated by tabs and can't be quoted, so tabs,
+line breaks and backslashes in them are escaped as \t, \n (and \r) and \\.
+Fields are unescaped, and Print and -p escape them again if the script changed
+them. Unlike -F '\t', this round-trips any field.
+
+  golf --tsv --project 2,1 dump.tsv
+
+Headers
+
+-H takes the first line of each input as a header rather than a record: it is
+split into the Header variable, and the -e script doesn't see it, though -p
+prints it. Col("name") then returns the field in the column of that name, or
+DefaultField if the record is too short; a name missing from the header is
+fatal. LineNum counts the header line. -H works with -F, --csv and --tsv, and
+implies -a.
+
+  golf -H --csv -lne 'if Col("status") == "active" { Print(Col("email")) }' users.csv
+  golf -H --csv -lane 'sum += GAtoi(Col("bytes"))' -b 'sum := 0' -E 'Print(sum)' log.csv
+
+Profiling
+
+--hotspots reports, at the end of line mode, how the loop's time was split
+between reading input, splitting it into fields (and filtering), printing,
+and the -e script itself. One line in 64 is timed. If "script" dominates,
+look to your regexps before blaming golf.
+
+File limits
+
+--max-file-size SIZE skips regular input files larger than SIZE bytes, which
+may have a K, M, G or T suffix. --per-file-timeout DURATION stops processing
+an input file once it has taken that long; the limit is checked between
+lines. Both warn about the files they skip. In -i mode, the unprocessed rest
+of a timed-out file is copied to the edited file unchanged. With
+--on-limit=abort, a file over either limit is fatal instead.
+
+  golf --max-file-size 1G --per-file-timeout 1m -ne 'if strings.Contains(Line, "panic:") { Print(Filename, ": ", Line) }' $(find /var/log -type f)
+
+Byte mode
+
+-bytes is a variant of line mode for crunching large inputs, where converting
+each line to a string can dominate the run time. It sets LineBytes instead of
+Line, and FieldsBytes instead of Fields under -a. These point into golf's input
+buffer and are only valid until the next line is read. Print accepts []byte
+and, with no arguments, prints LineBytes.
+
+  golf -bytes -ne 'if bytes.Contains(LineBytes, []byte("ERROR")) { Print() }' big.log
+
+Record sources
+
+--source NAME reads records with the named RecordSource instead of lines. The
+nul source reads NUL-terminated records, as written by find -print0. Records
+are given a "\n" LineEnding in place of their own terminator, so -l strips it
+and -p prints one record per line. The json source reads JSON documents, and
+the elements of arrays, as compact one-line records; see --json-doc. More
+sources can be registered with RegisterSource, from a -b block or from a
+package imported with -M; SplitSource builds one from a bufio.SplitFunc.
+--source can't be combined with -mmap or --since-last, nor, as records lose
+their own terminators, with -i, -I or -O.
+
+  find . -name '*.go' -print0 | golf --source nul -lne 'Print(Line)'
+  golf -b 'RegisterSource("words", func() RecordSource { return SplitSource(bufio.ScanWords) })' --source words -lne 'n[Line]++' -b 'n := map[string]int{}' -E 'Print(n)' README
+
+Incremental runs
+
+--since-last JOB remembers how far golf got into each input file, and on the
+next run with the same JOB name, only feeds it the lines added since, like
+logtail. LineNum carries on counting from the last run. A file that shrank,
+or whose start changed, for example because it was rotated, is read from the
+beginning. A final line without a terminator is left for the next run, as
+it may still be being written. The state is kept in $GOLF_STATE_DIR, by
+default the golf directory in the user cache directory, and saved when line
+mode finishes; a run that dies is repeated in full the next time. Standard
+input is not tracked. It can't be combined with -P, whose instances would
+race on the state.
+
+  # From cron, every few minutes:
+  golf --since-last errors -ne 'if strings.Contains(Line, "ERROR") { Print() }' /var/log/app.log | mail -E -s errors ops
+
+Following files
+
+-tail reads the inputs as usual, and then, like tail -F, waits for lines to
+be appended to the last one, and feeds them to the -e script as they come.
+The file is checked for more a few times a second, and output is flushed
+while waiting. If the file is replaced, as when a log is rotated, golf reads
+what was left in the old one, and goes on with the new one from its start; if
+it is truncated, golf starts over. Stop it with an interrupt: it then runs -E
+blocks and exits as usual. --timeout also stops it.
+
+  golf -tail -ne '/ERROR/ { Print(Line) }' /var/log/app.log
+
+--watch runs the one-liner, and then, like entr, runs it again each time one
+of the input files changes, until interrupted. The program is only compiled
+once. A line on standard error, naming the changed files, separates the runs.
+Files that appear under -r directories after golf started are not noticed.
+
+  golf --watch -lane 'sum += GAtoi(Field(2))' -b 'sum := 0' -E 'Print(sum)' expenses.txt
+
+Listening for input
+
+--listen ADDR makes the one-liner a network line filter: instead of reading
+files, it listens on ADDR, a TCP [HOST]:PORT or unix:PATH for a unix socket,
+and reads each connection it accepts in turn as an input, with Filename set
+to the peer's address. Output goes to standard output, as usual, and is
+flushed between connections. The address is reported on standard error; give
+port 0 to have one picked. Stop it with an interrupt: -E blocks then run.
+
+Connections are read one at a time, so that lines from different clients
+don't interleave, and the next client waits until the current one closes its
+connection; with netcat, use -N or -q 0 so that it does. A client that sends
+nothing for --listen-idle, a minute by default, is disconnected, with a
+warning, so that one that never closes can't hold up the others for long.
+
+  golf --listen :5140 -ne '/ERROR/ { Print(Filename, Line) }'
+  echo "ERROR disk full" | nc -N localhost 5140
+
+URLs
+
+In line mode, inputs that are http:// or https:// URLs are fetched, and their
+bodies read like files, with Filename set to the URL. A response with a status
+other than 2xx is fatal. Fetching honors Ctx, so --timeout and interrupts stop
+it.
+
+  golf -ne 'Print(Filename, ": ", Line)' https://example.com/a.txt local.txt https://example.com/b.txt
+
+Archives
+
+In line mode, tar archives (.tar, .tar.gz, .tgz) and zip archives (.zip)
+named as inputs stand for the regular files they contain, in archive order.
+Filename is then archive::member, for example logs.tgz::app/server.log.
+Archives are not expanded in -i mode.
+
+  golf -ne 'if strings.Contains(Line, "OOM") { Print(Filename, ": ", Line) }' support-bundle.tar.gz
+
+Memory-mapped input
+
+-mmap maps regular input files into memory, and takes lines directly from the
+mapping, which saves read system calls and copies on very large files. It is
+most effective with -bytes, where LineBytes then points into the mapping and
+no per-line copy is made at all. Pipes, terminals and other inputs that can't
+be mapped are read normally, as are all inputs on Windows. Don't truncate a
+file while golf has it mapped: the one-liner would crash with SIGBUS.
+
+  golf -mmap -bytes -ne 'if bytes.HasPrefix(LineBytes, []byte("ERROR")) { n++ }' -b 'n := 0' -E 'Print(n)' huge.log
+
+Record filtering
+
+--match-file LIST and --exclude-file LIST filter records in line mode before
+the -e snippet sees them, like grep -f and grep -v -f. LIST holds one pattern
+per line. Lines of the form /pat/ are regexps; all others are fixed strings,
+which are matched together in a single pass over the record, so lists with
+many thousands of entries are cheap. Blank lines are ignored.
+
+  golf --exclude-file spammers.txt -pe '' mail.log
+
+-m REGEXP passes only the records matching REGEXP, in the syntax of package
+regexp, to the -e snippet. With no -e at all, golf prints the matching lines,
+like grep, prefixed with their Filename when reading more than one file. It
+honors -l and -Z. golf's -i edits files in place rather than ignoring case, as
+grep's does, so it is refused there: start REGEXP with (?i) instead. With an
+-e snippet, -i keeps only the matching lines.
+
+  golf -m 'ERROR|WARN' *.log
+  golf -m '^#' -le 'Print(Line[1:])' config
+
+--lines FROM:TO passes only the lines numbered FROM to TO, inclusive, to the
+-e snippet, like sed -n 'FROM,TOp'. LineNum still counts every line. Either
+end may be left out, meaning the first or the last line, and negative numbers
+count back from the last line, -1. A single number selects one line. --lines
+may be repeated, and applies to each input file separately. Once past the
+last range, the rest of the file isn't read. Ranges counting from the end
+need the number of lines first, so such files are read into memory; they
+can't be combined with -mmap or --source. --lines implies -n, and can't be
+combined with -i.
+
+  golf --lines 100:200 -pe '' big.log
+  golf --lines -10: -pe '' big.log       # like tail
+  golf --lines :3 --lines -1 -pe '' *.csv  # heads and last lines
+
 In-place mode
 
--i causes edits to happen in-place: each input file is opened, unlinked, and
-the default output (Print, Printf) is sent to a new file with the original's
-name.
+-i causes edits to happen in-place: the default output (Print, Printf) for
+each input file is sent to a temporary file next to it, which is renamed over
+the original once the whole file was processed. Readers never see a partly
+written file, and if the one-liner dies or exits before it is done with a
+file, that file is left unchanged.
 
 -I does the same, but keeps a backup of the original, according to the same
 renaming rules that perl -i uses:
@@ -104,15 +680,192 @@ renaming rules that perl -i uses:
   - if the replacement contains no "*", it is used as a literal suffix.
   - otherwise, each occurrence of * is replaced with the original filename.
 
-Like perl, we do not support crossing filesystem boundaries in backups, nor
-do we create directories.
+A replacement ending in "/" names a directory to keep backups in, the same as
+DIR/*. Directories that backups need are created, so the tree being edited is
+kept free of them:
+
+  golf -I backups/ -pi -e 'Line = strings.ReplaceAll(Line, "foo", "bar")' src/*.go
+
+Like perl, we do not support crossing filesystem boundaries in backups.
 
-Unlike perl, in-place backup uses the -I flag, not the -i flag with an argument.
-Go's standard flag library does not support optional flags. So these don't act
-the same:
+The new file gets the original's permission bits and, on Unix, where the
+system allows it, its owner and group; only root may give files away. Its
+modification time is that of the edit, unless --keep-mtime is given, which
+keeps the original's, for build systems and backups that go by mtime.
 
-  perl -ib FILE1 FILE2  # Runs the perl program in FILE1 with backup to FILE2.
-  golf -ib WORD FILE    # Runs WORD in BEGIN stage, FILE will end up truncated.
+An input that is a symbolic link is replaced by a regular file holding the
+edit, leaving the link's target alone, as with perl -i. --symlinks=follow edits
+the target instead, keeping the link, which suits symlinked config files;
+backups then go next to the target. --symlinks=refuse skips such inputs with
+an error.
+
+A file whose edit leaves it byte-for-byte the same is not replaced at all, and
+gets no backup, so no-op runs over a large tree keep mtimes, inodes and hard
+links.
+
+Compressed files are edited in place, too: -i reads gzip-compressed input
+decompressed, and compresses its replacement, keeping the original gzip
+header. Backups are copies of the compressed original.
+
+  golf -pi -e 'Line = strings.ReplaceAll(Line, "10.0.0.1", "gateway")' access.log.gz
+
+-O PATTERN works like -i, but writes each file's output to a new file named by
+PATTERN, following the same rules as -I, and leaves the original alone. This
+turns every input into a transformed sibling, or a copy in another directory,
+which is created if needed. An existing output file is overwritten, unless it
+already holds the same bytes.
+
+  golf -O '*.csv' -F '\t' -lape 'Line = strings.Join(Fields, ",")' *.tsv
+  golf -O 'out/*' -pe 'Line = strings.ToUpper(Line)' *.txt
+
+--dry-run previews an in-place edit: instead of replacing each file, golf
+prints a unified diff of the change to stdout, and leaves the file, and any
+backup or quarantine file, alone. The diff can be reviewed, and then applied
+by running the same command without --dry-run, or with patch -p0.
+
+  golf --dry-run -pi -e 'Line = strings.ReplaceAll(Line, "http://", "https://")' *.html | less
+
+--atomic-batch makes -i all-or-nothing across files: the temporary files are
+only renamed into place, with backups if -I was given, once all inputs were
+processed. If the one-liner dies or exits early, the staged files are discarded
+and the inputs are left unchanged. A count of staged and committed files is
+printed to stderr, unless -q. It can't be combined with -P, whose instances
+would each commit their own files.
+
+  golf -I .bak --atomic-batch -pe 'Line = strings.ReplaceAll(Line, "foo", "bar")' $(find . -name '*.conf')
+
+Records rejected by --validate are not written to the edited file. So that
+they are not lost, -i saves their originals, byte-for-byte, to a quarantine
+file next to each input, named FILE.rejected by default. --quarantine PATTERN
+changes the name, following the same rules as -I. The file is only created if
+some record of FILE was rejected.
+
+  golf -i --validate 'len(Fields) == 3' -ape 'Fields[2] = "x"; Line = Field(0)' *.txt
+
+Before editing more than 20 files in place, or any found by -r, golf prints a
+summary such as "editing 412 files in place, backups: none" and asks for
+confirmation on the terminal. --yes skips the question, which is required when
+stdin is not a terminal; --confirm-over N changes the threshold.
+
+As in perl, the backup rule may also be attached to -i, as in -i.bak or
+-pi.orig, unless what follows -i is more flags, as in -ipe or -ilane: boolean
+flags, optionally ending with -e. So a cluster that would take an argument
+after -i, such as -ib, means -i with the extension "b", as it does in perl.
+-I EXT is the same as -iEXT.
+
+Exit status
+
+golf exits with the exit status of the one-liner. The one-liner exits with
+ExitCode when it ends, 0 unless the script changes it; Exit(n) exits right
+away. Both flush output first. So a grep-like one-liner can report whether
+anything matched:
+
+  golf -b 'ExitCode = 1' -ne 'if strings.Contains(Line, "needle") { Print(); ExitCode = 0 }'
+
+GErr wraps an error with context. Errors made by it and passed to Warn are
+counted in ErrCount, as are golf's own, such as failures to write output; other
+errors passed to Warn are only printed, so that a script can warn about bad
+input and carry on. If ErrCount is not 0, the one-liner prints it when it ends,
+and exits 1 rather than 0. Die(err) exits with the exit status of a failed
+command, if err is or wraps an *exec.ExitError:
+
+  golf -M os/exec -ne 'if err := exec.Command("gzip", "-t", Line).Run(); err != nil { Warn(GErr(err, "%s", Line)) }'
+
+-q silences golf's own non-fatal diagnostics, such as the notes on files
+skipped for --max-file-size, the reminder that stdin is a terminal and the
+count of errors above, but not what the script passes to Warn, nor fatal
+errors. Errors still count in ErrCount, so -q doesn't change the exit status.
+
+If the one-liner is killed by SIGINT, SIGTERM or SIGHUP, golf kills itself
+with the same signal after cleaning up. Failure to build the one-liner exits 1.
+
+Build directory
+
+golf builds the one-liner in a fresh directory under the system temp dir,
+and removes it when done. To put it elsewhere, for example when /tmp is small
+or not on tmpfs, give --tmpdir DIR or set GOLF_TMPDIR. The go command's own
+scratch files then go there too, unless GOTMPDIR is set. -k keeps the
+directory, and prints its path as "golf: keeping build dir: PATH".
+
+The one-liner is compiled together with the source of package prelude. Parts of
+it that only some flags or scripts need are features, embedded only as
+required: mmap for -mmap, pmap for -Pmap, since for --since-last, source for
+--source or scripts that refer to record sources, url for URL inputs, owner for
+-i on Unix, diff for --dry-run, tail for -tail, listen for --listen, csv and
+tsv for --csv and --tsv, json for -j, yaml for --yaml, xml for --xml, lines for
+--lines, columns for --fixed and --project, format for --format, hotspots for
+--hotspots, matchfile for --match-file and --exclude-file, and json, yaml,
+time, kv, flipflop, sketch, multimatch, accesslog and syslog for scripts that
+use the JSON and YAML helpers, GTime and Epoch, KV, Between and FlipFlop,
+Bloom, TopK and HLL, MultiMatcher, ParseAccessLog and ParseSyslog. Features
+that use others bring them along. --feature NAME embeds one anyway, for scripts
+that golf can't tell need it.
+
+Cancellation
+
+Ctx is a context.Context for long-running one-liners, such as ones making
+network calls, to pass on. If the script refers to Ctx, the first SIGINT,
+SIGTERM or SIGHUP cancels it instead of killing the one-liner, and line mode
+stops reading input; the program then runs its -E blocks and exits, reporting
+the signal. A second signal kills it outright. --timeout DURATION cancels Ctx,
+and stops line mode, after that long.
+
+  golf --timeout 30s -M net/http -lne 'req, _ := http.NewRequestWithContext(Ctx, "HEAD", Line, nil); if r, err := http.DefaultClient.Do(req); err == nil { Print(r.Status, " ", Line) }' urls.txt
+
+Profiles
+
+--profile safe restricts golf for shared environments, where operators run
+one-liners over data they must not damage by accident: in-place editing,
+fetching URLs, -g, --in-container, golf remote, --listen, and -M imports of
+packages such as os/exec, net and syscall are refused, as are scripts that use
+syscall, which golf always imports. A wrapper script can enforce it by setting
+GOLF_PROFILE, which overrides the flag. This is not a sandbox: the one-liner
+can still call os.Remove, for example.
+
+  GOLF_PROFILE=safe golf -ne 'Print(Line)' /srv/data/*.txt
+
+Remote execution
+
+golf remote HOST runs the one-liner on HOST, where the data is, without Go
+installed there: golf asks HOST for its platform over ssh, cross-compiles for
+it, copies the program over, runs it with the remaining arguments, and removes
+it again. Output and the exit status come back through ssh. Input files are
+named as they are on HOST, relative to the login directory. Stdin carries the
+program over, so it isn't forwarded: "-" and fd:N inputs, line mode without
+files, -r and -P are refused. ssh options come from ~/.ssh/config.
+
+  golf remote web1 -ne 'if strings.Contains(Line, " 502 ") { n++ }' -b 'n := 0' -E 'Print(n)' /var/log/nginx/access.log
+
+Containers
+
+--in-container IMAGE builds the one-liner as a static Linux program, and runs
+it in a container of IMAGE, for a reproducible userland, or one with the tools
+and files a job expects. The current directory is mounted at the same path and
+is the working directory there, so relative input names work as they do
+outside; other paths are the container's own. Stdin is passed through, and the
+program runs as the current user, so files edited with -i keep their owner. The
+container is run with docker, or with the command named by
+GOLF_CONTAINER_ENGINE, such as podman. fd:N inputs and -P are refused.
+
+  golf --in-container debian:12 -e 'b, _ := os.ReadFile("/etc/debian_version"); Print(string(b))'
+
+Audit log
+
+If GOLF_AUDIT_LOG names a file, golf appends a JSON record of each run to it:
+the time, user, host and directory, the command line, the code snippets, the
+input files, the exit status and the duration. golf refuses to run if it
+can't open the log.
+
+  GOLF_AUDIT_LOG=/var/log/golf.log golf -i -pe 'Line = strings.TrimSpace(Line)' /srv/data/*.txt
+
+Troubleshooting
+
+golf doctor checks that one-liners can be built and run: that the go command
+is there and recent enough, that the build cache and temp dir are writable,
+and whether goimports, for -g, and the module proxy, for -M of modules outside
+the standard library, are available. It then builds and runs two small
+one-liners. Each problem is reported with a hint on fixing it, and golf doctor
+exits 1 if one-liners can't run at all.
 
 No script mode
 
@@ -124,40 +877,118 @@ convenience, see if package Prelude contains anything useful.
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"go/format"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gaal/golf/prelude"
 )
 
 var (
-	rawSrc     = stringList("e", nil, "one-liner code")
-	beginSrc   = stringList("b", nil, "code block(s) to insert before record processing")
-	endSrc     = stringList("E", nil, "code block(s) to insert after record processing")
-	flgN       = flag.Bool("n", false, "line mode")
-	flgL       = flag.Bool("l", false, "automate line-end processing. Trims input newline and adds it back on -p")
-	flgP       = flag.Bool("p", false, "pipe mode. Implies -n and prints Line after each iteration")
-	flgG       = flag.Bool("g", false, "run goimports")
-	flgA       = flag.Bool("a", false, "autosplit Line to Fields. Implies -n")
-	flgF       = flag.String("F", " ", "field separator. Implies -a and -n. See docs for GSplit")
-	inplace    = flag.Bool("i", false, "in-place edit mode. See package doc for in-place edit")
-	inplaceBak = flag.String("I", "", "in-place edit mode, with backup. See package doc for in-place edit")
-	flgKeep    = flag.Bool("k", false, "keep tempdir, for debugging")
-	warnings   = flag.Bool("w", false, "print warnings on access to undefined fields and so on")
-	goVer      = flag.String("goVer", "1.17", "go version to declare in go.mod file")
-	help       = flag.Bool("h", false, "print usage help and exit")
-	modules    = stringList("M", nil, "modules to import. May be repeated")
+	rawSrc      = stringList("e", nil, "one-liner code")
+	beginSrc    = stringList("b", nil, "code block(s) to insert before record processing")
+	endSrc      = stringList("E", nil, "code block(s) to insert after record processing")
+	beginFile   = stringList("bf", nil, "code block(s) to run as each input file is opened, in line mode. Implies -n")
+	endFile     = stringList("Ef", nil, "code block(s) to run when done with each input file, in line mode. Implies -n")
+	flgN        = flag.Bool("n", false, "line mode")
+	flgL        = lineEnd("l", "automate line-end processing. Trims input newline and adds ORS on Print. -l0 sets ORS to NUL")
+	flgZ        = flag.Bool("Z", false, "terminate Print's records with NUL, for xargs -0. Implies -l, and overrides its ORS")
+	flgP        = flag.Bool("p", false, "pipe mode. Implies -n and prints Line after each iteration")
+	flgS        = flag.Bool("s", false, "take -name and -name=value arguments after golf's flags as switches for Opt, like perl -s. See package doc")
+	flgG        = flag.Bool("g", false, "run goimports")
+	flgA        = flag.Bool("a", false, "autosplit Line to Fields. Implies -n")
+	flgF        = flag.String("F", " ", "field separator. Implies -a and -n. See docs for GSplit")
+	flgJ        = flag.Bool("j", false, "parse each line as JSON into J, for JQ. Implies -n. See package doc")
+	flgJSONDoc  = flag.Bool("json-doc", false, "read each input as JSON documents, taking the elements of an array as records. Implies -j. See package doc")
+	flgXML      = flag.String("xml", "", "read each ELEMENT element of XML inputs as a record, decoded into X. Implies -n. See package doc")
+	flgYAML     = flag.Bool("yaml", false, "read each input as a YAML stream, taking its documents as records. Implies -j. See package doc")
+	flgH        = flag.Bool("H", false, "take the first line of each file as a header naming columns for Col. Implies -a. See package doc")
+	flgAutoJoin = flag.Bool("autojoin", false, "under -p, print Fields joined with OFS if the script changed them but not Line, like awk. See AutoJoin")
+	flgMaxSplit = flag.Int("maxsplit", 0, "split lines into at most this many fields, the last holding the rest of the line. Implies -a")
+	flgFixed    = flag.String("fixed", "", "split lines into Fields at these columns, like cut -c: e.g. 1-8,9-16,17-. Implies -a. See package doc")
+	flgCSV      = flag.Bool("csv", false, "split records into Fields as CSV, quoted fields included. Implies -a. See package doc")
+	flgTSV      = flag.Bool("tsv", false, "split lines into Fields at tabs, undoing backslash escapes in them. Implies -a. See package doc")
+	inplace     = flag.Bool("i", false, "in-place edit mode. See package doc for in-place edit")
+	inplaceBak  = flag.String("I", "", "in-place edit mode, with backup. See package doc for in-place edit")
+	outFile     = flag.String("o", "", "write output to this file instead of stdout, truncating it first")
+	outAppend   = flag.String("oa", "", "like -o, but append to the file")
+	flgTee      = flag.String("tee", "", "also write output to this file, truncating it first, like piping to tee")
+	outPattern  = flag.String("O", "", "like -i, but write each file's output to a file named by this pattern, as for -I, leaving the input alone")
+	flgKeep     = flag.Bool("k", false, "keep tempdir, for debugging")
+	flgTmpdir   = flag.String("tmpdir", "", "directory to build the one-liner in. Defaults to $GOLF_TMPDIR, or the system temp dir")
+	warnings    = flag.Bool("w", false, "summarize warnings about access to undefined fields and so on at exit")
+	flgStrict   = flag.Bool("strict", false, "make conversion helpers such as GAtoi die on bad input instead of returning 0")
+	quiet       = flag.Bool("q", false, "quiet: suppress golf's own non-fatal diagnostics")
+	goVer       = flag.String("goVer", "1.17", "go version to declare in go.mod file")
+	help        = flag.Bool("h", false, "print usage help and exit")
+	flgM        = flag.String("m", "", "only pass lines matching this regexp to the script. Without -e, print them, like grep. Implies -n. See package doc")
+	flgMatch    = flag.String("match-file", "", "only process records matching a pattern listed in this file. See package doc")
+	flgExclude  = flag.String("exclude-file", "", "skip records matching a pattern listed in this file. See package doc")
+	flgLines    = stringList("lines", nil, "only pass lines in this range to the script, e.g. 100:200, 5: or -10: for the last 10. May be repeated. Implies -n. See package doc")
+	maxLine     = flag.Int("maxline", 0, "fail on input lines longer than this many bytes. 0 means no limit")
+	flgBytes    = flag.Bool("bytes", false, "byte-slice line mode: set LineBytes instead of Line. Implies -n. See package doc")
+	flgFlush    = flag.Bool("flush", false, "flush output after every Print. Default when stdout is a terminal")
+	flgHot      = flag.Bool("hotspots", false, "report where line mode spends its time: reading, splitting, printing, or the script")
+	flgFormat   = flag.String("format", "", "print each record using this text/template. Implies -l and -a. See package doc")
+	flgProject  = flag.String("project", "", "print only these fields, like cut -f: e.g. 3,1,5-. Implies -lap. See package doc")
+	flgDefault  = flag.String("default-field", "", "value of fields past the end of a record, for Field, --project and --format")
+	flgValidate = flag.String("validate", "", "boolean Go expression; records for which it is false are rejected. See package doc")
+	flgErrorsTo = flag.String("errors-to", "", "file to write rejected records to, instead of stderr")
+	flgQuar     = flag.String("quarantine", ".rejected", "in -i mode, save the originals of rejected records to a file named by this pattern, as for -I")
+	flgYes      = flag.Bool("yes", false, "don't ask for confirmation before editing many files in place")
+	flgConfirm  = flag.Int("confirm-over", 20, "ask for confirmation before editing more than this many files in place")
+	flgDryRun   = flag.Bool("dry-run", false, "with -i, print a unified diff of each edit instead of making it")
+	flgMtime    = flag.Bool("keep-mtime", false, "in -i mode, keep the modification times of the files edited")
+	flgSymlinks = flag.String("symlinks", "replace", "in -i mode, what to do with inputs that are symlinks: replace, follow (edit the target) or refuse")
+	flgAtomic   = flag.Bool("atomic-batch", false, "in -i mode, only replace the input files once all of them were processed successfully")
+	flgTimeout  = flag.Duration("timeout", 0, "cancel Ctx after this long, and stop reading input in line mode. See package doc")
+	flgProfile  = flag.String("profile", "", "restrict what the one-liner may do: safe. GOLF_PROFILE overrides it. See package doc")
+	flgMaxSize  = byteSize("max-file-size", "skip input files larger than this, e.g. 500M. See --on-limit")
+	flgFileTime = flag.Duration("per-file-timeout", 0, "stop processing an input file after this long. See --on-limit")
+	flgOnLimit  = flag.String("on-limit", "skip", "what to do with files over --max-file-size or --per-file-timeout: skip or abort")
+	flgTail     = flag.Bool("tail", false, "after the last input file, wait for more lines to be appended to it, like tail -F. Implies -n. See package doc")
+	flgListen   = flag.String("listen", "", "read the connections accepted on this address, [HOST]:PORT or unix:PATH, as inputs. Implies -n. See package doc")
+	flgIdle     = flag.Duration("listen-idle", time.Minute, "with --listen, close a connection that sends nothing for this long, 0 for never")
+	flgWatch    = flag.Bool("watch", false, "rerun the one-liner each time an input file changes, until interrupted. See package doc")
+	flgSince    = flag.String("since-last", "", "only process input added since the last run with the same job name. See package doc")
+	flgMmap     = flag.Bool("mmap", false, "memory-map input files instead of reading them, where possible. See package doc")
+	flgPMap     = flag.Int("Pmap", 0, "run the -e body on up to N lines at once, keeping output in order. Implies -n. See package doc")
+	flgPar      = flag.Int("P", 1, "process input files with up to N instances of the one-liner at once. See package doc")
+	flgSource   = flag.String("source", "", "read records with this RecordSource instead of lines, e.g. nul. Implies -n. See package doc")
+	modules     = stringList("M", nil, "modules to import. May be repeated")
+	flgBinary   = flag.Bool("skip-binary", false, "skip input files that look binary, with a warning, like grep -I")
+	flgNoTerm   = flag.Bool("no-terminal", false, "in line mode with no input files, fail instead of reading stdin from a terminal")
+	flgFeature  = stringList("feature", nil, "embed this optional part of the prelude, even if golf doesn't see it's needed. May be repeated. See package doc")
+	flgCont     = flag.String("in-container", "", "run the one-liner in a container of this image, with the current directory mounted. See package doc")
+	flgR        = flag.Bool("r", false, "read the files under directory arguments, recursively. Implies -n. See package doc")
+	include     = stringList("include", nil, "with -r, only read files whose name matches this glob. May be repeated")
+	exclude     = stringList("exclude", nil, "with -r, skip files and directories whose name matches this glob. May be repeated")
 
 	longFlags      = map[string]bool{}
 	shortBoolFlags = map[string]bool{}
+
+	// scriptArgs are the arguments after --, for the one-liner itself.
+	scriptArgs []string
 )
 
 func init() {
@@ -165,6 +996,9 @@ func init() {
 	flag.BoolVar(help, "help", false, "print usage help and exit")
 	flag.Var(beginSrc, "BEGIN", "code block(s) to insert before record processing")
 	flag.Var(endSrc, "END", "code block(s) to insert after record processing")
+	flag.Var(beginFile, "BEGINFILE", "code block(s) to run as each input file is opened, in line mode. Implies -n")
+	flag.Var(endFile, "ENDFILE", "code block(s) to run when done with each input file, in line mode. Implies -n")
+	flag.Var(encodedSrc{rawSrc}, "e64", "one-liner code, base64-encoded and optionally gzip-compressed. See package doc")
 
 	// Study declared flags so we can decluster e.g. -lane later.
 	flag.CommandLine.VisitAll(func(f *flag.Flag) {
@@ -197,27 +1031,209 @@ func stringList(name string, value stringListValue, usage string) *stringListVal
 	return p
 }
 
+// lineEndValue is the value of the -l flag.
+// Like perl, -l may be given a octal number as a cluster suffix (-l0, -l012)
+// which sets the output record separator to that character. -l=STR sets it
+// to a literal string.
+type lineEndValue struct {
+	on  bool
+	ors string
+}
+
+func (v *lineEndValue) IsBoolFlag() bool { return true }
+
+func (v *lineEndValue) Set(s string) error {
+	switch s {
+	case "true":
+		v.on, v.ors = true, "\n"
+		return nil
+	case "false":
+		v.on, v.ors = false, "\n"
+		return nil
+	}
+	v.on = true
+	if isOctal(s) {
+		n, err := strconv.ParseUint(s, 8, 8)
+		if err != nil {
+			return fmt.Errorf("bad octal record separator %q: %v", s, err)
+		}
+		v.ors = string([]byte{byte(n)})
+		return nil
+	}
+	v.ors = s
+	return nil
+}
+
+func (v *lineEndValue) String() string {
+	if v == nil || !v.on {
+		return "false"
+	}
+	return fmt.Sprintf("%q", v.ors)
+}
+
+// lineEnd returns a lineEndValue bound to a flag.
+func lineEnd(name, usage string) *lineEndValue {
+	p := &lineEndValue{ors: "\n"}
+	flag.Var(p, name, usage)
+	return p
+}
+
+// byteSizeValue is a size in bytes, which may be given with a K, M, G or T
+// suffix for powers of 1024.
+type byteSizeValue int64
+
+func (v *byteSizeValue) Set(s string) error {
+	mult := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		mult = 1 << (10 * (1 + strings.IndexByte("KMGT", s[i])))
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("bad size %q", s)
+	}
+	*v = byteSizeValue(n * mult)
+	return nil
+}
+
+func (v *byteSizeValue) String() string {
+	if v == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+// byteSize returns a byteSizeValue bound to a flag.
+func byteSize(name, usage string) *byteSizeValue {
+	p := new(byteSizeValue)
+	flag.Var(p, name, usage)
+	return p
+}
+
+// encodedSrc is the value of --e64. It decodes the code it is given, and adds
+// it to the -e blocks.
+type encodedSrc struct{ src *stringListValue }
+
+func (v encodedSrc) Set(s string) error {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return fmt.Errorf("bad base64: %v", err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			data, err = io.ReadAll(zr)
+		}
+		if err != nil {
+			return fmt.Errorf("bad gzip data: %v", err)
+		}
+	}
+	return v.src.Set(string(data))
+}
+
+func (v encodedSrc) String() string { return "" }
+
+func isOctal(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '7' {
+			return false
+		}
+	}
+	return true
+}
+
+// chatter prints a non-fatal diagnostic, unless -q was given.
+func chatter(xs ...interface{}) {
+	if !*quiet {
+		prelude.Warn(xs...)
+	}
+}
+
 var errGolf = fmt.Errorf("golf returned nonzero status")
 
 // prog collects the parameters of our one-liner program.
 type prog struct {
-	RawArgs    []string
-	BeginSrc   []string
-	RawSrc     []string
-	EndSrc     []string
-	Src        string
-	Imports    []string
-	FlgN       bool
-	FlgP       bool
-	FlgL       bool
-	FlgA       bool
-	FlgF       string
-	InPlace    bool
-	InPlaceBak string
-	Warnings   bool
-	Goimports  bool
-	Keep       bool
-	Prelude    []byte
+	RawArgs      []string
+	ScriptArgs   []string          // Arguments after --, for the one-liner.
+	Switches     map[string]string // -s switches, for Opt.
+	BeginSrc     []string
+	RawSrc       []string
+	EndSrc       []string
+	BeginFileSrc []string
+	EndFileSrc   []string
+	Src          string
+	Imports      []string
+	FlgN         bool
+	FlgP         bool
+	FlgL         bool
+	ORS          string
+	FlgA         bool
+	FlgF         string
+	InPlace      bool
+	InPlaceBak   string
+	OutPattern   string // -O
+	OutFile      string // -o or -oa
+	OutAppend    bool   // -oa
+	Quarantine   string
+	AtomicBatch  bool
+	Warnings     bool
+	Strict       bool
+	Quiet        bool
+	Match        string
+	Exclude      string
+	MatchRE      string // -m
+	LineRanges   string // --lines ranges, as a Go expression.
+	LinesFromEnd bool   // Whether any --lines range counts from the end.
+	MaxLine      int
+	Bytes        bool
+	ReuseFields  bool // Whether the script can't keep Fields past its line.
+	Flush        bool
+	Hotspots     bool
+	FormatSrc    string // Go statement printing a record for --format.
+	FormatTmpl   string // The --format template, if it must be executed at run time.
+	ProjectSrc   string // Go statement rearranging Fields for --project.
+	DefaultField string
+	Validate     string
+	ValidateSrc  string // Validate, as Go code.
+	ErrorsTo     string
+	Goimports    bool
+	Keep         bool
+	Tmpdir       string
+	Parallel     int
+	PMap         int
+	MaxFileSize  int64
+	SinceLast    string
+	FileTimeout  time.Duration
+	LimitAbort   bool
+	Symlinks     string
+	UsesCtx      bool          // Whether the script refers to Ctx.
+	Timeout      time.Duration // --timeout, if any.
+	Prelude      []byte
+	FeatureSrc   [][]byte        // Optional parts of the prelude, as needed.
+	Features     map[string]bool // The names of those parts.
+	Mmap         bool
+	Source       string // --source record source, if any.
+	SkipBinary   bool
+	NoTerminal   bool
+	KeepMtime    bool
+	Remote       string        // Host to run on, for golf remote.
+	Container    string        // Image to run in, for --in-container.
+	Watch        bool          // Rerun on changes to the inputs, for --watch.
+	Listen       string        // Address to accept input connections on, for --listen.
+	ListenIdle   time.Duration // --listen-idle
+	CSV          bool
+	TSV          bool
+	Header       bool   // -H
+	SplitSrc     string // Go statement splitting Line into Fields under -a.
+	MaxSplit     int
+	AutoJoin     bool
+	JSON         bool   // -j
+	XML          string // --xml element, if any.
+
+	reraise os.Signal // fatal signal the one-liner died of, if any.
 }
 
 var program = template.Must(template.New("program").Parse(`// Program golfing is a one-liner wrapped by golf.
@@ -230,138 +1246,580 @@ import (
 )
 
 {{ printf "%s" .Prelude}}
+{{- range .FeatureSrc}}
+{{ printf "%s" .}}
+{{- end}}
 
 func init() {
+	golfSplitArgs()
+	{{- range $name, $value := .Switches}}
+	golfSwitches[{{printf "%q" $name}}] = {{printf "%q" $value}}
+	{{- end}}
 	IFS = {{ printf "%q" .FlgF }}
+	{{- if .MaxSplit}}
+	MaxSplit = {{.MaxSplit}}
+	{{- end}}
+	golfCompileIFS()
+	AutoJoin = {{.AutoJoin}}
+	{{- if .CSV}}
+	golfFieldJoin, golfDefaultLine = golfCSVJoin, golfCSVLine
+	{{- else if .TSV}}
+	golfFieldJoin, golfDefaultLine = golfTSVJoin, golfTSVLine
+	{{- end}}
+	{{- if .XML}}
+	RegisterSource("xml", func() RecordSource { return &golfXMLSource{elem: {{printf "%q" .XML}}} })
+	{{- else if eq .Source "yaml"}}
+	RegisterSource("yaml", func() RecordSource { return &golfYAMLSource{} })
+	{{- end}}
+	DefaultField = {{ printf "%q" .DefaultField }}
 	Warnings = {{ .Warnings }}
+	Strict = {{ .Strict }}
+	GolfQuiet = {{ .Quiet }}
+	{{- if .ErrorsTo}}
+	if f, err := os.Create({{printf "%q" .ErrorsTo}}); err != nil {
+		Die("golf: --errors-to: %v", err)
+	} else {
+		GolfErrors = golfBuffered(f)
+	}
+	{{- end}}
 	GolfFlgL = {{ .FlgL }}
+	GolfBytes = {{ .Bytes }}
+	GolfReuseFields = {{ .ReuseFields }}
+	GolfFlush = GolfFlush || {{ .Flush }}
+	ORS = {{ printf "%q" .ORS }}
 	GolfInPlace = {{ .InPlace }}
 	GolfInPlaceBak = {{ printf "%q" .InPlaceBak }}
+	GolfOutPattern = {{ printf "%q" .OutPattern }}
+	{{- if .OutFile}}
+	golfNameStdout({{printf "%q" .OutFile}}, {{.OutAppend}})
+	{{- end}}
+	GolfQuarantine = {{ printf "%q" .Quarantine }}
+	GolfAtomicBatch = {{ .AtomicBatch }}
+	GolfKeepMtime = {{ .KeepMtime }}
+	GolfLimitAbort = {{ .LimitAbort }}
+	GolfSymlinks = {{ printf "%q" .Symlinks }}
+	{{- if .SinceLast}}
+	golfSinceLoad({{printf "%q" .SinceLast}})
+	{{- end}}
+	{{- if .UsesCtx}}
+	golfCancelOnSignal()
+	{{- end}}
+	{{- if .Timeout}}
+	golfSetTimeout({{printf "%d" .Timeout}})
+	{{- end}}
 }
 
 func main() {
+	defer golfAtExit()
 	// User -BEGIN start
 	{{- range .BeginSrc}}
 	{{.}}
 	{{- end }}
 	// User -BEGIN end
 	{{- if .FlgN}}
+	{{- if .Match}}
+	_golfMatch := golfLoadPatterns({{printf "%q" .Match}})
+	{{- end}}
+	{{- if .Exclude}}
+	_golfExclude := golfLoadPatterns({{printf "%q" .Exclude}})
+	{{- end}}
+	{{- if .MatchRE}}
+	_golfMatchRE := regexp.MustCompile({{printf "%q" .MatchRE}})
+	{{- end}}
+	{{- if .Hotspots}}
+	_golfHot := &golfHotspots{}
+	{{- end}}
+	{{- if .FormatTmpl}}
+	_golfFormat := template.Must(template.New("format").Parse({{printf "%q" .FormatTmpl}}))
+	var _golfFormatOut bytes.Buffer
+	{{- end}}
 	const _golfP = {{.FlgP}}
 	var _golfPDirty = false
 	_golfFlushP := func() {
 		if _golfPDirty {
-			Print(Line)
+			Print()
 			_golfPDirty = false
 		}
 	}
 	_golfCloseOut := func() {
-		if CurOut == os.Stdout {
+		golfCloseQuarantine()
+		Flush()
+		if golfFileOut == nil {
 			return
 		}
-		if err := CurOut.Close(); err != nil {
-			Warn("golf: can't close current output: %v", err)
+		golfCloseFileOut()
+		CurOut = golfStdout
+	}
+
+	{{- if .PMap}}
+	// -Pmap: the -e body runs on batches of lines in parallel. Each line
+	// gets its own copies of the per-line variables, which shadow the
+	// globals, and its own output buffer.
+	_golfMapBody := func(_golfJob *golfMapJob) {
+		Filename, LineNum, TotalLineNum, Line, LineEnding, Fields := _golfJob.Filename, _golfJob.LineNum, _golfJob.TotalLineNum, _golfJob.Line, _golfJob.LineEnding, _golfJob.Fields
+		Field := func(n int) string { return golfField(Fields, n) }
+		Col := func(name string) string { return golfCol(Fields, name) }
+		SetField := func(n int, v string) { Fields, Line = golfSetField(Fields, n, v, LineEnding) }
+		Print := func(xs ...interface{}) { golfPrintTo(&_golfJob.Out, Line, xs) }
+		Printf := func(format string, xs ...interface{}) { fmt.Fprintf(&_golfJob.Out, format, xs...) }
+		_, _, _, _, _, _, _, _, _ = Filename, LineNum, TotalLineNum, LineEnding, Field, Col, SetField, Print, Printf
+		{{- if index .Features "kv"}}
+		KV := func(l ...string) map[string]string {
+			if len(l) == 0 {
+				l = []string{Line}
+			}
+			return KV(l...)
 		}
-		CurOut = os.Stdout
+		_ = KV
+		{{- end}}
+		{{- if index .Features "json"}}
+		PrintJSON := func(v interface{}) { Print(golfJSONRecord(ToJSON(v))) }
+		PrintJSONPretty := func(v interface{}) { Print(golfJSONRecord(ToJSONPretty(v))) }
+		_, _ = PrintJSON, PrintJSONPretty
+		{{- end}}
+		{{- if index .Features "yaml"}}
+		PrintYAML := func(v interface{}) { Print(golfYAMLRecord(v)) }
+		_ = PrintYAML
+		{{- end}}
+		// User -e start
+		{{- range .RawSrc}}
+		{{.}}
+		{{- end}}
+		// User -e end
+		{{- if .FlgP}}
+		Print()
+		{{- end}}
 	}
+	_golfMapBatch := make([]*golfMapJob, 0, {{.PMap}}*256)
+	_golfMapFlush := func() {
+		if len(_golfMapBatch) == 0 {
+			return
+		}
+		golfMapRun(_golfMapBatch, {{.PMap}}, _golfMapBody)
+		for _, j := range _golfMapBatch {
+			CurOut.Write(j.Out.Bytes())
+		}
+		if GolfFlush {
+			Flush()
+		}
+		_golfMapBatch = _golfMapBatch[:0]
+	}
+	{{- end}}
+
+	{{- if .Mmap}}
+	var _golfMapped []byte
+	{{- end}}
 
-	_golfFilenames := os.Args[1:]
-	if len(_golfFilenames)==0 {
-		_golfFilenames=[]string{"/dev/stdin"}
+	{{- if not .Listen}}
+	if len(ARGV) == 0 {
+		ARGV = []string{"-"}
 		GolfInPlace = false
 		GolfInPlaceBak = ""
+		golfCheckTerminal({{.NoTerminal}})
 	}
+	{{- end}}
+	{{- if .Source}}
+	_golfSource := golfSource({{printf "%q" .Source}})
+	{{- end}}
+	// Archives are only expanded when not editing in place.
+	_golfInputs := &golfInputs{archives: !GolfInPlace}
+	{{- if .Listen}}
+	_golfInputs.accept = golfListen({{printf "%q" .Listen}}, {{printf "%d" .ListenIdle}})
+	{{- end}}
 File:
-    for _, Filename = range _golfFilenames {
+	for {
+		{{- if .PMap}}
+		_golfMapFlush()
+		{{- end}}
 		_golfFlushP()
 		_golfCloseOut()
-		_golfFile, err := os.Open(Filename)
-		if err != nil {
-			Die(err)
+		_golfIn, ok := _golfInputs.next()
+		if !ok {
+			break
+		}
+		Filename = _golfIn.Name
+		{{- if .MaxFileSize}}
+		if _golfIn.Size > {{.MaxFileSize}} {
+			golfOverLimit(fmt.Sprintf("%s: %d bytes, over --max-file-size", Filename, _golfIn.Size))
+			continue File
 		}
-		// NOTE: assumes POSIX fs semantics: a file can be renamed or deleted
-		// after being opened. This will probably fail on Windows.
+		{{- end}}
+		LineNum = 0
+		{{- if .SinceLast}}
+		// Pick up where the last run left off.
+		_golfSince := golfSinceStart(_golfIn.File)
+		LineNum = _golfSince.Lines
+		{{- end}}
+		_golfReader := bufio.NewReaderSize(_golfIn.R, 64<<10)
+		{{- if .SkipBinary}}
+		if !(GolfInPlace && golfIsGzip(_golfReader)) && golfIsBinary(_golfReader) {
+			golfWarn("golf: %s: binary file skipped", Filename)
+			continue File
+		}
+		{{- end}}
+		// NOTE: assumes POSIX fs semantics: a file can be renamed over
+		// while it is open. This will probably fail on Windows.
 		if GolfInPlace {
-			if GolfInPlaceBak == "" {
-				// In the no-backup case, we still need to unlink the input
-				// before os.Create, because otherwise the input will be
-				// truncated before we read it.
-				if err := os.Remove(Filename); err !=nil {
-					Die("golf: can't remove input file: %v", err)
-				}
-			} else {
-				bakname := BackupName(Filename, GolfInPlaceBak)
-				if os.Rename(Filename, bakname); err != nil {
-					Die("golf: in-place backup: %v", err)
-				}
+			_golfOrig, err := _golfIn.File.Stat()
+			if err != nil {
+				Die("golf: %v", err)
 			}
-
-			if CurOut, err = os.Create(Filename); err != nil {
+			_golfTarget, err := golfInPlaceTarget(Filename)
+			if err != nil {
+				// Counted, like GErr's, but not hidden by -q.
+				Warn(golfErr{err})
+				continue File
+			}
+			_golfOut, err := golfStage(_golfTarget)
+			if err != nil {
 				Die("golf: can't create output: %v", err)
 			}
+			golfKeepAttrs(_golfOut, _golfOrig)
+			CurOut = golfBuffered(_golfOut)
 		}
-		LineNum = 0
-		_golfScanner := bufio.NewScanner(_golfFile)
+		{{- if .Mmap}}
+		// The previous file's lines are no longer needed.
+		golfMunmap(_golfMapped)
+		_golfMapped = golfMmap(_golfIn.File) // nil for archive members.
+		_golfData := _golfMapped
+		{{- if .SinceLast}}
+		if _golfData != nil {
+			_golfData = _golfData[_golfSince.Offset:]
+		}
+		{{- end}}
+		{{- end}}
+		if GolfInPlace && golfIsGzip(_golfReader) {
+			_golfReader, CurOut = golfGzipInPlace(_golfReader, CurOut)
+			{{- if .Mmap}}
+			_golfData = nil
+			{{- end}}
+		}
+		if GolfInPlace {
+			golfFileOut = CurOut
+		}
+		{{- if .LineRanges}}
+		_golfLines := golfNewLines({{.LineRanges}}, {{if .LinesFromEnd}}golfCountLines(&_golfReader){{else}}-1{{end}})
+		{{- end}}
+		{{- if or .PMap .Source}}
+		golfEof = golfNoEof
+		{{- else if .Mmap}}
+		golfEof = func() bool {
+			if _golfData != nil {
+				return len(_golfData) == 0
+			}
+			return golfReaderEof(&_golfReader)()
+		}
+		{{- else}}
+		golfEof = golfReaderEof(&_golfReader)
+		{{- end}}
+		{{- if .Source}}
+		if err := _golfSource.Open(Filename, _golfReader); err != nil {
+			Die("golf: %s: %v", Filename, err)
+		}
+		{{- end}}
+		{{- if .Header}}
+		golfSetHeader(nil)
+		{{- end}}
+		golfRunFileHooks()
+		// User -BEGINFILE start
+		{{- range .BeginFileSrc}}
+		{{.}}
+		{{- end}}
+		// User -BEGINFILE end
+		{{- if .FileTimeout}}
+		_golfFileStart := time.Now()
+		{{- end}}
 	Line:
-		for _golfScanner.Scan() {
+		for {
+			{{- if .Hotspots}}
+			_golfHot.line()
+			{{- end}}
+			{{- if or .UsesCtx .Timeout}}
+			if Ctx.Err() != nil {
+				break File
+			}
+			{{- end}}
 			_golfFlushP()
+			{{- if .FileTimeout}}
+			if time.Since(_golfFileStart) > {{printf "%d" .FileTimeout}} {
+				golfOverLimit(fmt.Sprintf("%s:%d: over --per-file-timeout", Filename, LineNum))
+				if GolfInPlace {
+					// Don't lose the rest of the file.
+					{{- if .Mmap}}
+					if _golfData != nil {
+						CurOut.Write(_golfData)
+						continue File
+					}
+					{{- end}}
+					io.Copy(CurOut, _golfReader)
+				}
+				continue File
+			}
+			{{- end}}
+			{{- if .Hotspots}}
+			_golfHot.lap(golfHotRead)
+			{{- end}}
+			// Read the raw line, terminator included, so that input can be
+			// reproduced byte-for-byte. The last line may lack a terminator.
+			{{- if .Source}}
+			_golfRaw, err := golfSourceNext(_golfSource)
+			{{- else if .Mmap}}
+			var _golfRaw []byte
+			var err error
+			if _golfData != nil {
+				_golfRaw, err = golfSliceLine(&_golfData, {{.MaxLine}})
+			} else {
+				_golfRaw, err = golfReadLine(_golfReader, {{.MaxLine}})
+			}
+			{{- else}}
+			_golfRaw, err := golfReadLine(_golfReader, {{.MaxLine}})
+			{{- end}}
+			{{- if .CSV}}
+			// A quoted field may go on over several lines.
+			_golfRaw, err = golfCSVRecord(_golfReader, {{.MaxLine}}, _golfRaw, err)
+			{{- end}}
+			if err != nil && err != io.EOF {
+				Die("%s:%d: %v", Filename, LineNum+1, err)
+			}
+			if len(_golfRaw) == 0 {
+				break
+			}
 			LineNum++  // 1-based. Be compatible with awk, perl's default.
-			// Scanned line.
-			// BUG: restores newlines crudely in non-line mode.
-			// Should have \r when they were present in input, and should not
-			// insert a trailing newline on the last line if it was absent.
-			Line = _golfScanner.Text() {{- if not .FlgL}} + "\n"{{end}}
+			TotalLineNum++
+			LineEnding = golfLineEnding(_golfRaw)
+			{{- if .SinceLast}}
+			if LineEnding == "" || LineEnding == "\r" && err == io.EOF {
+				// Possibly still being written. Leave it for the next run.
+				LineNum--
+				TotalLineNum--
+				break
+			}
+			_golfSince.Offset += int64(len(_golfRaw))
+			_golfSince.Lines = LineNum
+			{{- end}}
+			{{- if .Header}}
+			if LineNum == 1 {
+				// -H: the header names the columns, and is no record.
+				// Its names never include the line ending, even without -l.
+				Line = string(_golfRaw[:len(_golfRaw)-len(LineEnding)])
+				{{.SplitSrc}}
+				golfSetHeader(Fields)
+				{{- if .FlgP}}
+				{{- if not .FlgL}}
+				Line = string(_golfRaw)
+				{{- end}}
+				Print()
+				{{- end}}
+				continue Line
+			}
+			{{- end}}
+			{{- if .LineRanges}}
+			if !_golfLines.has(LineNum) {
+				if LineNum >= _golfLines.last {
+					break // Past the last range: the rest is not read.
+				}
+				continue Line
+			}
+			{{- end}}
+			{{- if .Hotspots}}
+			_golfHot.lap(golfHotSplit)
+			{{- end}}
+			{{- if .Match}}
+			if !_golfMatch.match(string(_golfRaw[:len(_golfRaw)-len(LineEnding)])) {
+				continue Line
+			}
+			{{- end}}
+			{{- if .Exclude}}
+			if _golfExclude.match(string(_golfRaw[:len(_golfRaw)-len(LineEnding)])) {
+				continue Line
+			}
+			{{- end}}
+			{{- if .MatchRE}}
+			if !_golfMatchRE.Match(_golfRaw[:len(_golfRaw)-len(LineEnding)]) {
+				continue Line
+			}
+			{{- end}}
+			{{- if .FlgL}}
+			_golfRaw = _golfRaw[:len(_golfRaw)-len(LineEnding)]
+			{{- end}}
+			{{- if .Bytes}}
+			LineBytes = _golfRaw
+			{{- else}}
+			Line = string(_golfRaw) // The only per-line allocation golf makes.
+			{{- end}}
+			{{- if .FlgA}}
+			{{.SplitSrc}}
+			{{- if not .Bytes}}
+			if AutoJoin {
+				golfNoteSplit()
+			}
+			{{- end}}
+			{{- end}}
+			{{- if .JSON}}
+			golfParseJSON()
+			{{- end}}
+			{{- if .Validate}}
+			if !({{.ValidateSrc}}) {
+				golfReject({{printf "%q" .Validate}})
+				continue Line
+			}
+			{{- end}}
+			if len(golfLineHooks) > 0 && !golfRunLineHooks() {
+				continue Line
+			}
+			{{- if .PMap}}
+			_golfMapBatch = append(_golfMapBatch, &golfMapJob{Filename: Filename, LineNum: LineNum, TotalLineNum: TotalLineNum, Line: Line, LineEnding: LineEnding{{if .FlgA}}, Fields: append([]string(nil), Fields...){{end}}})
+			if len(_golfMapBatch) == cap(_golfMapBatch) {
+				_golfMapFlush()
+			}
+			continue Line
+			{{- else}}
 			_golfPDirty = {{ .FlgP }}
-			{{if .FlgA}}
-			Fields = GSplit(IFS, Line)
+			{{- if .Hotspots}}
+			_golfHot.lap(golfHotScript)
+			{{- end}}
 			{{- end}}
 			{{- end}}
+			{{- if not .PMap}}
 			// User -e start
 			{{- range .RawSrc}}
 			{{.}}
 			{{- end}}
 			// User -e end
+			{{- end}}
 			{{- if .FlgN}}
+			{{- if .ProjectSrc}}
+			{{.ProjectSrc}}
+			{{- end}}
+			{{- if .FormatSrc}}
+			{{.FormatSrc}}
+			{{- end}}
 			continue Line
 		}
-		if err := _golfScanner.Err(); err != nil {
-			Die("%s: %v", Filename, err)
-		}
+		{{- if .Hotspots}}
+		_golfHot.lap(golfHotNone)
+		{{- end}}
+		{{- if .EndFileSrc}}
+		{{- if .PMap}}
+		_golfMapFlush()
+		{{- end}}
+		_golfFlushP()
+		// User -ENDFILE start
+		{{- range .EndFileSrc}}
+		{{.}}
+		{{- end}}
+		// User -ENDFILE end
+		{{- end}}
 		continue File
 	}
+	{{- if .PMap}}
+	_golfMapFlush()
+	{{- end}}
 	_golfFlushP()
 	_golfCloseOut()
+	{{- if .Source}}
+	if err := _golfSource.Close(); err != nil {
+		Die("golf: --source: %v", err)
+	}
+	{{- end}}
+	if GolfAtomicBatch {
+		golfCommitStaged()
+	}
+	{{- if .SinceLast}}
+	golfSinceSave()
+	{{- end}}
+	{{- if .Hotspots}}
+	_golfHot.report()
+	{{- end}}
 	{{- end}}
 	// User -END start
 	{{- range .EndSrc}}
 	{{.}}
 	{{- end }}
 	// User -END end
+	golfErrSummary()
+	Exit(ExitCode)
+}
+`))
+
+func (p *prog) transform() error {
+	s := &bytes.Buffer{}
+	if err := program.Execute(s, p); err != nil {
+		return err
+	}
+
+	// Try to pretty it up, but stay silent about errors. The real compiler
+	// will give a better error message later.
+	if src, err := format.Source(s.Bytes()); err != nil {
+		p.Src = s.String()
+	} else {
+		p.Src = string(src)
+	}
+	return nil
+}
+
+// signalRelay catches interrupt and termination signals while golf runs,
+// and relays them to the running child commands, if any. This keeps golf
+// alive long enough to clean up its temporary directory.
+type signalRelay struct {
+	mu       sync.Mutex
+	ch       chan os.Signal
+	children map[*os.Process]bool
+	caught   os.Signal // the last signal received.
+}
+
+var relay = &signalRelay{children: map[*os.Process]bool{}}
+
+func (r *signalRelay) start() {
+	r.ch = make(chan os.Signal, 1)
+	signal.Notify(r.ch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for s := range r.ch {
+			r.mu.Lock()
+			r.caught = s
+			for c := range r.children {
+				c.Signal(s)
+			}
+			r.mu.Unlock()
+		}
+	}()
+}
+
+func (r *signalRelay) stop() {
+	signal.Stop(r.ch)
+	close(r.ch)
+}
+
+// interrupted reports whether a signal was received since start.
+func (r *signalRelay) interrupted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.caught != nil
 }
-`))
 
-func (p *prog) transform() error {
-	s := &bytes.Buffer{}
-	if err := program.Execute(s, p); err != nil {
+// run runs cmd to completion, relaying signals to it meanwhile.
+func (r *signalRelay) run(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
 		return err
 	}
-
-	// Try to pretty it up, but stay silent about errors. The real compiler
-	// will give a better error message later.
-	if src, err := format.Source(s.Bytes()); err != nil {
-		p.Src = s.String()
-	} else {
-		p.Src = string(src)
-	}
-	return nil
+	r.mu.Lock()
+	r.children[cmd.Process] = true
+	r.mu.Unlock()
+	err := cmd.Wait()
+	r.mu.Lock()
+	delete(r.children, cmd.Process)
+	r.mu.Unlock()
+	return err
 }
 
 // do runs the command with stdio connected.
-func do(c string, args []string) error {
+func do(c string, args []string, extra ...*os.File) error {
 	cmd := exec.Command(c, args...)
 	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	cmd.ExtraFiles = extra
+	if err := relay.run(cmd); err != nil {
 		return err
 	}
 	if cmd.ProcessState.ExitCode() != 0 {
@@ -370,15 +1828,35 @@ func do(c string, args []string) error {
 	return nil
 }
 
+// inheritFDs returns the descriptors that fd:N inputs among args refer to,
+// for a one-liner's exec.Cmd.ExtraFiles, where descriptor N is entry N-3.
+func inheritFDs(args []string) []*os.File {
+	var files []*os.File
+	for _, arg := range args {
+		fd, ok := prelude.InputFD(arg)
+		if !ok || fd < 3 {
+			// Standard descriptors are passed on anyway.
+			continue
+		}
+		for len(files) <= fd-3 {
+			files = append(files, nil)
+		}
+		files[fd-3] = os.NewFile(uintptr(fd), arg)
+	}
+	return files
+}
+
 // doQ runs the command, but elides the output if it was successful.
 func doQ(c string, args []string) error {
 	cmd := exec.Command(c, args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := relay.run(cmd); err != nil {
 		return err
 	}
 	if cmd.ProcessState.ExitCode() != 0 {
-		return fmt.Errorf("%s", string(out))
+		return fmt.Errorf("%s", out.String())
 	}
 	return nil
 }
@@ -426,7 +1904,14 @@ func (p *prog) writeGolf(tmpdir string) bool {
 }
 
 func (p *prog) run() int {
-	tmpdir, err := os.MkdirTemp("", "golf-")
+	relay.start()
+	defer relay.stop()
+
+	parent := p.Tmpdir
+	if parent == "" {
+		parent = os.Getenv("GOLF_TMPDIR")
+	}
+	tmpdir, err := os.MkdirTemp(parent, "golf-")
 	if err != nil {
 		prelude.Warn("golf: mkdir tmp: %v\n", err)
 		return 1
@@ -435,13 +1920,17 @@ func (p *prog) run() int {
 		prelude.Warn("golf: abs tmp: %v\n", err)
 		return 1
 	}
+	if parent != "" && os.Getenv("GOTMPDIR") == "" {
+		// Keep the go command's own scratch files off the system temp, too.
+		os.Setenv("GOTMPDIR", tmpdir)
+	}
 
 	if p.Keep {
-		prelude.Warn(tmpdir)
+		prelude.Warn("golf: keeping build dir: %s", tmpdir)
 	} else {
 		defer func() {
 			if err := os.RemoveAll(tmpdir); err != nil {
-				prelude.Warn("golf: rmall tmp: %v\n", err)
+				chatter("golf: rmall tmp: %v\n", err)
 				// but don't fail the golf.
 			}
 		}()
@@ -458,7 +1947,7 @@ func (p *prog) run() int {
 		return 1
 	}
 
-	if ok := p.writeGolf(tmpdir); !ok {
+	if ok := p.writeGolf(tmpdir); !ok || relay.interrupted() {
 		return 1
 	}
 
@@ -480,43 +1969,191 @@ func (p *prog) run() int {
 	const binname = "golfing" // should this add .exe on win32?
 
 	if err := do("go", []string{"build", "-o", binname, "."}); err != nil {
+		// On errGolf, the compiler has already explained.
 		if err != errGolf {
 			prelude.Warn("golf: %v", err)
 		}
 		return 1
 	}
+	if relay.interrupted() {
+		return 1
+	}
 
 	if err := os.Chdir(origdir); err != nil {
 		prelude.Warn("golf: returning to original dir: %v", err)
 		return 1
 	}
 
-	if err := do(filepath.Join(tmpdir, binname), p.RawArgs); err != nil {
+	if p.Remote != "" {
+		return p.runRemote(filepath.Join(tmpdir, binname))
+	}
+	if p.Container != "" {
+		return p.runContainer(filepath.Join(tmpdir, binname))
+	}
+	once := func() int {
+		if p.Parallel > 1 && len(p.RawArgs) > 1 && p.FlgN {
+			return p.runParallel(filepath.Join(tmpdir, binname), tmpdir)
+		}
+		if err := do(filepath.Join(tmpdir, binname), p.binArgs(p.RawArgs), inheritFDs(p.RawArgs)...); err != nil {
+			return p.exitStatus(err)
+		}
+		return 0
+	}
+	if p.Watch {
+		return p.watch(once)
+	}
+	return once()
+}
+
+// binArgs returns the command line of the one-liner binary for the input
+// files named: the files, then the script arguments after a "--".
+func (p *prog) binArgs(files []string) []string {
+	if len(p.ScriptArgs) == 0 {
+		return files
+	}
+	return append(append(append([]string(nil), files...), "--"), p.ScriptArgs...)
+}
+
+// runParallel runs the one-liner binary bin once for each input file, with
+// up to p.Parallel of them at a time. Each instance's output is spooled to a
+// file in tmpdir, and copied to stdout in the order the files were given.
+// The status is that of the first file whose instance failed.
+func (p *prog) runParallel(bin, tmpdir string) int {
+	type job struct {
+		out  string
+		err  error
+		done chan bool
+	}
+	jobs := make([]*job, len(p.RawArgs))
+	for i := range jobs {
+		jobs[i] = &job{out: filepath.Join(tmpdir, fmt.Sprintf("out-%d", i)), done: make(chan bool)}
+	}
+	sem := make(chan bool, p.Parallel)
+	go func() {
+		for i, name := range p.RawArgs {
+			j := jobs[i]
+			sem <- true
+			if relay.interrupted() {
+				j.err = errGolf
+				<-sem
+				close(j.done)
+				continue
+			}
+			go func(name string) {
+				defer func() { <-sem; close(j.done) }()
+				out, err := os.Create(j.out)
+				if err != nil {
+					j.err = err
+					return
+				}
+				defer out.Close()
+				cmd := exec.Command(bin, p.binArgs([]string{name})...)
+				if name == "-" {
+					cmd.Stdin = os.Stdin
+				}
+				cmd.ExtraFiles = inheritFDs([]string{name})
+				cmd.Stdout = out
+				cmd.Stderr = os.Stderr
+				if j.err = relay.run(cmd); j.err == nil && cmd.ProcessState.ExitCode() != 0 {
+					j.err = errGolf
+				}
+			}(name)
+		}
+	}()
+	status := 0
+	for _, j := range jobs {
+		<-j.done
+		if f, err := os.Open(j.out); err == nil {
+			io.Copy(stdout, f)
+			f.Close()
+			os.Remove(j.out)
+		}
+		if j.err != nil && status == 0 {
+			status = p.exitStatus(j.err)
+		}
+	}
+	return status
+}
+
+// exitStatus returns the status golf should exit with after the one-liner
+// failed with err: its own exit code, so that golf composes in pipelines and
+// Makefiles. If it was killed by a fatal signal, it is recorded in p.reraise
+// so that main can kill golf with it too, once cleanup is done.
+func (p *prog) exitStatus(err error) int {
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
 		if err != errGolf {
 			prelude.Warn("golf: %v", err)
 		}
 		return 1
 	}
+	ws, ok := ee.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return ee.ExitCode()
+	}
+	switch sig := ws.Signal(); sig {
+	case syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP:
+		p.reraise = sig
+	}
+	// The shell convention, in case re-raising doesn't work.
+	return 128 + int(ws.Signal())
+}
 
-	return 0
+// exit exits golf with status, or with the signal that killed the one-liner.
+func (p *prog) exit(status int) {
+	if p.reraise != nil {
+		signal.Reset(p.reraise)
+		if self, err := os.FindProcess(os.Getpid()); err == nil && self.Signal(p.reraise) == nil {
+			// Delivery is asynchronous. Give it a moment before falling back.
+			time.Sleep(time.Second)
+		}
+	}
+	os.Exit(status)
 }
 
 func decluster() {
 	res := []string{os.Args[0]}
 	for i, v := range os.Args[1:] {
-		if v[0] != '-' || longFlags[v[1:]] {
-			// Skip a non-flag arguments and known long flags.
+		if v == "" || v == "-" || v[0] != '-' || longFlags[strings.TrimLeft(v, "-")] || v[1] >= '0' && v[1] <= '9' {
+			// Skip a non-flag arguments (including "-", for stdin, and
+			// negative numbers, such as --lines -10:) and known long
+			// flags.
 			res = append(res, v)
 			continue
 		}
 		if v == "--" {
-			res = append(res, os.Args[i:]...)
+			// The rest is for the one-liner itself.
+			scriptArgs = os.Args[i+2:]
 			break
 		}
-		for i, vv := range strings.Split(v[1:], "") {
-			if i < (len(v)-2) && !shortBoolFlags[vv] {
-				// This doesn't protect against -ib, unfortunately.
-				// (Our version of -i does not take an arg.)
+		if eq := strings.Index(v, "="); eq > 0 {
+			// -l=STR and friends are passed through to the flag package.
+			if name := strings.TrimLeft(v[:eq], "-"); len(name) == 1 || longFlags[name] {
+				res = append(res, v)
+				continue
+			}
+		}
+		cluster := strings.Split(v[1:], "")
+		for i := 0; i < len(cluster); i++ {
+			vv := cluster[i]
+			if vv == "l" {
+				// perl-style -l0, -l012: consume trailing octal digits.
+				j := i + 1
+				for j < len(cluster) && isOctal(cluster[j]) {
+					j++
+				}
+				if j > i+1 {
+					res = append(res, "-l="+strings.Join(cluster[i+1:j], ""))
+					i = j - 1
+					continue
+				}
+			}
+			if vv == "i" && i < len(cluster)-1 && !isCluster(cluster[i+1:]) {
+				// perl-style -i.bak: the rest is the backup extension.
+				res = append(res, "-I", strings.Join(cluster[i+1:], ""))
+				break
+			}
+			if i < len(cluster)-1 && !shortBoolFlags[vv] {
 				prelude.Warn("-%s cannot be used inside a flag cluster", vv)
 				flag.PrintDefaults()
 				os.Exit(1)
@@ -527,6 +2164,89 @@ func decluster() {
 	os.Args = res
 }
 
+// isCluster reports whether flags, what follows -i in a cluster, are more
+// flags rather than a backup extension: boolean flags, -l with octal digits,
+// and a final -e, so that -ipe and -ilane keep their meaning.
+func isCluster(flags []string) bool {
+	for i, f := range flags {
+		switch {
+		case shortBoolFlags[f]:
+		case isOctal(f) && i > 0 && (flags[i-1] == "l" || isOctal(flags[i-1])):
+		case f == "e" && i == len(flags)-1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// redirectStdout opens the file named by -o, truncating it, or by -oa, for
+// appending, and makes it golf's standard output, which one-liners inherit.
+// It does nothing if neither was given.
+func redirectStdout(trunc, appendTo string) error {
+	name, mode := trunc, os.O_TRUNC
+	if appendTo != "" {
+		name, mode = appendTo, os.O_APPEND
+	}
+	if name == "" {
+		return nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|mode, 0666)
+	if err != nil {
+		return err
+	}
+	os.Stdout, stdout = f, f
+	return nil
+}
+
+// stdout is where one-liners' standard output goes: os.Stdout, or with
+// --tee, a teeWriter.
+var stdout io.Writer = os.Stdout
+
+// teeWriter copies what is written to it to out and to the file f, for
+// --tee. It never fails, so as not to leave the one-liner blocked writing to
+// a pipe nobody reads. Instead it stops writing where it failed, and keeps
+// the first error, for tee.err.
+type teeWriter struct {
+	out, f       io.Writer
+	name         string
+	outErr, fErr error
+}
+
+func (t *teeWriter) Write(b []byte) (int, error) {
+	if t.outErr == nil {
+		_, t.outErr = t.out.Write(b)
+	}
+	if t.fErr == nil {
+		_, t.fErr = t.f.Write(b)
+	}
+	return len(b), nil
+}
+
+// err returns the first error writing out or f, if any.
+func (t *teeWriter) err() error {
+	if t.outErr != nil {
+		return t.outErr
+	}
+	if t.fErr != nil {
+		return fmt.Errorf("--tee %s: %v", t.name, t.fErr)
+	}
+	return nil
+}
+
+// startTee creates the file name, truncating it, and copies stdout to it
+// from now on. It is opened write-only: were golf to hold a read end of a
+// pipe, writing to it would block once its reader was gone, rather than fail.
+func startTee(name string) (*teeWriter, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	t := &teeWriter{out: stdout, f: f, name: name}
+	stdout = t
+	return t, nil
+}
+
 func dedupe(s []string) []string {
 	sort.Strings(s)
 	w := 0
@@ -540,6 +2260,37 @@ func dedupe(s []string) []string {
 	return s[:w]
 }
 
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmInPlace describes an in-place edit of many files on stderr, and
+// asks the user whether to go ahead, unless yes is set. If stdin is not a
+// terminal, there is nobody to ask, and the answer is no.
+func confirmInPlace(p *prog, yes bool) bool {
+	backups := "none"
+	if p.InPlaceBak != "" {
+		backups = prelude.BackupName("FILE", p.InPlaceBak)
+	}
+	prelude.Warn("golf: editing %d files in place, backups: %s", len(p.RawArgs), backups)
+	if yes {
+		return true
+	}
+	if st, err := os.Stdin.Stat(); err != nil || st.Mode()&os.ModeCharDevice == 0 {
+		prelude.Warn("golf: not a terminal; give --yes to go ahead")
+		return false
+	}
+	fmt.Fprint(os.Stderr, "golf: proceed? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.TrimSpace(strings.ToLower(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
 const helpString = `Command golf provides some Go one-liner fun.
 
 Invoke it with a snippet of Go code in the -e flag, which will be compiled
@@ -554,6 +2305,21 @@ github.com/gaal/golf.
 `
 
 func main() {
+	if len(os.Args) == 2 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor())
+	}
+
+	audit, err := openAudit(os.Args)
+	if err != nil {
+		prelude.Warn("golf: audit log: %v", err)
+		os.Exit(1)
+	}
+
+	remote := remoteHost()
+
+	rest, switches := takeSwitches(os.Args[1:])
+	os.Args = append(os.Args[:1], rest...)
+
 	// The standard Go flag package does not support flag clustering.
 	// This is too convenient to give up when golfing, so handle it ourselves.
 	decluster()
@@ -566,49 +2332,557 @@ func main() {
 		os.Exit(0)
 	}
 
+	// --json-doc is -j, reading records with the json source.
+	if *flgJSONDoc {
+		if *flgSource != "" {
+			prelude.Warn("golf: --json-doc can't be combined with --source")
+			os.Exit(1)
+		}
+		*flgSource = "json"
+		*flgJ = true
+	}
+	// --yaml likewise, with the yaml source.
+	if *flgYAML {
+		if *flgSource != "" {
+			prelude.Warn("golf: --yaml can't be combined with --source or --json-doc")
+			os.Exit(1)
+		}
+		*flgSource = "yaml"
+		*flgJ = true
+	}
+	if *flgXML != "" {
+		if *flgSource != "" || *flgPMap > 0 {
+			prelude.Warn("golf: --xml can't be combined with --source, --json-doc, --yaml or -Pmap")
+			os.Exit(1)
+		}
+		*flgSource = "xml"
+	}
+
 	// -F implies -a (which in turn implies -n...)
+	setF := false
 	flag.Visit(func(f *flag.Flag) {
 		if f.Name == "F" {
 			*flgA = true
+			setF = true
 		}
 	})
 
-	// Both -a and -p imply -n.
-	*flgN = *flgN || *flgP || *flgA
+	// --csv implies -a too, and takes a one-character -F as the delimiter.
+	if *flgCSV {
+		*flgA = true
+		if !setF {
+			*flgF = ","
+		} else if *flgF == `\t` {
+			*flgF = "\t"
+		}
+		if utf8.RuneCountInString(*flgF) != 1 {
+			prelude.Warn("golf: --csv: -F must be a single character, not %q", *flgF)
+			os.Exit(1)
+		}
+		if *flgBytes || *flgMmap || *flgSource != "" || *flgPMap > 0 {
+			prelude.Warn("golf: --csv can't be combined with -bytes, -mmap, --source or -Pmap")
+			os.Exit(1)
+		}
+	}
+
+	if *flgJ && (*flgBytes || *flgPMap > 0) {
+		prelude.Warn("golf: -j can't be combined with -bytes or -Pmap")
+		os.Exit(1)
+	}
+	if *flgAutoJoin && (*flgBytes || *flgPMap > 0) {
+		prelude.Warn("golf: --autojoin can't be combined with -bytes or -Pmap")
+		os.Exit(1)
+	}
+
+	// --maxsplit implies -a.
+	if *flgMaxSplit != 0 {
+		*flgA = true
+		if *flgMaxSplit < 0 || *flgCSV || *flgTSV || *flgFixed != "" {
+			prelude.Warn("golf: --maxsplit must be positive, and can't be combined with --csv, --tsv or --fixed")
+			os.Exit(1)
+		}
+	}
+
+	// --fixed implies -a.
+	splitSrc := "golfAutosplit()"
+	if *flgFixed != "" {
+		src, err := compileFixed(*flgFixed)
+		if err != nil {
+			prelude.Warn("golf: --fixed: %v", err)
+			os.Exit(1)
+		}
+		splitSrc = src
+		*flgA = true
+		if *flgCSV || *flgTSV || setF || *flgBytes {
+			prelude.Warn("golf: --fixed can't be combined with --csv, --tsv, -F or -bytes")
+			os.Exit(1)
+		}
+	}
+	switch {
+	case *flgCSV:
+		splitSrc = "golfCSVSplit()"
+	case *flgTSV:
+		splitSrc = "golfTSVSplit()"
+	}
+
+	// -H implies -a.
+	if *flgH {
+		*flgA = true
+		if *flgBytes || *flgSince != "" {
+			prelude.Warn("golf: -H can't be combined with -bytes or --since-last")
+			os.Exit(1)
+		}
+	}
+
+	// --tsv implies -a.
+	if *flgTSV {
+		*flgA = true
+		if *flgCSV || setF || *flgBytes || *flgPMap > 0 {
+			prelude.Warn("golf: --tsv can't be combined with --csv, -F, -bytes or -Pmap")
+			os.Exit(1)
+		}
+	}
+
+	// -Z is -l0, whatever order it's given in with -l.
+	if *flgZ {
+		flgL.on, flgL.ors = true, "\x00"
+	}
+
+	// --format implies -l and -a.
+	var formatSrc, formatTmpl string
+	if *flgFormat != "" {
+		src, fast, err := compileFormat(*flgFormat)
+		if err != nil {
+			prelude.Warn("golf: --format: %v", err)
+			os.Exit(1)
+		}
+		formatSrc = src
+		if !fast {
+			formatTmpl = *flgFormat
+		}
+		flgL.on = true
+		*flgA = true
+	}
+
+	// --project implies -lap, unless --format is doing the printing.
+	var projectSrc string
+	if *flgProject != "" {
+		src, err := compileProjection(*flgProject)
+		if err != nil {
+			prelude.Warn("golf: --project: %v", err)
+			os.Exit(1)
+		}
+		projectSrc = src
+		flgL.on = true
+		*flgA = true
+		*flgP = *flgP || *flgFormat == ""
+	}
+
+	// awk-style -e '/regexp/ { ... }' guards the block with a pattern.
+	for i, src := range *rawSrc {
+		var err error
+		if (*rawSrc)[i], err = expandPattern(src, *flgBytes); err != nil {
+			prelude.Warn("golf: -e %s: %v", src, err)
+			os.Exit(1)
+		}
+	}
+
+	// -m without -e prints the matching lines, like grep.
+	grepMode := false
+	if *flgM != "" {
+		if _, err := regexp.Compile(*flgM); err != nil {
+			prelude.Warn("golf: -m: %v", err)
+			os.Exit(1)
+		}
+		grepMode = len(*rawSrc) == 0
+		*flgP = *flgP || grepMode
+		// grep's -i ignores case, but golf's edits in place: refuse it
+		// rather than rewrite the files with only the matching lines.
+		if grepMode && (*inplace || *inplaceBak != "") {
+			prelude.Warn("golf: -m without -e can't be combined with -i or -I, which edit files in place; for case-insensitive matching, start REGEXP with (?i)")
+			os.Exit(1)
+		}
+	}
+
+	var lineRanges string
+	var linesFromEnd bool
+	if len(*flgLines) > 0 {
+		src, fromEnd, err := compileLineRanges(*flgLines)
+		if err != nil {
+			prelude.Warn("golf: --lines: %v", err)
+			os.Exit(1)
+		}
+		lineRanges, linesFromEnd = src, fromEnd
+	}
+	if len(*flgLines) > 0 && (*flgSince != "" || *inplace || *inplaceBak != "" || *outPattern != "") {
+		prelude.Warn("golf: --lines can't be combined with --since-last, -i, -I or -O")
+		os.Exit(1)
+	}
+	if *flgTail && (*inplace || *inplaceBak != "" || *outPattern != "" || *flgPar > 1 || *flgPMap > 0 || *flgMmap || *flgSince != "" || *flgBinary || linesFromEnd) {
+		prelude.Warn("golf: -tail can't be combined with -i, -I, -O, -P, -Pmap, -mmap, --since-last, --skip-binary or --lines counting from the end")
+		os.Exit(1)
+	}
+	if linesFromEnd && (*flgMmap || *flgSource != "") {
+		prelude.Warn("golf: --lines ranges counting from the end can't be combined with -mmap or --source")
+		os.Exit(1)
+	}
+
+	switch *flgSymlinks {
+	case "replace", "follow", "refuse":
+	default:
+		prelude.Warn("golf: --symlinks must be replace, follow or refuse, not %q", *flgSymlinks)
+		os.Exit(1)
+	}
+
+	if *flgOnLimit != "skip" && *flgOnLimit != "abort" {
+		prelude.Warn("golf: --on-limit must be skip or abort, not %q", *flgOnLimit)
+		os.Exit(1)
+	}
+
+	if *outFile != "" && *outAppend != "" {
+		prelude.Warn("golf: -o and -oa can't be combined")
+		os.Exit(1)
+	}
+
+	if *outPattern != "" && (*inplace || *inplaceBak != "" || *flgDryRun) {
+		prelude.Warn("golf: -O can't be combined with -i, -I or --dry-run")
+		os.Exit(1)
+	}
+
+	// Each instance would commit its own files, whatever the others did.
+	if *flgAtomic && *flgPar > 1 {
+		prelude.Warn("golf: --atomic-batch can't be combined with -P")
+		os.Exit(1)
+	}
+
+	if *flgSince != "" && (*inplace || *inplaceBak != "" || *outPattern != "" || strings.ContainsAny(*flgSince, `/\`)) {
+		prelude.Warn("golf: --since-last takes a job name, and can't be combined with -i")
+		os.Exit(1)
+	}
+
+	// Instances would race on the job's state file.
+	if *flgSince != "" && *flgPar > 1 {
+		prelude.Warn("golf: --since-last can't be combined with -P")
+		os.Exit(1)
+	}
+
+	if *flgPMap > 0 && (*flgBytes || *flgFormat != "" || *flgProject != "") {
+		prelude.Warn("golf: -Pmap can't be combined with -bytes, --format or --project")
+		os.Exit(1)
+	}
+
+	if *flgSource != "" && (*flgMmap || *flgSince != "") {
+		prelude.Warn("golf: --source can't be combined with -mmap or --since-last")
+		os.Exit(1)
+	}
+	// Records don't keep their terminators, so edits would replace them.
+	if *flgSource != "" && (*inplace || *inplaceBak != "" || *outPattern != "") {
+		prelude.Warn("golf: --source, --json-doc, --yaml and --xml can't be combined with -i, -I or -O")
+		os.Exit(1)
+	}
+
+	// -a, -p, -j, -bytes, --validate, -Pmap, --source, -r, --lines, -m, -bf,
+	// -Ef, -tail and --listen imply -n.
+	*flgN = *flgN || *flgP || *flgA || *flgJ || *flgBytes || *flgValidate != "" || *flgPMap > 0 || *flgSource != "" || *flgR || len(*flgLines) > 0 || *flgM != "" || len(*beginFile) > 0 || len(*endFile) > 0 || *flgTail || *flgListen != ""
 
-	// -I implies -i.
-	*inplace = *inplace || len(*inplaceBak) > 0
+	// NFields stands for the number of fields.
+	for _, srcs := range []*stringListValue{beginSrc, beginFile, rawSrc, endFile, endSrc} {
+		for i, src := range *srcs {
+			(*srcs)[i] = expandNFields(src, *flgBytes)
+		}
+	}
 
-	imps := []string{"io", "os", "regexp", "strconv", "strings", "fmt"}
+	// Next, NextFile and Stop stand for loop control in line mode.
 	if *flgN {
-		imps = append(imps, "bufio")
+		control := lineControl
+		if *flgPMap > 0 {
+			control = pmapControl
+		}
+		for i, src := range *rawSrc {
+			(*rawSrc)[i] = expandControl(src, control)
+		}
+		for _, srcs := range []*stringListValue{beginFile, endFile} {
+			for i, src := range *srcs {
+				(*srcs)[i] = expandControl(src, fileControl)
+			}
+		}
+	}
+
+	// -I implies -i. So does -O, which works the same but for the name of
+	// the output.
+	*inplace = *inplace || len(*inplaceBak) > 0 || *outPattern != ""
+
+	if *flgDryRun && !*inplace {
+		prelude.Warn("golf: --dry-run only applies to -i")
+		os.Exit(1)
+	}
+
+	args := flag.Args()
+	if runtime.GOOS == "windows" && remote == "" {
+		// cmd.exe doesn't expand wildcards.
+		args = globArgs(args)
+	}
+	goos := runtime.GOOS
+	if remote != "" {
+		if err := checkRemote(args, *flgN, *flgR, *flgPar); err != nil {
+			prelude.Warn("golf remote: %v", err)
+			os.Exit(1)
+		}
+		goarch := ""
+		if goos, goarch, err = remoteTarget(remote); err != nil {
+			prelude.Warn("golf remote: %v", err)
+			os.Exit(1)
+		}
+		os.Setenv("GOOS", goos)
+		os.Setenv("GOARCH", goarch)
+		os.Setenv("CGO_ENABLED", "0")
+	}
+	if *flgCont != "" {
+		if err := checkContainer(args, remote, *flgPar); err != nil {
+			prelude.Warn("golf: --in-container: %v", err)
+			os.Exit(1)
+		}
+		// A static binary runs in any Linux userland.
+		goos = "linux"
+		os.Setenv("GOOS", goos)
+		os.Setenv("GOARCH", runtime.GOARCH)
+		os.Setenv("CGO_ENABLED", "0")
+	}
+	if *flgR {
+		var err error
+		if args, err = expandDirs(args, *include, *exclude); err != nil {
+			prelude.Warn("golf: -r: %v", err)
+			os.Exit(1)
+		}
+	}
+	if *flgListen != "" {
+		if len(args) > 0 || *flgR {
+			prelude.Warn("golf: --listen reads connections instead of input files")
+			os.Exit(1)
+		}
+		if *inplace || *inplaceBak != "" || *outPattern != "" || remote != "" || *flgCont != "" || *flgTail || *flgSince != "" || linesFromEnd {
+			prelude.Warn("golf: --listen can't be combined with -i, -I, -O, -tail, --since-last, --lines counting from the end, --in-container or golf remote")
+			os.Exit(1)
+		}
+	}
+	if *flgWatch {
+		if *inplace || *inplaceBak != "" || *outPattern != "" || remote != "" || *flgCont != "" || *flgTail {
+			prelude.Warn("golf: --watch can't be combined with -i, -I, -O, -tail, --in-container or golf remote")
+			os.Exit(1)
+		}
+		if err := checkWatch(args); err != nil {
+			prelude.Warn("golf: --watch: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if grepMode && !*inplace && (len(args) > 1 || *flgR) {
+		// Say where matches came from, as grep does.
+		if *flgBytes {
+			*rawSrc = []string{`LineBytes = append([]byte(Filename+":"), LineBytes...)`}
+		} else {
+			*rawSrc = []string{`Line = Filename + ":" + Line`}
+		}
+	}
+
+	imps := []string{"archive/tar", "archive/zip", "bufio", "bytes", "compress/gzip", "io", "math", "os", "path/filepath", "regexp", "sort", "strconv", "strings", "sync", "time", "unicode", "fmt", "context", "errors", "os/signal", "syscall"}
+	if formatTmpl != "" {
+		imps = append(imps, "text/template")
+	}
+	hasURL := false
+	for _, arg := range args {
+		hasURL = hasURL || prelude.IsURL(arg)
+	}
+	if hasURL {
+		if *inplace && profile(*flgProfile) == "" {
+			prelude.Warn("golf: can't edit URLs in place")
+			os.Exit(1)
+		}
+	}
+	var blocks []string
+	for _, b := range [][]string{*beginSrc, *beginFile, *rawSrc, *endFile, *endSrc} {
+		blocks = append(blocks, b...)
+	}
+	script := strings.Join(blocks, "\n")
+	// Record sources, and -Pmap's reading ahead, can't tell the last line
+	// of an input in advance.
+	if (*flgPMap > 0 || *flgSource != "") && usesIdent(script, "Eof") {
+		prelude.Warn("golf: Eof can't be used with -Pmap, --source, --json-doc, --yaml or --xml")
+		os.Exit(1)
+	}
+	// -tail and --listen stop cleanly on an interrupt, through Ctx.
+	usesCtx := usesIdent(script, "Ctx") || *flgTail || *flgListen != ""
+
+	// Optional parts of the prelude are only embedded when they're needed.
+	feats := append([]string(nil), *flgFeature...)
+	for name, need := range map[string]bool{
+		"accesslog":  usesIdent(script, "ParseAccessLog", "AccessLog"),
+		"columns":    *flgFixed != "" || *flgProject != "",
+		"flipflop":   usesIdent(script, "Between", "FlipFlop", "NewFlipFlop"),
+		"format":     formatTmpl != "",
+		"hotspots":   *flgHot,
+		"kv":         usesIdent(script, "KV"),
+		"lines":      lineRanges != "",
+		"matchfile":  *flgMatch != "" || *flgExclude != "",
+		"mmap":       *flgMmap && goos != "windows",
+		"multimatch": usesIdent(script, "MultiMatcher", "MM"),
+		"pmap":       *flgPMap > 0,
+		"since":      *flgSince != "",
+		"sketch":     usesIdent(script, "Bloom", "BloomFilter", "TopK", "TopKCounter", "TopKItem", "HLL", "HyperLogLog"),
+		"source":     *flgSource != "" || usesIdent(script, "RecordSource", "RegisterSource", "SplitSource"),
+		"syslog":     usesIdent(script, "ParseSyslog", "Syslog"),
+		"owner":      *inplace && goos != "windows",
+		"csv":        *flgCSV,
+		"diff":       *flgDryRun,
+		"json":       *flgJ || usesIdent(script, "JQ", "JQS", "ToJSON", "ToJSONPretty", "PrintJSON", "PrintJSONPretty", "FromJSON", "FromJSONMap"),
+		"listen":     *flgListen != "",
+		"tail":       *flgTail,
+		"time":       usesIdent(script, "GTime", "Epoch", "FromEpoch"),
+		"tsv":        *flgTSV,
+		"url":        hasURL,
+		"xml":        *flgXML != "",
+		"yaml":       *flgSource == "yaml" || usesIdent(script, "ToYAML", "PrintYAML", "FromYAML"),
+	} {
+		if need {
+			feats = append(feats, name)
+		}
+	}
+	feats, err = prelude.ResolveFeatures(feats)
+	if err != nil {
+		prelude.Warn("golf: --feature: %v", err)
+		os.Exit(1)
+	}
+	var featSrc [][]byte
+	featSet := map[string]bool{}
+	for _, name := range feats {
+		featSet[name] = true
+		src, featImps, err := prelude.FeatureSource(name)
+		if err != nil {
+			prelude.Warn("golf: --feature: %v", err)
+			os.Exit(1)
+		}
+		featSrc = append(featSrc, src)
+		imps = append(imps, featImps...)
 	}
 	if len(*modules) > 0 {
 		imps = append(imps, *modules...)
 	}
 	imps = dedupe(imps)
 
+	// Output through --tee reaches a terminal as promptly as without, though
+	// the one-liner's stdout is then a pipe.
+	teeTerminal := *flgTee != "" && isTerminal(os.Stdout)
+
 	p := &prog{
-		BeginSrc:   *beginSrc,
-		RawSrc:     *rawSrc,
-		EndSrc:     *endSrc,
-		RawArgs:    flag.Args(),
-		Imports:    imps,
-		FlgN:       *flgN,
-		FlgP:       *flgP,
-		FlgL:       *flgL,
-		FlgA:       *flgA,
-		FlgF:       *flgF,
-		InPlace:    *inplace,
-		InPlaceBak: *inplaceBak,
-		Warnings:   *warnings,
-		Goimports:  *flgG,
-		Keep:       *flgKeep,
-		Prelude:    prelude.Source(),
+		BeginSrc:     *beginSrc,
+		RawSrc:       *rawSrc,
+		EndSrc:       *endSrc,
+		BeginFileSrc: *beginFile,
+		EndFileSrc:   *endFile,
+		RawArgs:      args,
+		ScriptArgs:   scriptArgs,
+		Switches:     switches,
+		Imports:      imps,
+		FlgN:         *flgN,
+		FlgP:         *flgP,
+		FlgL:         flgL.on,
+		ORS:          flgL.ors,
+		FlgA:         *flgA,
+		FlgF:         *flgF,
+		InPlace:      *inplace,
+		InPlaceBak:   *inplaceBak,
+		OutPattern:   *outPattern,
+		OutFile:      *outFile + *outAppend,
+		OutAppend:    *outAppend != "",
+		Quarantine:   *flgQuar,
+		AtomicBatch:  *flgAtomic,
+		Warnings:     *warnings,
+		Strict:       *flgStrict,
+		Quiet:        *quiet,
+		Match:        *flgMatch,
+		Exclude:      *flgExclude,
+		MatchRE:      *flgM,
+		LineRanges:   lineRanges,
+		LinesFromEnd: linesFromEnd,
+		MaxLine:      *maxLine,
+		Bytes:        *flgBytes,
+		ReuseFields:  !keepsFields(script),
+		Flush:        *flgFlush || teeTerminal,
+		Hotspots:     *flgHot,
+		FormatSrc:    formatSrc,
+		FormatTmpl:   formatTmpl,
+		ProjectSrc:   projectSrc,
+		DefaultField: *flgDefault,
+		Validate:     *flgValidate,
+		ValidateSrc:  expandNFields(*flgValidate, *flgBytes),
+		ErrorsTo:     *flgErrorsTo,
+		Goimports:    *flgG,
+		Keep:         *flgKeep,
+		Tmpdir:       *flgTmpdir,
+		Parallel:     *flgPar,
+		PMap:         *flgPMap,
+		MaxFileSize:  int64(*flgMaxSize),
+		SinceLast:    *flgSince,
+		FileTimeout:  *flgFileTime,
+		LimitAbort:   *flgOnLimit == "abort",
+		Symlinks:     *flgSymlinks,
+		UsesCtx:      usesCtx,
+		Timeout:      *flgTimeout,
+		Source:       *flgSource,
+		SkipBinary:   *flgBinary,
+		NoTerminal:   *flgNoTerm,
+		KeepMtime:    *flgMtime,
+		Prelude:      prelude.Source(),
+		FeatureSrc:   featSrc,
+		Features:     featSet,
+		Mmap:         *flgMmap && goos != "windows",
+		Remote:       remote,
+		Container:    *flgCont,
+		Watch:        *flgWatch,
+		Listen:       *flgListen,
+		ListenIdle:   *flgIdle,
+		CSV:          *flgCSV,
+		TSV:          *flgTSV,
+		Header:       *flgH,
+		SplitSrc:     splitSrc,
+		MaxSplit:     *flgMaxSplit,
+		AutoJoin:     *flgAutoJoin,
+		JSON:         *flgJ,
+		XML:          *flgXML,
+	}
+	if err := checkProfile(profile(*flgProfile), p, *modules); err != nil {
+		prelude.Warn("golf: %v", err)
+		os.Exit(1)
+	}
+	if p.InPlace && p.OutPattern == "" && !*flgDryRun && (len(p.RawArgs) > *flgConfirm || *flgR) && !confirmInPlace(p, *flgYes) {
+		os.Exit(1)
 	}
 	if err := p.transform(); err != nil {
 		prelude.Warn("golf: %v", err)
 		os.Exit(1)
 	}
-	os.Exit(p.run())
+	if err := redirectStdout(*outFile, *outAppend); err != nil {
+		prelude.Warn("golf: %v", err)
+		os.Exit(1)
+	}
+	var tee *teeWriter
+	if *flgTee != "" {
+		if tee, err = startTee(*flgTee); err != nil {
+			prelude.Warn("golf: %v", err)
+			os.Exit(1)
+		}
+	}
+	status := p.run()
+	if tee != nil {
+		if err := tee.err(); err != nil {
+			prelude.Warn("golf: %v", err)
+			if status == 0 {
+				status = 1
+			}
+		}
+	}
+	if err := audit.finish(p, status); err != nil {
+		prelude.Warn("golf: audit log: %v", err)
+	}
+	p.exit(status)
 }
@@ -1,11 +1,25 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -50,8 +64,18 @@ func TestBasics(t *testing.T) {
 		{"hello, world", `Print("hello, world")`, nil, "hello, world"},
 		{"Print numbers", `Print(42, 54)`, nil, "42 54"},
 		{"output -l", `Print("hello, world")`, []string{"-l"}, "hello, world\n"},
+		{"output -l0", `Print("hello", "world")`, []string{"-l0"}, "hello world\x00"},
+		{"output -l=STR", `Print("hello")`, []string{"-l=;"}, "hello;"},
+		{"output -Z", `Print("hello world"); Print("a\nb")`, []string{"-Z", "-l=;"}, "hello world\x00a\nb\x00"},
+		{"SetPrecision", `Print(1.0/3, 2e21); SetPrecision(2); Print(1.0/3, float32(2e7), Round(2.5, 0))`, []string{"-l"}, "0.3333333333333333 2e+21\n0.33 20000000.00 3.00\n"},
+		{"buffered", `Print(1); fmt.Print(2); Print(3)`, nil, "213"},
+		{"-flush", `Print(1); fmt.Print(2); Print(3)`, []string{"-flush"}, "123"},
 		{"BEGIN/END", `i++`, []string{"-b", "i := 0", "-BEGIN", "i = 10", "-END", "i *= 2", "-E", "Print(i)"}, "22"},
 		{"-M", "pi := math.Pi; Print(strconv.Itoa(int(pi)))", []string{"-M", "math", "-M", "strconv"}, "3"},
+		{"--timeout", "<-Ctx.Done(); Print(Ctx.Err())", []string{"--timeout", "10ms"}, "context deadline exceeded"},
+		{"--feature", `Print(reflect.TypeOf(golfMapRun))`, []string{"--feature", "pmap", "-M", "reflect"}, "func([]*main.golfMapJob, int, func(*main.golfMapJob))"},
+		{"--e64", `Print(1)`, []string{"--e64", base64.StdEncoding.EncodeToString([]byte("Print(2)"))}, "12"},
+		{"--e64 gzip", ``, []string{"--e64", base64.StdEncoding.EncodeToString([]byte(gzipped("Print(`a\nb`)")))}, "a\nb"},
 		{"-g", "pi := math.Pi; Print(strconv.Itoa(int(pi)))", []string{"-g"}, "3"},
 	}
 	for _, d := range data {
@@ -70,6 +94,86 @@ func TestBasics(t *testing.T) {
 	}
 }
 
+// TestExamples runs the worked examples in testdata/examples.txt, whose
+// header explains their format.
+func TestExamples(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "examples.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	for start := 0; start < len(lines); {
+		end := start
+		for end < len(lines) && lines[end] != "" {
+			end++
+		}
+		var args []string
+		var at int
+		var stdin, want strings.Builder
+		wantCode := 0
+		for i, l := range lines[start:end] {
+			switch {
+			case strings.HasPrefix(l, "$ golf "):
+				args, at = shellWords(l[len("$ golf "):]), start+i+1
+			case strings.HasPrefix(l, "<"):
+				stdin.WriteString(strings.TrimPrefix(l[1:], " ") + "\n")
+			case strings.HasPrefix(l, ">"):
+				want.WriteString(strings.TrimPrefix(l[1:], " ") + "\n")
+			case strings.HasPrefix(l, "? "):
+				fmt.Sscan(l[2:], &wantCode)
+			}
+		}
+		start = end + 1
+		if args == nil {
+			continue // The header.
+		}
+		t.Run(fmt.Sprintf("examples.txt:%d", at), func(t *testing.T) {
+			t.Parallel()
+			cmd := exec.Command(testBin, args...)
+			cmd.Stdin = strings.NewReader(stdin.String())
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+			if _, ok := err.(*exec.ExitError); err != nil && !ok {
+				t.Fatalf("%q: %v", args, err)
+			}
+			if code := cmd.ProcessState.ExitCode(); code != wantCode {
+				t.Errorf("%q: exit code %d, want %d\n%s", args, code, wantCode, stderr.String())
+			}
+			if diff := cmp.Diff(want.String(), string(out)); diff != "" {
+				t.Errorf("%q: unexpected stdout. diff(-want,+got):\n%v", args, diff)
+			}
+		})
+	}
+}
+
+// shellWords splits s into words at spaces, as sh would, but only
+// understanding single quotes.
+func shellWords(s string) []string {
+	var words []string
+	var w strings.Builder
+	inWord, quoted := false, false
+	for _, c := range s {
+		switch {
+		case c == '\'':
+			inWord, quoted = true, !quoted
+		case c == ' ' && !quoted:
+			if inWord {
+				words = append(words, w.String())
+				w.Reset()
+			}
+			inWord = false
+		default:
+			inWord = true
+			w.WriteRune(c)
+		}
+	}
+	if inWord {
+		words = append(words, w.String())
+	}
+	return words
+}
+
 func TestLineModes(t *testing.T) {
 	data := []struct {
 		desc         string
@@ -94,11 +198,290 @@ func TestLineModes(t *testing.T) {
 			map[string]string{"f1": "Once\t\t\tupon\t\t\ta time\nthere\twas\ta"},
 			nil,
 			"upon\nwas\n"},
+		{"-a keeps Fields the script holds on to", `if prev != nil { Print(prev[0], Field(1)) }; prev = Fields[:1]`,
+			[]string{"-b", "var prev []string", "-lan", "f1"},
+			map[string]string{"f1": "a b\nc d\ne f\n"},
+			nil,
+			"a c\nc e\n"},
+		{"-p preserves line endings", ``,
+			[]string{"-p", "f1"},
+			map[string]string{"f1": "dos\r\nunix\nno newline"},
+			nil,
+			"dos\r\nunix\nno newline"},
+		{"-lp trims CRLF", `Line += strconv.Quote(LineEnding)`,
+			[]string{"-lp", "f1"},
+			map[string]string{"f1": "dos\r\nunix\nno newline"},
+			nil,
+			"dos\"\\r\\n\"\nunix\"\\n\"\nno newline\"\"\n"},
+		{"-lp trims CR and mixed endings", `Line += strconv.Quote(LineEnding)`,
+			[]string{"-lp", "mac", "mixed"},
+			map[string]string{"mac": "old\rmac\r", "mixed": "a\rb\r\nc\n\r\nd"},
+			nil,
+			"old\"\\r\"\nmac\"\\r\"\na\"\\r\"\nb\"\\r\\n\"\nc\"\\n\"\n\"\\r\\n\"\nd\"\"\n"},
+		{"-mmap -p preserves mixed endings", ``,
+			[]string{"-mmap", "-p", "mixed"},
+			map[string]string{"mixed": "a\rb\r\nc\n\r\nd"},
+			nil,
+			"a\rb\r\nc\n\r\nd"},
+		{"--source nul", `Printf("%s:%d:%q\n", Filename, LineNum, Line)`,
+			[]string{"-l", "--source", "nul", "f1", "f2"},
+			map[string]string{"f1": "a b\x00c\nd\x00", "f2": "e"},
+			nil,
+			"f1:1:\"a b\"\nf1:2:\"c\\nd\"\nf2:1:\"e\"\n"},
+		{"--source registered in BEGIN", ``,
+			[]string{"-b", `RegisterSource("words", func() RecordSource { return SplitSource(bufio.ScanWords) })`, "--source", "words", "-p", "f1"},
+			map[string]string{"f1": "once upon\n a  time"},
+			nil,
+			"once\nupon\na\ntime\n"},
+		{"-r", `Print(Filename)`,
+			[]string{"-lr", "f1", "d"},
+			map[string]string{"f1": "x", "d/b": "x", "d/a/c": "x\ny"},
+			nil,
+			"f1\nd/a/c\nd/a/c\nd/b\n"},
+		{"-r --include --exclude", `Print(Filename)`,
+			[]string{"-lr", "--include", "*.go", "--include=*.s", "--exclude", "skip*", "d"},
+			map[string]string{"d/a.go": "x", "d/b.s": "x", "d/c.txt": "x", "d/skip.go": "x", "d/skipdir/e.go": "x", "d/sub/f.go": "x"},
+			nil,
+			"d/a.go\nd/b.s\nd/sub/f.go\n"},
+		{"-r -i", `Line = strings.ToUpper(Line)`,
+			[]string{"-rip", "--yes", "d"},
+			map[string]string{"d/f1": "a\n", "d/e/f2": "b\n"},
+			map[string]string{"d/f1": "A\n", "d/e/f2": "B\n"},
+			""},
+		{"--skip-binary", `Print(Filename)`,
+			[]string{"-ln", "--skip-binary", "f1", "f2", "f3"},
+			map[string]string{"f1": "text\n", "f2": "PNG\x00\x01\n", "f3": "more text"},
+			nil,
+			"f1\nf3\n"},
+		{"--skip-binary -i", `Line = strings.ToUpper(Line)`,
+			[]string{"-ip", "--skip-binary", "f1", "f2"},
+			map[string]string{"f1": "text\n", "f2": "bin\x00ary\n"},
+			map[string]string{"f1": "TEXT\n", "f2": "bin\x00ary\n"},
+			""},
+		{"SetSink", `SetSink(FileSink(Field(1) + ".out")); Print(Field(2)); SetSink(StdoutSink()); Print(LineNum)`,
+			[]string{"-lan", "f1", "f2"},
+			map[string]string{"f1": "a 1\nb 2\na 3\n", "f2": "b 4\n"},
+			map[string]string{"f1": "a 1\nb 2\na 3\n", "f2": "b 4\n", "a.out": "1\n3\n", "b.out": "2\n4\n"},
+			"1\n2\n3\n1\n"},
+		{"SetSink -i", `if Line == "b" { SetSink(FileSink("log")) }; Print(Filename)`,
+			[]string{"-lin", "f1", "f2"},
+			map[string]string{"f1": "a\nb\n", "f2": "c\n"},
+			map[string]string{"f1": "f1\n", "f2": "f2\n", "log": "f1\n"},
+			""},
+		{"--dry-run", `Line = strings.ToUpper(Line)`,
+			[]string{"-I", ".bak", "--dry-run", "-p", "f1", "f2"},
+			map[string]string{"f1": "a\n", "f2": "B\n"},
+			nil,
+			"--- f1\n+++ f1\n@@ -1 +1 @@\n-a\n+A\n"},
+		{"hooks", ``,
+			[]string{"-lp", "-b", `n := 0
+				OnFile(func() { Print("# " + Filename) })
+				OnLine(func() bool { return !strings.HasPrefix(Line, "#") })
+				OnLine(func() bool { n++; return true })
+				AtExit(func() { Printf("%d kept\n", n) })`, "-i", "f1", "f2"},
+			map[string]string{"f1": "a\n#b\n", "f2": "#c\n"},
+			map[string]string{"f1": "# f1\na\n", "f2": "# f2\n"},
+			"1 kept\n"},
+		{"long lines", `Print(len(Line))`,
+			[]string{"-ln", "f1"},
+			map[string]string{"f1": strings.Repeat("x", 100000) + "\n"},
+			nil,
+			"100000\n"},
+		{"-bytes -a", `FieldsBytes[0], FieldsBytes[1] = FieldsBytes[1], FieldsBytes[0]; LineBytes = bytes.Join(FieldsBytes, []byte(":"))`,
+			[]string{"-bytes", "-lpF", ":", "f1"},
+			map[string]string{"f1": "a:b\r\nc:d:e\n"},
+			nil,
+			"b:a\nd:c:e\n"},
+		{"-mmap", `Line = Field(2) + strconv.Quote(LineEnding)`,
+			[]string{"-mmap", "-lap", "f1", "f2"},
+			map[string]string{"f1": "a b\r\nc d\n\ne f", "f2": ""},
+			nil,
+			"b\"\\r\\n\"\nd\"\\n\"\n\"\\n\"\nf\"\"\n"},
+		{"-mmap -bytes", ``,
+			[]string{"-mmap", "-bytes", "-p", "--maxline", "3", "f1"},
+			map[string]string{"f1": "abc\nd"},
+			nil,
+			"abc\nd"},
+		{"archives", `Printf("%s:%d:%s", Filename, LineNum, Line)`,
+			[]string{"-n", "a.tgz", "f1", "b.zip"},
+			map[string]string{"a.tgz": gzipped(tarball("x.log", "1\n2\n", "d/y.log", "3\n")), "f1": "4\n", "b.zip": zipped("z", "5\n")},
+			nil,
+			"a.tgz::x.log:1:1\na.tgz::x.log:2:2\na.tgz::d/y.log:1:3\nf1:1:4\nb.zip::z:1:5\n"},
+		{"--format", ``,
+			[]string{"--format", `{{.F 2}}:{{.F -2}}"{{.LineNum}}`, "f1"},
+			map[string]string{"f1": "a b\r\nc d\n"},
+			nil,
+			"b:a\"1\nd:c\"2\n"},
+		{"--format with -e and text/template", `if LineNum == 1 { continue Line }`,
+			[]string{"--format", `{{printf "%3s" (.F 1)}} {{len .Fields}} {{.Filename}}`, "f1"},
+			map[string]string{"f1": "a b\nc d\n"},
+			nil,
+			"  c 2 f1\n"},
+		{"--project", ``,
+			[]string{"-F", ":", "--project", "3,1,4-,-2,9", "f1"},
+			map[string]string{"f1": "a:b:c:d:e\nf:g\n"},
+			nil,
+			"c a d e a b \n f f g \n"},
+		{"--csv", `if LineNum == 3 { Fields[0] = "x,y" }`,
+			[]string{"--csv", "-p", "f1"},
+			map[string]string{"f1": "a,\"b\"\"c\"\r\n\"d,e\",\"f\ng\"\nh,i\n"},
+			nil,
+			"a,\"b\"\"c\"\r\n\"d,e\",\"f\ng\"\n\"x,y\",i\n"},
+		{"--csv with -F and --project", ``,
+			[]string{"--csv", "-F", ";", "--project", "2,1", "f1"},
+			map[string]string{"f1": "a;\"b;c\"\n"},
+			nil,
+			"\"b;c\";a\n"},
+		{"--csv with a multibyte -F", `Fields[1] = "x¦y"`,
+			[]string{"--csv", "-F", "¦", "-p", "f1"},
+			map[string]string{"f1": "a¦b¦c\n"},
+			nil,
+			"a¦\"x¦y\"¦c\n"},
+		{"--tsv", `if LineNum == 1 { Fields[0] += "\t" }`,
+			[]string{"--tsv", "-p", "f1"},
+			map[string]string{"f1": "a\\\\b\tc\\nd\n\\t\tx\n"},
+			nil,
+			"a\\\\b\\t\tc\\nd\n\\t\tx\n"},
+		{"--tsv fields", `Print(Field(1) + "|" + Field(2))`,
+			[]string{"--tsv", "-l", "f1"},
+			map[string]string{"f1": "a\\\\b\tc\\nd\r\n"},
+			nil,
+			"a\\b|c\nd\n"},
+		{"-H", `if Col("n") != "1" { Line = Col("x") + Col("n") }`,
+			[]string{"-H", "-lp", "f1", "f2"},
+			map[string]string{"f1": "n x\n1 a\n2 b\n", "f2": "x n\nc 3\n"},
+			nil,
+			"n x\n1 a\nb2\nx n\nc3\n"},
+		{"-H with -F and no -l", `Line = Col("a") + "=" + Col("b")`,
+			[]string{"-H", "-F", ",", "-p", "f1"},
+			map[string]string{"f1": "a,b\nc,d\n"},
+			nil,
+			"a,b\nc=d\n"},
+		{"--fixed", `Print(Field(1) + "|" + Field(2) + "|" + Field(3))`,
+			[]string{"--fixed", "1-6,7-9,10-", "-l", "f1"},
+			map[string]string{"f1": "Jo Ann 33 New York\nÉmile  7\n"},
+			nil,
+			"Jo Ann|33|New York\nÉmile|7|\n"},
+		{"-j", `if J != nil { Print(JQS("user.name") + ":" + JQS("tags[0]")) }`,
+			[]string{"-j", "-l", "f1"},
+			map[string]string{"f1": "{\"user\": {\"name\": \"ann\"}, \"tags\": [3]}\n# comment\n{}\n"},
+			nil,
+			"ann:3\n:\n"},
+		{"PrintJSON", `PrintJSON(map[string]interface{}{"user": Field(1), "uid": GAtoi(Field(2))})`,
+			[]string{"-F", ":", "-l", "f1"},
+			map[string]string{"f1": "ann:7\nbob:x\n"},
+			nil,
+			"{\"uid\":7,\"user\":\"ann\"}\n{\"uid\":0,\"user\":\"bob\"}\n"},
+		{"--json-doc", `Print(LineNum, JQS("id"), Line)`,
+			[]string{"--json-doc", "-l", "f1", "f2"},
+			map[string]string{"f1": "[\n  {\"id\": 1,\n   \"tags\": [\"a\"]},\n  {\"id\": 2}\n]\n", "f2": "{\"id\": 3}"},
+			nil,
+			"1 1 {\"id\":1,\"tags\":[\"a\"]}\n2 2 {\"id\":2}\n1 3 {\"id\":3}\n"},
+		{"--yaml", `Print(JQS("kind") + "/" + JQS("metadata.name")); PrintYAML(J)`,
+			[]string{"--yaml", "-l", "f1"},
+			map[string]string{"f1": "# web\nkind: Deployment\nmetadata:\n  name: web\n---\nkind: Service\nmetadata: {name: web, port: 80}\n"},
+			nil,
+			"Deployment/web\n---\n# web\nkind: Deployment\nmetadata:\n  name: web\nService/web\n---\nkind: Service\nmetadata: {name: web, port: 80}\n"},
+		{"--xml", `Print(X["@id"], X["title"], Line)`,
+			[]string{"--xml", "item", "-l", "f1"},
+			map[string]string{"f1": "<feed>\n<item id=\"1\">\n  <title>A</title>\n</item>\n<item id=\"2\"><title>B</title></item>\n</feed>\n"},
+			nil,
+			"1 A <item id=\"1\">\n  <title>A</title>\n</item>\n2 B <item id=\"2\"><title>B</title></item>\n"},
+		{"KV", `kv := KV(); Print(kv["user"] + "|" + kv["msg"])`,
+			[]string{"-ln", "f1"},
+			map[string]string{"f1": "level=info user=ann msg=\"logged in\"\nlevel=warn msg=bye\n"},
+			nil,
+			"ann|logged in\n|bye\n"},
+		{"ParseAccessLog", `if r := ParseAccessLog(Line); r != nil { Print(r.Status, r.Method, r.Path) }`,
+			[]string{"-ln", "f1"},
+			map[string]string{"f1": "127.0.0.1 - - [01/Jan/2024:00:00:00 +0000] \"GET /x HTTP/1.1\" 404 0 \"-\" \"curl\"\njunk\n"},
+			nil,
+			"404 GET /x\n"},
+		{"ParseSyslog", `if m := ParseSyslog(Line); m != nil { Print(m.Host + "|" + m.Tag + "|" + m.PID + "|" + m.Msg) }`,
+			[]string{"-ln", "f1"},
+			map[string]string{"f1": "Mar  7 09:00:01 db CRON[42]: (root) CMD (true)\n<14>1 2024-03-07T09:00:02Z db app - - - hi\n"},
+			nil,
+			"db|CRON|42|(root) CMD (true)\ndb|app||hi\n"},
+		{"GTime", `Print(Epoch(GTime(Field(1))) - Epoch(GTime(Field(2))))`,
+			[]string{"-lan", "-F", ",", "f1"},
+			map[string]string{"f1": "2024-01-02T03:04:05Z,1704164640\n02/Jan/2024:04:04:05 +0100,1704164645000\n"},
+			nil,
+			"5\n0\n"},
+		{"--autojoin", `if LineNum == 1 { Fields[1] = "x" }`,
+			[]string{"--autojoin", "-F", ":", "-p", "f1"},
+			map[string]string{"f1": "a:b\r\nc:d\n"},
+			nil,
+			"a x\r\nc:d\n"},
+		{"--maxsplit", `Print(Field(-1))`,
+			[]string{"-F", ":", "--maxsplit", "2", "-l", "f1"},
+			map[string]string{"f1": "url:http://x:80\nk\n"},
+			nil,
+			"http://x:80\nk\n"},
+		{"NFields", `Fields = Fields[:NFields-1]; Print(NFields)`,
+			[]string{"-al", "f1"},
+			map[string]string{"f1": "a b c\nd\n"},
+			nil,
+			"2\n0\n"},
+		{"--default-field", ``,
+			[]string{"--project", "1,3,2-", "--default-field", "NA", "f1"},
+			map[string]string{"f1": "a b c\nd\n"},
+			nil,
+			"a c b c\nd NA\n"},
+		{"--validate", ``,
+			[]string{"-ap", "--validate", "len(Fields) == 2", "--errors-to", "bad", "f1"},
+			map[string]string{"f1": "a b\nc\nd e\n"},
+			map[string]string{"f1": "a b\nc\nd e\n", "bad": "f1:2: len(Fields) == 2: c\n"},
+			"a b\nd e\n"},
+		{"-P", `Printf("%s:%d:%s", Filename, LineNum, Line)`,
+			[]string{"-P", "2", "-b", "time.Sleep(time.Duration(len(os.Args[1])) * 10 * time.Millisecond)", "-n", "f123", "f1", "f12"},
+			map[string]string{"f1": "a\nb\n", "f12": "c\n", "f123": "d\ne"},
+			nil,
+			"f123:1:d\nf123:2:ef1:1:a\nf1:2:b\nf12:1:c\n"},
+		{"-Pmap", `if Field(2) == "skip" { return }; Line = strings.ToUpper(Field(2))`,
+			[]string{"-Pmap", "3", "-lap", "f1", "f2"},
+			map[string]string{"f1": strings.Repeat("1 a\n2 skip\n3 b\n", 500), "f2": "4 c"},
+			nil,
+			strings.Repeat("A\nB\n", 500) + "C\n"},
+		{"--match-file", ``,
+			[]string{"-p", "--match-file", "allow", "f1"},
+			map[string]string{"f1": "apple\nbanana\ncherry\ndate\n", "allow": "nan\n/^d/\n\nerr\n"},
+			nil,
+			"banana\ncherry\ndate\n"},
+		{"--exclude-file", ``,
+			[]string{"-lp", "--exclude-file", "deny", "f1"},
+			map[string]string{"f1": "apple\nbanana\ncherry\ndate\n", "deny": "nan\r\n/e$/\r\n"},
+			nil,
+			"cherry\n"},
+		{"--max-file-size", ``,
+			[]string{"-p", "--max-file-size", "1K", "f1", "f2", "f3"},
+			map[string]string{"f1": "small\n", "f2": strings.Repeat("x", 1025), "f3": strings.Repeat("y", 1024)},
+			nil,
+			"small\n" + strings.Repeat("y", 1024)},
+		{"-i --per-file-timeout", `Line = "x"`,
+			[]string{"-lpi", "--per-file-timeout", "1ns", "f1", "f2"},
+			map[string]string{"f1": "a\r\nb", "f2": "c\n"},
+			nil,
+			""},
 		{"-lpi", `Line = strings.ToUpper(Line)`,
 			[]string{"-lpi", "f1", "f2"},
 			map[string]string{"f1": "Once upon a time\nthere was a", "f2": "Go programmer\n"},
 			map[string]string{"f1": "ONCE UPON A TIME\nTHERE WAS A\n", "f2": "GO PROGRAMMER\n"},
 			""},
+		{"-i --validate quarantines", `Line = strings.ToUpper(Line)`,
+			[]string{"-lpi", "--validate", `Line != "bad"`, "f1", "f2"},
+			map[string]string{"f1": "ok\r\nbad\r\nfine", "f2": "bad\n"},
+			map[string]string{"f1": "OK\nFINE\n", "f1.rejected": "bad\r\n", "f2": "", "f2.rejected": "bad\n"},
+			""},
+		{"-i on gzip", `Line = strings.ToUpper(Line)`,
+			[]string{"-lpi", "f1.gz", "f2"},
+			map[string]string{"f1.gz": gzipped("a\nb\n"), "f2": "c\n"},
+			map[string]string{"f1.gz": gzipped("A\nB\n"), "f2": "C\n"},
+			""},
+		{"-i --yes", `Line = "x"`,
+			[]string{"-lpi", "--confirm-over", "1", "--yes", "f1", "f2"},
+			map[string]string{"f1": "a\n", "f2": "b\n"},
+			map[string]string{"f1": "x\n", "f2": "x\n"},
+			""},
 		{"-lp -I .bak", `Line = strings.ToUpper(Line); fmt.Fprintln(os.Stdout, LineNum)`,
 			[]string{"-lp", "-I", ".bak", "f1", "f2"},
 			map[string]string{"f1": "Once upon a time\nthere was a", "f2": "Go programmer\n"},
@@ -106,6 +489,13 @@ func TestLineModes(t *testing.T) {
 				"f1.bak": "Once upon a time\nthere was a", "f2.bak": "Go programmer\n",
 			},
 			"1\n2\n1\n"},
+		{"-I --atomic-batch", `Line = strings.ToUpper(Line)`,
+			[]string{"-lp", "-I", ".bak", "--atomic-batch", "f1", "f2"},
+			map[string]string{"f1": "Once upon a time\nthere was a", "f2": "Go programmer\n"},
+			map[string]string{"f1": "ONCE UPON A TIME\nTHERE WAS A\n", "f2": "GO PROGRAMMER\n",
+				"f1.bak": "Once upon a time\nthere was a", "f2.bak": "Go programmer\n",
+			},
+			""},
 		{"-lp -I orig_*", `Line = strings.ToUpper(Line)`,
 			[]string{"-lp", "-I", "orig_*", "f1", "f2"},
 			map[string]string{"f1": "Once upon a time\nthere was a", "f2": "Go programmer\n"},
@@ -113,6 +503,153 @@ func TestLineModes(t *testing.T) {
 				"orig_f1": "Once upon a time\nthere was a", "orig_f2": "Go programmer\n",
 			},
 			""},
+		{"-pi.bak", `Line = strings.ToUpper(Line)`,
+			[]string{"-pi.bak", "f1"},
+			map[string]string{"f1": "a\n"},
+			map[string]string{"f1": "A\n", "f1.bak": "a\n"},
+			""},
+		{"-ib", `Line = strings.ToUpper(Line)`,
+			[]string{"-lpib", "f1"},
+			map[string]string{"f1": "a\n"},
+			map[string]string{"f1": "A\n", "f1b": "a\n"},
+			""},
+		{"-O", `Line = strings.ToUpper(Line)`,
+			[]string{"-p", "-O", "*.out", "f1", "f2"},
+			map[string]string{"f1": "a\n", "f2": "b\n"},
+			map[string]string{"f1": "a\n", "f2": "b\n", "f1.out": "A\n", "f2.out": "B\n"},
+			""},
+		{"-O dir/", `Line = strings.ToUpper(Line)`,
+			[]string{"-p", "-O", "out/", "d/f1"},
+			map[string]string{"d/f1": "a\n"},
+			map[string]string{"d/f1": "a\n", "out/d/f1": "A\n"},
+			""},
+		{"-o", `Print(Line + "!")`,
+			[]string{"-ln", "-o", "out", "f1"},
+			map[string]string{"f1": "a\nb\n"},
+			map[string]string{"f1": "a\nb\n", "out": "a!\nb!\n"},
+			""},
+		{"-oa", `Print(Line + "!")`,
+			[]string{"-ln", "-oa", "out", "f1"},
+			map[string]string{"f1": "a\n", "out": "old\n"},
+			map[string]string{"out": "old\na!\n"},
+			""},
+		{"--tee", `Print(Line + "!")`,
+			[]string{"-ln", "--tee", "out", "f1"},
+			map[string]string{"f1": "a\nb\n"},
+			map[string]string{"out": "a!\nb!\n"},
+			"a!\nb!\n"},
+		{"--lines", `Printf("%d:%s\n", LineNum, Line)`,
+			[]string{"-ln", "--lines", "2:3", "--lines", "-1", "f1", "f2"},
+			map[string]string{"f1": "a\nb\nc\nd\ne\n", "f2": "x\ny"},
+			nil,
+			"2:b\n3:c\n5:e\n2:y\n"},
+		{"--lines from the end", `Printf("%d:%s\n", LineNum, Line)`,
+			[]string{"-ln", "--lines", "-3:-2", "f1"},
+			map[string]string{"f1": "a\nb\nc\nd\n"},
+			nil,
+			"2:b\n3:c\n"},
+		{"pattern { action }", `/^[0-9]/ { Print(Line) }`,
+			[]string{"-ln", "f1"},
+			map[string]string{"f1": "1\nx\n2\n"},
+			nil,
+			"1\n2\n"},
+		{"expression { action }", `LineNum > 1 && Field(1) != "x" { Print(Field(2)) }`,
+			[]string{"-lan", "f1"},
+			map[string]string{"f1": "a 1\nx 2\nb 3\n"},
+			nil,
+			"3\n"},
+		{"Between", `Between("^<", ">$") { Print(Line) }`,
+			[]string{"-ln", "f1"},
+			map[string]string{"f1": "a\n<b\nc>\nd\n<e>\n"},
+			nil,
+			"<b\nc>\n<e>\n"},
+		{"-bf and -Ef", `n++`,
+			[]string{"-l", "-bf", `n := 0; Print("<" + Filename)`, "-Ef", `Print(n, ">")`, "f1", "f2"},
+			map[string]string{"f1": "a\nb\n", "f2": "c\n"},
+			nil,
+			"<f1\n2 >\n<f2\n1 >\n"},
+		{"-bf with -i", ``,
+			[]string{"-pi", "-BEGINFILE", `Print("# " + Filename + "\n")`, "f1"},
+			map[string]string{"f1": "a\n"},
+			map[string]string{"f1": "# f1\na\n"},
+			""},
+		{"Eof", `if Eof() { Print(Filename + ":" + Line) }`,
+			[]string{"-ln", "f1", "f2", "f3"},
+			map[string]string{"f1": "a\nb\n", "f2": "c\nd", "f3": "e\r\n"},
+			nil,
+			"f1:b\nf2:d\nf3:e\n"},
+		{"-mmap Eof", `if Eof() { Print(Filename + ":" + Line) }`,
+			[]string{"-ln", "-mmap", "f1", "f2"},
+			map[string]string{"f1": "a\nb\n", "f2": "c\nd"},
+			nil,
+			"f1:b\nf2:d\n"},
+		{"Next, NextFile and Stop", `if Line == "skip" { Next() }; if Line == "next" { NextFile() }; if Line == "stop" { Stop() }; Print(Line)`,
+			[]string{"-ln", "-Ef", `Print("--")`, "f1", "f2", "f3", "f4"},
+			map[string]string{"f1": "a\nskip\nb\n", "f2": "c\nnext\nd\n", "f3": "e\nstop\nf\n", "f4": "g\n"},
+			nil,
+			"a\nb\n--\nc\ne\n"},
+		{"Stop in -Ef", `Print(Line)`,
+			[]string{"-ln", "-Ef", `Stop()`, "f1", "f2"},
+			map[string]string{"f1": "a\n", "f2": "b\n"},
+			nil,
+			"a\n"},
+		{"-Pmap Next", `if Line == "b" { Next() }; Line += "!"`,
+			[]string{"-lp", "-Pmap", "2", "f1"},
+			map[string]string{"f1": "a\nb\nc\n"},
+			nil,
+			"a!\nc!\n"},
+		{"TotalLineNum", `Printf("%s %d %d\n", Filename, LineNum, TotalLineNum)`,
+			[]string{"-ln", "f1", "f2"},
+			map[string]string{"f1": "a\nb\n", "f2": "c\n"},
+			nil,
+			"f1 1 1\nf1 2 2\nf2 1 3\n"},
+		{"-Pmap TotalLineNum", `Line = fmt.Sprint(TotalLineNum, Line)`,
+			[]string{"-lp", "-Pmap", "2", "f1", "f2"},
+			map[string]string{"f1": "a\nb\n", "f2": "c\n"},
+			nil,
+			"1a\n2b\n3c\n"},
+		{"-Pmap PrintJSON", `PrintJSON(map[string]string{"k": Line})`,
+			[]string{"-ln", "-Pmap", "4", "f1"},
+			map[string]string{"f1": "a\nb\nc\nd\ne\n"},
+			nil,
+			"{\"k\":\"a\"}\n{\"k\":\"b\"}\n{\"k\":\"c\"}\n{\"k\":\"d\"}\n{\"k\":\"e\"}\n"},
+		{"-Pmap KV", `Print(KV()["id"])`,
+			[]string{"-ln", "-Pmap", "4", "f1"},
+			map[string]string{"f1": "id=1\nid=2 x=y\nid=3\nid=4\nid=5\n"},
+			nil,
+			"1\n2\n3\n4\n5\n"},
+		{"ARGV", `Print(Filename, Line); if Line == "more" { ARGV = append(ARGV, "f1") }`,
+			[]string{"-ln", "-b", `ARGV = ARGV[1:]`, "f1", "f2", "f3"},
+			map[string]string{"f1": "a\n", "f2": "more\n", "f3": "b\n"},
+			nil,
+			"f2 more\nf3 b\nf1 a\n"},
+		{"-- ARGS", `Print(Filename, Line, ARGS, os.Args[1:])`,
+			[]string{"-ln", "f1", "--", "x", "-y"},
+			map[string]string{"f1": "a\n"},
+			nil,
+			"f1 a [x -y] [x -y]\n"},
+		{"-s", `Print(Opt("word", "x"), Opt("v", "0"), Opt("none", "d"), Line)`,
+			[]string{"-s", "-ln", "-word=a b", "--v", "f1"},
+			map[string]string{"f1": "a\n"},
+			nil,
+			"a b 1 d a\n"},
+		{"-m with -i", "",
+			[]string{"-pi", "-m", "^[0-9]", "f1"},
+			map[string]string{"f1": "1\nx\n2\n"},
+			map[string]string{"f1": "1\n2\n"},
+			""},
+		{"-ilp", `Line = strings.ToUpper(Line)`,
+			[]string{"-ilp", "f1"},
+			map[string]string{"f1": "a\n"},
+			map[string]string{"f1": "A\n"},
+			""},
+		{"-lp -I bak/", `Line = strings.ToUpper(Line)`,
+			[]string{"-lp", "-I", "bak/", "f1", "d/f2"},
+			map[string]string{"f1": "Once upon a time\nthere was a", "d/f2": "Go programmer\n"},
+			map[string]string{"f1": "ONCE UPON A TIME\nTHERE WAS A\n", "d/f2": "GO PROGRAMMER\n",
+				"bak/f1": "Once upon a time\nthere was a", "bak/d/f2": "Go programmer\n",
+			},
+			""},
 	}
 	for _, d := range data {
 		d := d
@@ -132,6 +669,9 @@ func TestLineModes(t *testing.T) {
 				t.Fatal(err)
 			}
 			for name, data := range d.filesIn {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(tdir, name)), 0750); err != nil {
+					t.Fatalf("write test input: %v", err)
+				}
 				if err := os.WriteFile(filepath.Join(tdir, name), []byte(data), 0640); err != nil {
 					t.Fatalf("write test input: %v", err)
 				}
@@ -160,3 +700,1022 @@ func TestLineModes(t *testing.T) {
 		})
 	}
 }
+
+func TestAtomicBatchAbort(t *testing.T) {
+	tdir := t.TempDir()
+	in := map[string]string{"f1": "one\n", "f2": "two\n", "f3": "three\n"}
+	for name, data := range in {
+		if err := os.WriteFile(filepath.Join(tdir, name), []byte(data), 0640); err != nil {
+			t.Fatalf("write test input: %v", err)
+		}
+	}
+	cmd := exec.Command(testBin, "-i", "--atomic-batch", "-pe", `if Filename == "f2" { Die("oops") }; Line = "x"`, "f1", "f2", "f3")
+	cmd.Dir = tdir
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("golf %v: want failure", cmd.Args)
+	}
+	ents, err := os.ReadDir(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := map[string]string{}
+	for _, e := range ents {
+		data, err := os.ReadFile(filepath.Join(tdir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		have[e.Name()] = string(data)
+	}
+	if diff := cmp.Diff(in, have); diff != "" {
+		t.Errorf("directory after aborted batch. diff(-want,+got):\n%s", diff)
+	}
+}
+
+func TestInPlaceAbort(t *testing.T) {
+	tdir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tdir, "f1"), []byte("one\ntwo\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(testBin, "-i", "-pe", `if LineNum == 2 { Die("oops") }; Line = "x"`, "f1")
+	cmd.Dir = tdir
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("golf %v: want failure", cmd.Args)
+	}
+	ents, err := os.ReadDir(tdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 {
+		t.Errorf("temporary file left behind: %v", ents)
+	}
+	data, err := os.ReadFile(filepath.Join(tdir, "f1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\ntwo\n" {
+		t.Errorf("f1 = %q after aborted edit, want it unchanged", data)
+	}
+}
+
+func TestTmpdir(t *testing.T) {
+	tdir := t.TempDir()
+	for _, d := range []struct {
+		desc string
+		args []string
+		env  string
+	}{
+		{"--tmpdir", []string{"--tmpdir", filepath.Join(tdir, "flag")}, ""},
+		{"GOLF_TMPDIR", nil, filepath.Join(tdir, "env")},
+	} {
+		dir := d.env
+		if dir == "" {
+			dir = d.args[1]
+		}
+		if err := os.Mkdir(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(testBin, append(d.args, "-k", "-e", "")...)
+		cmd.Env = append(os.Environ(), "GOLF_TMPDIR="+d.env)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", d.desc, err, out)
+		}
+		kept, _ := filepath.Glob(filepath.Join(dir, "golf-*", "golfing"))
+		if len(kept) != 1 || !strings.Contains(string(out), "golf: keeping build dir: "+filepath.Dir(kept[0])) {
+			t.Errorf("%v: kept %v, output %q", d.desc, kept, out)
+		}
+	}
+}
+
+// gzipped returns s, compressed.
+func gzipped(s string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.String()
+}
+
+func TestSinceLast(t *testing.T) {
+	tdir := t.TempDir()
+	log := filepath.Join(tdir, "log")
+	for _, d := range []struct {
+		desc, write, want string
+	}{
+		{"first run", "a\nb\n", "1 a\n2 b\n"},
+		{"appended", "a\nb\nc\nd", "3 c\n"},
+		{"completed", "a\nb\nc\nd\n", "4 d\n"},
+		{"nothing new", "a\nb\nc\nd\n", ""},
+		{"rotated", "x\n", "1 x\n"},
+	} {
+		if err := os.WriteFile(log, []byte(d.write), 0640); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(testBin, "--since-last", "job", "-lne", "Print(LineNum, Line)", log)
+		cmd.Env = append(os.Environ(), "GOLF_STATE_DIR="+tdir)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%v: %v", d.desc, err)
+		}
+		if diff := cmp.Diff(d.want, string(out)); diff != "" {
+			t.Errorf("%v: unexpected stdout. diff(-want,+got):\n%v", d.desc, diff)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	tdir := t.TempDir()
+	log := filepath.Join(tdir, "audit.log")
+	for _, script := range []string{"Print(1)", "Exit(3)"} {
+		cmd := exec.Command(testBin, "-b", "x := 1", "-e", script, "-E", "_ = x")
+		cmd.Env = append(os.Environ(), "GOLF_AUDIT_LOG="+log)
+		cmd.Run()
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("audit log has %d lines, want 2:\n%s", len(lines), data)
+	}
+	for i, want := range []struct {
+		script string
+		status int
+	}{{"Print(1)", 0}, {"Exit(3)", 3}} {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(lines[i]), &rec); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		if diff := cmp.Diff([]string{want.script}, rec.Script); diff != "" || rec.Status != want.status || rec.User == "" || len(rec.Begin) != 1 {
+			t.Errorf("line %d: unexpected record %+v", i+1, rec)
+		}
+	}
+}
+
+func TestHotspots(t *testing.T) {
+	cmd := exec.Command(testBin, "--hotspots", "-lane", `Print(Fields[0])`)
+	cmd.Stdin = strings.NewReader(strings.Repeat("a b\n", 200))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("golf --hotspots: %v\n%s", err, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(stderr.String(), "\n"), "\n")
+	if want := "golf: hotspots (1 in 64 lines sampled, 4 samples):"; lines[0] != want {
+		t.Fatalf("golf --hotspots: report starts %q, want %q", lines[0], want)
+	}
+	var names []string
+	total := 0.0
+	for _, l := range lines[1:] {
+		var name string
+		var pct float64
+		if _, err := fmt.Sscanf(l, "golf: %s %f%%", &name, &pct); err != nil {
+			t.Fatalf("golf --hotspots: report line %q: %v", l, err)
+		}
+		names = append(names, name)
+		total += pct
+	}
+	if diff := cmp.Diff([]string{"print", "read", "split", "script"}, names); diff != "" {
+		t.Errorf("golf --hotspots: unexpected phases. diff(-want,+got):\n%v", diff)
+	}
+	if total < 99.5 || total > 100.5 {
+		t.Errorf("golf --hotspots: shares add up to %.1f%%, want 100%%", total)
+	}
+}
+
+// tarball returns a tar archive of the given name, content pairs.
+func tarball(files ...string) string {
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+	w.WriteHeader(&tar.Header{Name: "d/", Typeflag: tar.TypeDir, Mode: 0755})
+	for i := 0; i < len(files); i += 2 {
+		w.WriteHeader(&tar.Header{Name: files[i], Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[i+1]))})
+		w.Write([]byte(files[i+1]))
+	}
+	w.Close()
+	return b.String()
+}
+
+// zipped returns a zip archive of the given name, content pairs.
+func zipped(files ...string) string {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for i := 0; i < len(files); i += 2 {
+		f, _ := w.Create(files[i])
+		f.Write([]byte(files[i+1]))
+	}
+	w.Close()
+	return b.String()
+}
+
+func TestURLInput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/x" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "a\nb\n")
+	}))
+	defer srv.Close()
+	f := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(f, []byte("c\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(testBin, "-lne", `Print(strings.TrimPrefix(Filename, "`+srv.URL+`"), LineNum, Line)`, srv.URL+"/x", f).Output()
+	if err != nil {
+		t.Fatalf("golf: %v", err)
+	}
+	if diff := cmp.Diff("/x 1 a\n/x 2 b\n"+f+" 1 c\n", string(out)); diff != "" {
+		t.Errorf("unexpected stdout. diff(-want,+got):\n%v", diff)
+	}
+	if err := exec.Command(testBin, "-ne", "", srv.URL+"/missing").Run(); err == nil {
+		t.Errorf("golf on a missing URL: want failure")
+	}
+}
+
+func TestCheckProfile(t *testing.T) {
+	for _, d := range []struct {
+		profile string
+		p       prog
+		modules []string
+		wantErr bool
+	}{
+		{"", prog{InPlace: true, Goimports: true}, []string{"os/exec"}, false},
+		{"safe", prog{RawArgs: []string{"f1"}}, []string{"encoding/csv"}, false},
+		{"safe", prog{InPlace: true}, nil, true},
+		{"safe", prog{Goimports: true}, nil, true},
+		{"safe", prog{}, []string{"os/exec"}, true},
+		{"safe", prog{RawArgs: []string{"https://example.com/"}}, nil, true},
+		{"safe", prog{Imports: []string{"os", "syscall"}, RawSrc: []string{`syscall.Kill(1, 9)`}}, nil, true},
+		{"safe", prog{Imports: []string{"os", "syscall"}, RawSrc: []string{`Print("syscall.Kill") // syscall`}}, nil, false},
+		{"safe", prog{Container: "alpine"}, nil, true},
+		{"safe", prog{Remote: "host"}, nil, true},
+		{"safe", prog{Listen: ":0"}, nil, true},
+		{"unsafe", prog{}, nil, true},
+	} {
+		if err := checkProfile(d.profile, &d.p, d.modules); (err != nil) != d.wantErr {
+			t.Errorf("checkProfile(%q, %+v, %q) = %v, want error %v", d.profile, d.p, d.modules, err, d.wantErr)
+		}
+	}
+}
+
+func TestStdinDash(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(f, []byte("b\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "-:1:in\n"},
+		{[]string{f, "-", f}, f + ":1:b\n-:1:in\n" + f + ":1:b\n"},
+	} {
+		cmd := exec.Command(testBin, append([]string{"-lne", `Printf("%s:%d:%s\n", Filename, LineNum, Line)`}, d.args...)...)
+		cmd.Stdin = strings.NewReader("in\n")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%v: %v", d.args, err)
+		}
+		if diff := cmp.Diff(d.want, string(out)); diff != "" {
+			t.Errorf("%v: unexpected stdout. diff(-want,+got):\n%v", d.args, diff)
+		}
+	}
+}
+
+func TestGrepMode(t *testing.T) {
+	dir := t.TempDir()
+	f1, f2 := filepath.Join(dir, "f1"), filepath.Join(dir, "f2")
+	if err := os.WriteFile(f1, []byte("ERROR a\nok\nWARN b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f2, []byte("WARN c\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-m", "ERROR|WARN", f1}, "ERROR a\nWARN b"},
+		{[]string{"-m", "ERROR|WARN", "-l", f1}, "ERROR a\nWARN b\n"},
+		{[]string{"-m", "WARN", f1, f2}, f1 + ":WARN b" + f2 + ":WARN c\n"},
+		{[]string{"-m", "WARN", "-le", "Print(Line[5:])", f1, f2}, "b\nc\n"},
+		{[]string{"-m", "^$"}, ""},
+	} {
+		cmd := exec.Command(testBin, d.args...)
+		cmd.Stdin = strings.NewReader("in\n")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%v: %v", d.args, err)
+		}
+		if diff := cmp.Diff(d.want, string(out)); diff != "" {
+			t.Errorf("%v: unexpected stdout. diff(-want,+got):\n%v", d.args, diff)
+		}
+	}
+}
+
+// TestConflicts checks that golf refuses combinations of flags that can't
+// work together, before running anything.
+func TestConflicts(t *testing.T) {
+	for _, d := range []struct {
+		args []string
+		want string // In the error message.
+	}{
+		{[]string{"--source", "nul", "-i", "-e", "", "f"}, "-i, -I or -O"},
+		{[]string{"--yaml", "-O", "%s.out", "-e", "", "f"}, "-i, -I or -O"},
+		{[]string{"-m", "error", "-i", "f"}, "(?i)"},
+		{[]string{"--atomic-batch", "-P", "2", "-pie", "", "f", "g"}, "--atomic-batch"},
+		{[]string{"--since-last", "job", "-P", "2", "-ne", "", "f", "g"}, "--since-last"},
+		{[]string{"-Pmap", "2", "-ne", "if Eof() { Print() }", "f"}, "Eof"},
+		{[]string{"--yaml", "-ne", "_ = Eof", "f"}, "Eof"},
+	} {
+		out, err := exec.Command(testBin, d.args...).CombinedOutput()
+		if err == nil || !strings.Contains(string(out), d.want) {
+			t.Errorf("%v: %v, %q; want an error about %q", d.args, err, out, d.want)
+		}
+	}
+}
+
+// startGolf starts golf with args, for tests of modes that keep running.
+// expect reads the next line of its stdout, and fails the test unless it is
+// want; stop interrupts golf. Its stderr goes to stderr, if not nil. golf
+// is killed and waited for when the test ends.
+func startGolf(t *testing.T, stderr io.Writer, args ...string) (expect func(want string), stop func()) {
+	t.Helper()
+	cmd := exec.Command(testBin, args...)
+	cmd.Stderr = stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	timer := time.AfterFunc(time.Minute, func() { cmd.Process.Kill() })
+	t.Cleanup(func() {
+		timer.Stop()
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	r := bufio.NewReader(out)
+	expect = func(want string) {
+		t.Helper()
+		if got, err := r.ReadString('\n'); got != want+"\n" {
+			t.Fatalf("golf %s: read %q, %v; want %q", args[0], got, err, want)
+		}
+	}
+	stop = func() { cmd.Process.Signal(os.Interrupt) }
+	return expect, stop
+}
+
+func TestTail(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "log")
+	write := func(name, data string, flag int) {
+		w, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|flag, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(f, "a\n", os.O_TRUNC)
+	expect, stop := startGolf(t, os.Stderr, "-tail", "-lne", `Print(Line)`, "-E", `Print("end")`, f)
+	expect("a")
+	write(f, "b\n", os.O_APPEND)
+	expect("b")
+	// Rotate, with a last line for the old file.
+	if err := os.Rename(f, f+".1"); err != nil {
+		t.Fatal(err)
+	}
+	write(f+".1", "c\n", os.O_APPEND)
+	write(f, "dd\n", os.O_TRUNC)
+	expect("c")
+	expect("dd")
+	write(f, "e\n", os.O_TRUNC)
+	expect("e")
+	stop()
+	expect("end")
+}
+
+func TestWatch(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "in")
+	if err := os.WriteFile(f, []byte("a\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expect, stop := startGolf(t, nil, "--watch", "-lne", `n++`, "-b", `n := 0`, "-E", `Print(strconv.Itoa(n))`, f)
+	expect("2")
+	if err := os.WriteFile(f, []byte("a\nb\nc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expect("3")
+	stop()
+}
+
+func TestListen(t *testing.T) {
+	errOut, errIn, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer errOut.Close()
+	expect, stop := startGolf(t, errIn, "--listen", "127.0.0.1:0", "--listen-idle", "500ms", "-lne", `Print(Line)`, "-E", `Print("end")`)
+	errIn.Close()
+	var addr string
+	for sc := bufio.NewScanner(errOut); addr == "" && sc.Scan(); {
+		addr = strings.TrimPrefix(sc.Text(), "golf: listening on ")
+		if addr == sc.Text() {
+			addr = ""
+		}
+	}
+	if addr == "" {
+		t.Fatal("golf --listen: no address reported")
+	}
+	// The first client never closes its connection, and is cut off when
+	// idle.
+	for i, in := range []string{"a\nb\n", "c"} {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte(in))
+		if i == 0 {
+			defer c.Close()
+		} else {
+			c.Close()
+		}
+	}
+	expect("a")
+	expect("b")
+	expect("c")
+	stop()
+	expect("end")
+}
+
+func TestFDInput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := w.WriteString("piped\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	cmd := exec.Command(testBin, "-lne", `Printf("%s:%s\n", Filename, Line)`, "fd:4", "-")
+	cmd.Stdin = strings.NewReader("in\n")
+	cmd.ExtraFiles = []*os.File{nil, r}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("fd:4:piped\n-:in\n", string(out)); diff != "" {
+		t.Errorf("unexpected stdout. diff(-want,+got):\n%v", diff)
+	}
+}
+
+// TestTeeBrokenPipe checks that a --tee file whose reader is gone is
+// reported, rather than taken in by golf itself.
+func TestTeeBrokenPipe(t *testing.T) {
+	if _, err := os.Stat("/dev/fd/0"); err != nil {
+		t.Skip("no /dev/fd")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r.Close()
+	cmd := exec.Command(testBin, "--tee", "/dev/fd/3", "-le", `Print("x")`)
+	cmd.ExtraFiles = []*os.File{w}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if _, ok := err.(*exec.ExitError); !ok || cmd.ProcessState.ExitCode() != 1 {
+		t.Errorf("golf --tee: %v, want exit status 1", err)
+	}
+	if string(out) != "x\n" {
+		t.Errorf("golf --tee: stdout %q, want %q", out, "x\n")
+	}
+	if !strings.Contains(stderr.String(), "--tee /dev/fd/3") || !strings.Contains(stderr.String(), "broken pipe") {
+		t.Errorf("golf --tee: stderr %q, want a broken pipe error", stderr.String())
+	}
+}
+
+func TestInPlaceAttrs(t *testing.T) {
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for _, keep := range []bool{false, true} {
+		f := filepath.Join(t.TempDir(), "f")
+		if err := os.WriteFile(f, []byte("a\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(f, 0751); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		args := []string{"-ipe", `Line = "b\n"`, f}
+		if keep {
+			args = append([]string{"--keep-mtime"}, args...)
+		}
+		if out, err := exec.Command(testBin, args...).CombinedOutput(); err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, out)
+		}
+		st, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode().Perm() != 0751 {
+			t.Errorf("%v: mode %v, want %v", args, st.Mode().Perm(), os.FileMode(0751))
+		}
+		if got := st.ModTime().Equal(mtime); got != keep {
+			t.Errorf("%v: mtime %v, kept: %v, want kept: %v", args, st.ModTime(), got, keep)
+		}
+	}
+}
+
+func TestInPlaceUnchanged(t *testing.T) {
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	dir := t.TempDir()
+	same, changed := filepath.Join(dir, "same"), filepath.Join(dir, "changed")
+	for name, data := range map[string]string{same: "a\n", changed: "b\n"} {
+		if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Link(same, link); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-I", ".bak", "-pe", `Line = strings.Replace(Line, "b", "c", 1)`, same, changed}
+	if out, err := exec.Command(testBin, args...).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %v\n%s", args, err, out)
+	}
+	st, err := os.Stat(same)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lst, err := os.Stat(link); err != nil || !os.SameFile(st, lst) {
+		t.Errorf("%s: replaced, want the hard link to %s kept (err: %v)", same, link, err)
+	}
+	if !st.ModTime().Equal(mtime) {
+		t.Errorf("%s: mtime %v, want %v", same, st.ModTime(), mtime)
+	}
+	if _, err := os.Stat(same + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("%s.bak: got err %v, want no backup", same, err)
+	}
+	if data, err := os.ReadFile(changed); err != nil || string(data) != "c\n" {
+		t.Errorf("%s: got %q, %v, want %q", changed, data, err, "c\n")
+	}
+	if _, err := os.Stat(changed + ".bak"); err != nil {
+		t.Errorf("%s.bak: %v, want a backup", changed, err)
+	}
+}
+
+// TestConfirmRecursive checks that -r with -i asks for confirmation, which
+// nobody is there to give, however few files it finds.
+func TestConfirmRecursive(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "f")
+	if err := os.WriteFile(f, []byte("a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(testBin, "-r", "-pie", `Line = "x"`, dir).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "editing 1 files in place") {
+		t.Errorf("-r -i: %v, %q; want a refused confirmation", err, out)
+	}
+	if data, err := os.ReadFile(f); err != nil || string(data) != "a\n" {
+		t.Errorf("%s: got %q, %v, want it unchanged", f, data, err)
+	}
+}
+
+func TestInPlaceSymlinks(t *testing.T) {
+	for _, d := range []struct {
+		mode               string
+		wantLink, wantDest string
+		wantErr            bool
+	}{
+		{"replace", "B\n", "a\n", false},
+		{"follow", "B\n", "B\n", false},
+		{"refuse", "a\n", "a\n", true},
+	} {
+		dir := t.TempDir()
+		dest, link := filepath.Join(dir, "dest"), filepath.Join(dir, "link")
+		if err := os.WriteFile(dest, []byte("a\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("dest", link); err != nil {
+			t.Fatal(err)
+		}
+		args := []string{"-i", "--symlinks", d.mode, "-pe", `Line = "B\n"`, link}
+		out, err := exec.Command(testBin, args...).CombinedOutput()
+		if (err != nil) != d.wantErr {
+			t.Errorf("%v: err %v, want error %v\n%s", args, err, d.wantErr, out)
+		}
+		for name, want := range map[string]string{link: d.wantLink, dest: d.wantDest} {
+			if data, err := os.ReadFile(name); err != nil || string(data) != want {
+				t.Errorf("%v: %s: got %q, %v, want %q", args, name, data, err, want)
+			}
+		}
+		fi, err := os.Lstat(link)
+		if isLink := err == nil && fi.Mode()&os.ModeSymlink != 0; isLink != (d.mode != "replace") {
+			t.Errorf("%v: %s is a symlink: %v, want %v", args, link, isLink, d.mode != "replace")
+		}
+	}
+}
+
+func TestRemote(t *testing.T) {
+	// A stand-in for ssh that runs the remote command locally.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\nshift\nexec sh -c \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(t.TempDir(), "it's")
+	if err := os.WriteFile(f, []byte("a\nb\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(testBin, "remote", "host", "-lne", `Print(Filename == os.Args[1], Line); if LineNum == 2 { Exit(3) }`, f)
+	cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	out, err := cmd.Output()
+	if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() != 3 {
+		t.Fatalf("golf remote: %v, want exit status 3", err)
+	}
+	if diff := cmp.Diff("true a\ntrue b\n", string(out)); diff != "" {
+		t.Errorf("unexpected stdout. diff(-want,+got):\n%v", diff)
+	}
+}
+
+func TestInContainer(t *testing.T) {
+	// A stand-in for docker that runs the mounted binary directly.
+	bin := t.TempDir()
+	fake := `#!/bin/sh
+for a; do case $a in *:/golfing:ro) golfing=${a%:/golfing:ro};; esac; done
+while [ "$1" != img ]; do shift; done
+shift 2
+exec "$golfing" "$@"
+`
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(testBin, "--in-container", "img", "-lne", `Print(Filename, Line)`, "-", "x y")
+	cmd.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(cmd.Dir, "x y"), []byte("file\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	cmd.Stdin = strings.NewReader("stdin\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("golf --in-container: %v", err)
+	}
+	if diff := cmp.Diff("- stdin\nx y file\n", string(out)); diff != "" {
+		t.Errorf("unexpected stdout. diff(-want,+got):\n%v", diff)
+	}
+}
+
+func TestCompileLineRanges(t *testing.T) {
+	for _, d := range []struct {
+		specs   []string
+		want    string
+		fromEnd bool
+	}{
+		{[]string{"100:200"}, "[]golfLineRange{{100, 200}}", false},
+		{[]string{"5", ":3", "7:"}, "[]golfLineRange{{5, 5}, {1, 3}, {7, 0}}", false},
+		{[]string{"-10:"}, "[]golfLineRange{{-10, 0}}", true},
+		{[]string{"2:-2"}, "[]golfLineRange{{2, -2}}", true},
+		{[]string{"0"}, "error", false},
+		{[]string{"3:2"}, "error", false},
+		{[]string{"-2:-3"}, "error", false},
+		{[]string{"a:b"}, "error", false},
+	} {
+		got, fromEnd, err := compileLineRanges(d.specs)
+		if err != nil {
+			got = "error"
+		}
+		if got != d.want || fromEnd != d.fromEnd {
+			t.Errorf("compileLineRanges(%q) = %s, %v; want %s, %v", d.specs, got, fromEnd, d.want, d.fromEnd)
+		}
+	}
+}
+
+func TestCompileFixed(t *testing.T) {
+	for _, d := range []struct {
+		spec, want string
+	}{
+		{"1-8,9-16,17-", "golfFixedSplit([]golfSpan{{1, 8}, {9, 16}, {17, -1}})"},
+		{"-4,5,3-3", "golfFixedSplit([]golfSpan{{1, 4}, {5, 5}, {3, 3}})"},
+		{"0-3", "error"},
+		{"5-2", "error"},
+		{"a-", "error"},
+	} {
+		got, err := compileFixed(d.spec)
+		if err != nil {
+			got = "error"
+		}
+		if got != d.want {
+			t.Errorf("compileFixed(%q) = %s, want %s", d.spec, got, d.want)
+		}
+	}
+}
+
+func TestExpandNFields(t *testing.T) {
+	for _, d := range []struct {
+		src   string
+		bytes bool
+		want  string
+	}{
+		{`if NFields < 5 { Print(NFields) }`, false, `if len(Fields) < 5 { Print(len(Fields)) }`},
+		{`n := NFields`, true, `n := len(FieldsBytes)`},
+		{`x.NFields + NFieldsMax + myNFields`, false, `x.NFields + NFieldsMax + myNFields`},
+		{`Print("NFields") // NFields`, false, `Print("NFields") // NFields`},
+	} {
+		if got := expandNFields(d.src, d.bytes); got != d.want {
+			t.Errorf("expandNFields(%q, %v) = %q, want %q", d.src, d.bytes, got, d.want)
+		}
+	}
+}
+
+func TestKeepsFields(t *testing.T) {
+	for _, d := range []struct {
+		src  string
+		want bool
+	}{
+		{`Print(Fields[1], len(Fields), Field(2))`, false},
+		{`for _, f := range Fields { Print(f) }`, false},
+		{`Fields[0] = "x"; Fields = Fields`, true},
+		{`Fields = strings.Split(Line, ","); x := Fields[a[1:2][0]]`, false},
+		{`prev = Fields`, true},
+		{`prev = Fields[1:]`, true},
+		{`p := &Fields[0]`, true},
+		{`ok && Fields[0] == ""`, false},
+		{`keep(Fields)`, true},
+		{`x.Fields; myFields = 1; Print("Fields") // Fields`, false},
+	} {
+		if got := keepsFields(d.src); got != d.want {
+			t.Errorf("keepsFields(%q) = %v, want %v", d.src, got, d.want)
+		}
+	}
+}
+
+func TestExpandPattern(t *testing.T) {
+	for _, d := range []struct {
+		src, want string
+	}{
+		{`/a{2}\/b/ { n++ }`, `if golfPatternRE("a{2}/b").MatchString(Line) { n++ }`},
+		{`LineNum > 10 {Print(Line)}`, `if LineNum > 10 {Print(Line)}`},
+		{` Field(1) == "}" { if x { y() } } `, `if Field(1) == "}" { if x { y() } }`},
+		{`/a/ { x } /b/ { y }`, `/a/ { x } /b/ { y }`},
+		{`for _, f := range Fields { Print(f) }`, `for _, f := range Fields { Print(f) }`},
+		{`if x { y }`, `if x { y }`},
+		{`func() { x }()`, `func() { x }()`},
+		{`m := map[string]int{}`, `m := map[string]int{}`},
+		{`n++; if x { y }`, `n++; if x { y }`},
+		{`Print("{")`, `Print("{")`},
+		{`/(/ { x }`, "error"},
+	} {
+		got, err := expandPattern(d.src, false)
+		if err != nil {
+			got = "error"
+		}
+		if got != d.want {
+			t.Errorf("expandPattern(%q) = %q, want %q", d.src, got, d.want)
+		}
+	}
+	if got, _ := expandPattern(`/a/ {}`, true); got != `if golfPatternRE("a").Match(LineBytes) {}` {
+		t.Errorf("expandPattern with -bytes = %q", got)
+	}
+}
+
+func TestExpandControl(t *testing.T) {
+	for _, d := range []struct {
+		src, want string
+	}{
+		{`if x { Next() }; NextFile(); Stop()`, `if x { continue Line }; continue File; break File`},
+		{`Print("Next()") // Stop()`, `Print("Next()") // Stop()`},
+		{`x.Next(); MyNext(); Next ()`, `x.Next(); MyNext(); Next ()`},
+	} {
+		if got := expandControl(d.src, lineControl); got != d.want {
+			t.Errorf("expandControl(%q) = %q, want %q", d.src, got, d.want)
+		}
+	}
+	if got, want := expandControl(`Next(); Stop()`, pmapControl), `return; Stop()`; got != want {
+		t.Errorf("expandControl with -Pmap = %q, want %q", got, want)
+	}
+}
+
+func TestUsesIdent(t *testing.T) {
+	for _, d := range []struct {
+		src  string
+		want bool
+	}{
+		{`<-Ctx.Done()`, true},
+		{`f(Ctx)`, true},
+		{`Print("Ctx") // Ctx`, false},
+		{`reqCtx := r.Ctx; CtxFoo()`, false},
+	} {
+		if got := usesIdent(d.src, "Ctx"); got != d.want {
+			t.Errorf("usesIdent(%q, \"Ctx\") = %v, want %v", d.src, got, d.want)
+		}
+	}
+	if !usesIdent(`x := ToYAML(v)`, "FromYAML", "ToYAML") || usesIdent(`Print("ToYAML") // FromYAML`, "FromYAML", "ToYAML") {
+		t.Error("usesIdent with several names: wrong answer")
+	}
+}
+
+func TestTakeSwitches(t *testing.T) {
+	for _, d := range []struct {
+		args, rest []string
+		switches   map[string]string
+	}{
+		{[]string{"-lne", "x", "-y", "f"}, []string{"-lne", "x", "-y", "f"}, nil},
+		{[]string{"-sl", "-x", "-e", "-x", "-name=v", "--lines", "-3:", "f", "-z"}, []string{"-sl", "-e", "-x", "--lines", "-3:", "f", "-z"}, map[string]string{"x": "1", "name": "v"}},
+		{[]string{"-s", "-i.bak", "-l0", "-nope=", "-F=:", "--", "-q=1"}, []string{"-s", "-i.bak", "-l0", "-F=:", "--", "-q=1"}, map[string]string{"nope": ""}},
+	} {
+		rest, switches := takeSwitches(d.args)
+		if diff := cmp.Diff(d.rest, rest); diff != "" {
+			t.Errorf("takeSwitches(%q): rest diff(-want,+got):\n%v", d.args, diff)
+		}
+		if diff := cmp.Diff(d.switches, switches); diff != "" {
+			t.Errorf("takeSwitches(%q): switches diff(-want,+got):\n%v", d.args, diff)
+		}
+	}
+}
+
+func TestGoTarget(t *testing.T) {
+	for uname, want := range map[string]string{
+		"Linux x86_64\n": "linux/amd64",
+		"Darwin arm64\n": "darwin/arm64",
+		"Linux armv7l\n": "linux/arm",
+		"SunOS i86pc\n":  "error",
+		"Linux\n":        "error",
+	} {
+		goos, goarch, err := goTarget(uname)
+		got := goos + "/" + goarch
+		if err != nil {
+			got = "error"
+		}
+		if got != want {
+			t.Errorf("goTarget(%q) = %s, want %s", uname, got, want)
+		}
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	cmd := exec.Command(testBin, "doctor")
+	// Don't go looking for modules on the network.
+	cmd.Env = append(os.Environ(), "GOPROXY=off")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("golf doctor: %v\n%s", err, out)
+	}
+	for _, want := range []string{"ok    go toolchain: ", "ok    canary: script: ", "ok    canary: line mode: ", "warn  module proxy: "} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("golf doctor: output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGoVersionAtLeast(t *testing.T) {
+	for _, d := range []struct {
+		have, want string
+		ok         bool
+	}{
+		{"1.21.5", "1.17", true},
+		{"1.17", "1.17", true},
+		{"1.16.15", "1.17", false},
+		{"1.22rc1", "1.22", true},
+		{"2.0", "1.17", true},
+	} {
+		if got := goVersionAtLeast(d.have, d.want); got != d.ok {
+			t.Errorf("goVersionAtLeast(%q, %q) = %v, want %v", d.have, d.want, got, d.ok)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	data := []struct {
+		desc     string
+		args     []string
+		wantCode int
+		wantSig  syscall.Signal
+	}{
+		{"success", []string{"-e", ""}, 0, 0},
+		{"os.Exit", []string{"-e", "os.Exit(3)"}, 3, 0},
+		{"Die", []string{"-e", `Die("oops")`}, 1, 0},
+		{"Dief", []string{"-e", `Dief(7, "oops: %d", 42)`}, 7, 0},
+		{"Die exec.ExitError", []string{"-M", "os/exec", "-e", `Die(GErr(exec.Command("sh", "-c", "exit 4").Run(), "sh"))`}, 4, 0},
+		{"ErrCount", []string{"-M", "errors", "-e", `Warn(GErr(errors.New("a"), "x")); Warn("%s: %w", "b", GErr(errors.New("c"), "y"))`}, 1, 0},
+		{"ErrCount reset", []string{"-M", "errors", "-e", `Warn(GErr(errors.New("a"), "x"))`, "-E", "ErrCount = 0"}, 0, 0},
+		{"Warn without GErr", []string{"-M", "errors", "-e", `Warn(errors.New("a"))`}, 0, 0},
+		{"ExitCode", []string{"-b", "ExitCode = 1", "-E", "ExitCode++", "-e", ""}, 2, 0},
+		{"Exit", []string{"-e", "Exit(5); ExitCode = 6"}, 5, 0},
+		{"-strict", []string{"-strict", "-e", `GAtoi("N/A")`}, 1, 0},
+		{"not -strict", []string{"-e", `if GAtoi("N/A") != 0 { Exit(2) }`}, 0, 0},
+		{"--profile safe", []string{"--profile", "safe", "-i", "-e", ""}, 1, 0},
+		{"-i many files", []string{"-i", "--confirm-over", "1", "-e", "", "f1", "f2"}, 1, 0},
+		{"compile error", []string{"-e", "x"}, 1, 0},
+		{"--e64 bad base64", []string{"--e64", "Print(1)"}, 2, 0},
+		{"--feature unknown", []string{"--feature", "nonesuch", "-e", ""}, 1, 0},
+		{"SIGTERM cancels Ctx", []string{"-M", "syscall", "-e", "syscall.Kill(os.Getpid(), syscall.SIGTERM); <-Ctx.Done()"}, -1, syscall.SIGTERM},
+		{"SIGTERM", []string{"-M", "syscall", "-M", "time", "-e", "syscall.Kill(os.Getpid(), syscall.SIGTERM); time.Sleep(time.Minute)"}, -1, syscall.SIGTERM},
+	}
+	for _, d := range data {
+		d := d
+		t.Run(d.desc, func(t *testing.T) {
+			t.Parallel()
+			cmd := exec.Command(testBin, d.args...)
+			err := cmd.Run()
+			if _, ok := err.(*exec.ExitError); err != nil && !ok {
+				t.Fatalf("%v: %v", d.args, err)
+			}
+			if code := cmd.ProcessState.ExitCode(); code != d.wantCode {
+				t.Errorf("%v: exit code %d, want %d", d.args, code, d.wantCode)
+			}
+			ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
+			if d.wantSig != 0 && (!ws.Signaled() || ws.Signal() != d.wantSig) {
+				t.Errorf("%v: wait status %v, want killed by %v", d.args, ws, d.wantSig)
+			}
+		})
+	}
+}
+
+// TestQuiet checks that -q silences golf's own diagnostics, but not the
+// script's, and leaves the exit status alone.
+func TestQuiet(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(f, []byte("ab\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []struct {
+		args       []string
+		wantStderr string
+		wantCode   int
+	}{
+		{[]string{"--max-file-size", "1", "-ne", "", f}, "golf: " + f + ": 3 bytes, over --max-file-size; skipped\n", 0},
+		{[]string{"-q", "--max-file-size", "1", "-ne", "", f}, "", 0},
+		{[]string{"-M", "errors", "-e", `Warn(GErr(errors.New("boom"), "f"))`}, "f: boom\ngolf: 1 error\n", 1},
+		{[]string{"-q", "-M", "errors", "-e", `Warn(GErr(errors.New("boom"), "f"))`}, "f: boom\n", 1},
+	} {
+		cmd := exec.Command(testBin, d.args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			t.Fatalf("%v: %v", d.args, err)
+		}
+		if code := cmd.ProcessState.ExitCode(); code != d.wantCode {
+			t.Errorf("%v: exit code %d, want %d", d.args, code, d.wantCode)
+		}
+		if diff := cmp.Diff(d.wantStderr, stderr.String()); diff != "" {
+			t.Errorf("%v: unexpected stderr. diff(-want,+got):\n%v", d.args, diff)
+		}
+	}
+}
+
+func TestGlobArgs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "c.txt", "[x].log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := func(name string) string { return filepath.Join(dir, name) }
+	got := globArgs([]string{in("*.log"), "-", in("*.none"), in("[x].log"), "https://example.com/*.log"})
+	want := []string{in("[x].log"), in("a.log"), in("b.log"), "-", in("*.none"), in("[x].log"), "https://example.com/*.log"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected args. diff(-want,+got):\n%v", diff)
+	}
+}
+
+func TestCompileFormat(t *testing.T) {
+	for _, d := range []struct {
+		format   string
+		wantSrc  string
+		wantFast bool
+	}{
+		{"", `Print("")`, true},
+		{"{{.F 3}}\t{{.F -1}}", `Print(Field(3) + "\t" + Field(-1))`, true},
+		{"{{.LineNum}}: {{.Line}} ({{.Filename}})", `Print(strconv.Itoa(LineNum) + ": " + Line + " (" + Filename + ")")`, true},
+		{"{{.F 1 | printf \"%q\"}}", "", false},
+		{"{{range .Fields}}{{.}},{{end}}", "", false},
+	} {
+		src, fast, err := compileFormat(d.format)
+		if err != nil {
+			t.Errorf("compileFormat(%q): %v", d.format, err)
+			continue
+		}
+		if fast != d.wantFast || (fast && src != d.wantSrc) {
+			t.Errorf("compileFormat(%q) = %q, %v; want %q, %v", d.format, src, fast, d.wantSrc, d.wantFast)
+		}
+	}
+	if _, _, err := compileFormat("{{.F"); err == nil {
+		t.Errorf("compileFormat of bad template: want error")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compileLineRanges turns --lines specs such as "100:200", "5", "-10:" or
+// ":-2" into a Go expression for golfNewLines, and reports whether any of
+// them counts from the end of the file.
+//
+// A spec is FROM:TO, inclusive, or a single line number. Line numbers are
+// 1-based, and negative ones count back from the last line, -1. FROM
+// defaults to the first line, and TO to the last.
+func compileLineRanges(specs []string) (string, bool, error) {
+	var ranges []string
+	fromEnd := false
+	for _, spec := range specs {
+		from, to := spec, spec
+		if i := strings.Index(spec, ":"); i >= 0 {
+			from, to = spec[:i], spec[i+1:]
+		}
+		num := func(s string, def int) (int, error) {
+			if s == "" {
+				return def, nil
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("bad line number %q in %q", s, spec)
+			}
+			return n, nil
+		}
+		f, err := num(from, 1)
+		if err != nil {
+			return "", false, err
+		}
+		t, err := num(to, 0) // 0 is the last line.
+		if err != nil {
+			return "", false, err
+		}
+		if t != 0 && (f > 0) == (t > 0) && t < f {
+			return "", false, fmt.Errorf("bad line range %q", spec)
+		}
+		fromEnd = fromEnd || f < 0 || t < 0
+		ranges = append(ranges, fmt.Sprintf("{%d, %d}", f, t))
+	}
+	return fmt.Sprintf("[]golfLineRange{%s}", strings.Join(ranges, ", ")), fromEnd, nil
+}
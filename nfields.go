@@ -0,0 +1,69 @@
+package main
+
+import "strings"
+
+// expandNFields replaces the identifier NFields in src with the number of
+// fields, len(Fields), or len(FieldsBytes) in -bytes mode. Being an
+// expression rather than a variable, it can't go stale when the script
+// changes Fields.
+func expandNFields(src string, bytes bool) string {
+	const name = "NFields"
+	repl := "len(Fields)"
+	if bytes {
+		repl = "len(FieldsBytes)"
+	}
+	var b strings.Builder
+	last := 0
+	scanGo(src, func(i int, c byte, depth int) bool {
+		if i < last || i > 0 && (isIdentByte(src[i-1]) || src[i-1] == '.') {
+			return true
+		}
+		if strings.HasPrefix(src[i:], name) && (i+len(name) == len(src) || !isIdentByte(src[i+len(name)])) {
+			b.WriteString(src[last:i])
+			b.WriteString(repl)
+			last = i + len(name)
+		}
+		return true
+	})
+	b.WriteString(src[last:])
+	return b.String()
+}
+
+// keepsFields reports whether the Go source src might keep Fields, or part
+// of it, past the current line. It might unless every use of Fields indexes
+// a single field, takes its length, ranges over it or assigns to it.
+// golf only reuses the storage of Fields from line to line when it can't.
+func keepsFields(src string) bool {
+	const name = "Fields"
+	keeps := false
+	scanGo(src, func(i int, c byte, depth int) bool {
+		if i > 0 && (isIdentByte(src[i-1]) || src[i-1] == '.') {
+			return true
+		}
+		if !strings.HasPrefix(src[i:], name) || i+len(name) < len(src) && isIdentByte(src[i+len(name)]) {
+			return true
+		}
+		before := strings.TrimRight(src[:i], " \t")
+		after := strings.TrimLeft(src[i+len(name):], " \t")
+		switch {
+		case strings.HasSuffix(before, "&") && !strings.HasSuffix(before, "&&"):
+			keeps = true // A pointer to a field.
+		case strings.HasSuffix(before, "len(") && strings.HasPrefix(after, ")"):
+		case strings.HasSuffix(before, "range") && (len(before) == 5 || !isIdentByte(before[len(before)-6])):
+		case strings.HasPrefix(after, "=") && !strings.HasPrefix(after, "=="):
+		case strings.HasPrefix(after, "["):
+			// An index, unless it's a slice expression.
+			start := i + len(src[i:]) - len(after)
+			scanGo(src[start:], func(j int, c byte, d int) bool {
+				if c == ':' && d == 1 {
+					keeps = true
+				}
+				return !(c == ']' && d == 0)
+			})
+		default:
+			keeps = true
+		}
+		return !keeps
+	})
+	return keeps
+}
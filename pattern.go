@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// expandPattern rewrites an awk-style -e snippet, PATTERN { ACTION }, into
+// an if statement running ACTION for the records PATTERN selects. PATTERN is
+// either /regexp/, matched against Line (or LineBytes, for -bytes), or a Go
+// expression such as LineNum > 10. Other snippets are returned unchanged.
+func expandPattern(src string, bytes bool) (string, error) {
+	s := strings.TrimSpace(src)
+	var cond, action string
+	if strings.HasPrefix(s, "/") {
+		end := regexpEnd(s)
+		if end < 0 {
+			return src, nil
+		}
+		re := strings.ReplaceAll(s[1:end], `\/`, "/")
+		if _, err := regexp.Compile(re); err != nil {
+			return "", err
+		}
+		if bytes {
+			cond = fmt.Sprintf("golfPatternRE(%q).Match(LineBytes)", re)
+		} else {
+			cond = fmt.Sprintf("golfPatternRE(%q).MatchString(Line)", re)
+		}
+		action = strings.TrimSpace(s[end+1:])
+	} else {
+		open := topLevelBrace(s)
+		if open <= 0 {
+			return src, nil
+		}
+		cond = strings.TrimSpace(s[:open])
+		if token.Lookup(strings.Fields(cond)[0]).IsKeyword() {
+			return src, nil // if, for, switch, func and so on.
+		}
+		if _, err := parser.ParseExpr(cond); err != nil {
+			return src, nil
+		}
+		action = s[open:]
+	}
+	if !strings.HasPrefix(action, "{") || !balanced(action) {
+		return src, nil
+	}
+	return "if " + cond + " " + action, nil
+}
+
+// regexpEnd returns the index of the slash ending the /regexp/ s starts
+// with, or -1.
+func regexpEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '/':
+			return i
+		}
+	}
+	return -1
+}
+
+// scanGo calls f with the index and value of each byte of Go source s that
+// is outside string and rune literals and comments, along with the nesting
+// depth of (), [] and {} there. It stops if f returns false.
+func scanGo(s string, f func(i int, c byte, depth int) bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\'':
+			for i++; i < len(s) && s[i] != c && s[i] != '\n'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			continue
+		case c == '`':
+			if j := strings.IndexByte(s[i+1:], '`'); j >= 0 {
+				i += j + 1
+				continue
+			}
+			i = len(s)
+			continue
+		case strings.HasPrefix(s[i:], "//"):
+			if j := strings.IndexByte(s[i:], '\n'); j >= 0 {
+				i += j
+				continue
+			}
+			return
+		case strings.HasPrefix(s[i:], "/*"):
+			if j := strings.Index(s[i+2:], "*/"); j >= 0 {
+				i += j + 3
+				continue
+			}
+			return
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		}
+		if !f(i, c, depth) {
+			return
+		}
+		if c == '(' || c == '[' || c == '{' {
+			depth++
+		}
+	}
+}
+
+// topLevelBrace returns the index of the first { in s that isn't nested in
+// brackets, or -1.
+func topLevelBrace(s string) int {
+	at := -1
+	scanGo(s, func(i int, c byte, depth int) bool {
+		if c == '{' && depth == 0 {
+			at = i
+			return false
+		}
+		return true
+	})
+	return at
+}
+
+// balanced reports whether block, which starts with {, ends with the } that
+// closes it.
+func balanced(block string) bool {
+	end := -1
+	scanGo(block, func(i int, c byte, depth int) bool {
+		if c == '}' && depth == 0 {
+			end = i
+			return false
+		}
+		return true
+	})
+	return end == len(block)-1
+}
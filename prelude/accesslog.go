@@ -0,0 +1,69 @@
+package prelude
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file is only embedded in the generated program when the script uses
+// ParseAccessLog.
+
+// golf:prelude start
+
+// AccessLog is a request logged in the common or combined log format, as by
+// Apache and nginx. Fields are as written, "-" included, but for Bytes, which
+// is 0 when "-".
+type AccessLog struct {
+	IP, Ident, User     string
+	Time                time.Time
+	Method, Path, Proto string
+	Status              int
+	Bytes               int
+	Referer, UserAgent  string // Only in the combined format.
+}
+
+var golfAccessLogRE = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+// ParseAccessLog parses line as an access log entry in the common or
+// combined log format. It returns nil if line is not one, which counts as a
+// warning in -w mode.
+//
+//	golf -b 'n := map[int]int{}' -lne 'if r := ParseAccessLog(Line); r != nil { n[r.Status]++ }' -E 'Print(n)' access.log
+func ParseAccessLog(line string) *AccessLog {
+	m := golfAccessLogRE.FindStringSubmatch(line)
+	if m == nil {
+		golfAccessLogWarning(line)
+		return nil
+	}
+	t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[4])
+	if err != nil {
+		golfAccessLogWarning(line)
+		return nil
+	}
+	r := &AccessLog{IP: m[1], Ident: m[2], User: m[3], Time: t, Referer: m[8], UserAgent: m[9]}
+	// The request line is usually METHOD PATH PROTO, but needn't be, as
+	// clients can send anything.
+	req := strings.Fields(m[5])
+	switch len(req) {
+	case 3:
+		r.Proto = req[2]
+		fallthrough
+	case 2:
+		r.Method, r.Path = req[0], req[1]
+	default:
+		r.Path = m[5]
+	}
+	r.Status, _ = strconv.Atoi(m[6])
+	r.Bytes, _ = strconv.Atoi(m[7])
+	return r
+}
+
+func golfAccessLogWarning(line string) {
+	if Warnings {
+		golfNoteWarning("not an access log line", strconv.Quote(strings.TrimSuffix(line, LineEnding)))
+	}
+}
+
+// golf:prelude end
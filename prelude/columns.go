@@ -0,0 +1,80 @@
+package prelude
+
+import (
+	"strings"
+)
+
+// This file is only embedded in the generated program with --fixed or
+// --project.
+
+// golf:prelude start
+
+// golfSpan is a field range for --project, or a column range for --fixed.
+// Both are 1-based, and the range is inclusive. To == -1 means up to the last
+// field, or the end of the line.
+type golfSpan struct{ From, To int }
+
+var golfProjectBuf []string
+
+// golfProject rearranges Fields and rebuilds Line for --project.
+func golfProject(spans []golfSpan) {
+	var out []string
+	if GolfReuseFields {
+		out = golfProjectBuf[:0]
+	}
+	for _, s := range spans {
+		if s.From == s.To {
+			out = append(out, Field(s.From))
+			continue
+		}
+		to := s.To
+		if to == -1 || to > len(Fields) {
+			to = len(Fields)
+		}
+		for i := s.From; i <= to; i++ {
+			out = append(out, Fields[i-1])
+		}
+	}
+	golfProjectBuf = out
+	Fields = out
+	Line = golfJoin(Fields)
+}
+
+// golfFixedSplit splits Line into Fields at the columns in spans, for
+// --fixed. Columns count runes. Blanks around fields are trimmed, and columns
+// past the end of the line yield empty fields.
+func golfFixedSplit(spans []golfSpan) {
+	line := strings.TrimSuffix(Line, LineEnding)
+	ascii := true
+	for i := 0; i < len(line) && ascii; i++ {
+		ascii = line[i] < 0x80
+	}
+	// offset returns where column n, 0-based, starts in line.
+	offset := func(n int) int {
+		if n >= len(line) {
+			return len(line)
+		}
+		if ascii {
+			return n
+		}
+		for i := range line {
+			if n == 0 {
+				return i
+			}
+			n--
+		}
+		return len(line)
+	}
+	out := golfFieldsStorage()
+	for _, s := range spans {
+		from, to := offset(s.From-1), len(line)
+		if s.To != -1 {
+			to = offset(s.To)
+		}
+		out = append(out, strings.Trim(line[from:to], " \t"))
+	}
+	golfFieldsBuf = out
+	Fields = out
+}
+
+// golf:prelude end
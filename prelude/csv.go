@@ -0,0 +1,93 @@
+package prelude
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file is only embedded in the generated program with --csv.
+
+// golf:prelude start
+
+var (
+	golfCSVFields []string // Fields as split, to tell whether the script changed them.
+	golfCSVBuf    bytes.Buffer
+)
+
+// golfCSVRecord completes raw, a line read from r, to a whole CSV record:
+// while a quoted field is left open, the following lines are appended.
+func golfCSVRecord(r *bufio.Reader, max int, raw []byte, err error) ([]byte, error) {
+	if err != nil || !golfCSVOpen(raw) {
+		return raw, err
+	}
+	// raw points into r's buffer, which reading more may overwrite.
+	raw = append([]byte(nil), raw...)
+	for err == nil && golfCSVOpen(raw) {
+		var more []byte
+		if more, err = golfReadLine(r, max); len(more) == 0 {
+			break
+		}
+		raw = append(raw, more...)
+	}
+	return raw, err
+}
+
+// golfCSVOpen reports whether b ends inside a quoted field. Quotes in a
+// field are doubled, so an odd number of them leaves one open.
+func golfCSVOpen(b []byte) bool {
+	return bytes.Count(b, []byte(`"`))%2 == 1
+}
+
+// golfCSVSplit splits Line into Fields as a CSV record delimited by IFS,
+// for --csv.
+func golfCSVSplit() {
+	r := csv.NewReader(strings.NewReader(Line))
+	r.Comma = golfCSVComma()
+	r.FieldsPerRecord = -1
+	fields, err := r.Read()
+	switch {
+	case err == io.EOF:
+		fields = nil // A blank line.
+	case err != nil:
+		Die("%s:%d: %v", Filename, LineNum, err)
+	}
+	Fields = fields
+	golfCSVFields = append(golfCSVFields[:0], fields...)
+}
+
+// golfCSVJoin encodes fields as a CSV record delimited by IFS, without a
+// terminator.
+func golfCSVJoin(fields []string) string {
+	golfCSVBuf.Reset()
+	w := csv.NewWriter(&golfCSVBuf)
+	w.Comma = golfCSVComma()
+	w.Write(fields)
+	w.Flush()
+	return strings.TrimSuffix(golfCSVBuf.String(), "\n")
+}
+
+// golfCSVComma returns the delimiter, the one character of IFS, which may
+// be any rune.
+func golfCSVComma() rune {
+	r, _ := utf8.DecodeRuneInString(IFS)
+	return r
+}
+
+// golfCSVLine returns the record that Print prints by default: Line, unless
+// the script changed Fields, in which case they are encoded anew.
+func golfCSVLine() string {
+	if golfSameFields(Fields, golfCSVFields) {
+		return Line
+	}
+	s := golfCSVJoin(Fields)
+	if !GolfFlgL {
+		s += LineEnding
+	}
+	return s
+}
+
+// golf:prelude end
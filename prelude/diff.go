@@ -0,0 +1,247 @@
+package prelude
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// This file is only embedded in the generated program with --dry-run.
+
+// golf:prelude start
+
+func init() {
+	golfPreview = golfPreviewDiff
+}
+
+// golfPreviewDiff prints the edit staged in st as a unified diff, for
+// --dry-run, and discards it. Gzip-compressed files are compared
+// decompressed.
+func golfPreviewDiff(st golfStagedFile) error {
+	defer os.Remove(st.tmp)
+	a, err := golfReadPlain(st.name)
+	if err != nil {
+		return err
+	}
+	b, err := golfReadPlain(st.tmp)
+	if err != nil {
+		return err
+	}
+	golfDiff(golfStdout, st.name, a, b)
+	return nil
+}
+
+// golfReadPlain reads the named file, decompressing it if it's gzipped.
+func golfReadPlain(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return data, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+// golfDiffLines splits data into lines, terminators included.
+func golfDiffLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i:]
+	}
+	return lines
+}
+
+// golfDiffOp is a line of an edit script: kept (' '), deleted ('-') or
+// inserted ('+').
+type golfDiffOp struct {
+	kind byte
+	line string
+}
+
+// golfDiffScript returns a shortest edit script from a to b, found with
+// the linear-space variant of Myers' algorithm, which splits the problem at
+// the middle of an optimal path and solves the halves in turn.
+func golfDiffScript(a, b []string) []golfDiffOp {
+	return golfDiffAppend(nil, a, b)
+}
+
+// golfDiffAppend appends an edit script from a to b to ops.
+func golfDiffAppend(ops []golfDiffOp, a, b []string) []golfDiffOp {
+	var suf []string
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		ops = append(ops, golfDiffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suf = append(suf, a[len(a)-1])
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	if x, y, ok := golfDiffSplit(a, b); ok {
+		ops = golfDiffAppend(ops, a[:x], b[:y])
+		ops = golfDiffAppend(ops, a[x:], b[y:])
+	} else {
+		for _, l := range a {
+			ops = append(ops, golfDiffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, golfDiffOp{'+', l})
+		}
+	}
+	for i := len(suf) - 1; i >= 0; i-- {
+		ops = append(ops, golfDiffOp{' ', suf[i]})
+	}
+	return ops
+}
+
+// golfDiffSplit finds where an optimal path from a to b, which have no
+// common prefix or suffix, crosses its middle, by searching forward from
+// the start and backward from the end until the two meet. It reports false
+// if there is no point to split at, as when a or b is empty.
+func golfDiffSplit(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+	// vf[off+k] is the furthest x reached forward on diagonal k = x - y, and
+	// vb[off+k] the furthest reached backward, counted from the ends of a
+	// and b. Diagonals whose paths left the grid are no longer searched.
+	maxD := (n + m + 1) / 2
+	off := maxD
+	vf, vb := make([]int, 2*maxD+2), make([]int, 2*maxD+2)
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[off+1], vb[off+1] = 0, 0
+	delta := n - m
+	odd := delta%2 != 0
+	var fLo, fHi, bLo, bHi int
+	split := func(x, y int) (int, int, bool) {
+		return x, y, (x > 0 || y > 0) && (x < n || y < m)
+	}
+	for d := 0; d < maxD; d++ {
+		for k := -d + fLo; k <= d-fHi; k += 2 {
+			var x int
+			if k == -d || k != d && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1] // Down: an insertion.
+			} else {
+				x = vf[off+k-1] + 1 // Right: a deletion.
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			vf[off+k] = x
+			switch {
+			case x > n:
+				fHi += 2
+			case y > m:
+				fLo += 2
+			case odd:
+				if kb := off + delta - k; kb >= 0 && kb < len(vb) && vb[kb] != -1 && x >= n-vb[kb] {
+					return split(x, y)
+				}
+			}
+		}
+		for k := -d + bLo; k <= d-bHi; k += 2 {
+			var x int
+			if k == -d || k != d && vb[off+k-1] < vb[off+k+1] {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x, y = x+1, y+1
+			}
+			vb[off+k] = x
+			switch {
+			case x > n:
+				bHi += 2
+			case y > m:
+				bLo += 2
+			case !odd:
+				if kf := off + delta - k; kf >= 0 && kf < len(vf) && vf[kf] != -1 && vf[kf] >= n-x {
+					fx := vf[kf]
+					return split(fx, fx-(kf-off))
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// golfDiff writes a unified diff from a to b, both called name, to w, with
+// three lines of context. It writes nothing if a and b are the same.
+func golfDiff(w io.Writer, name string, a, b []byte) {
+	const context = 3
+	ops := golfDiffScript(golfDiffLines(a), golfDiffLines(b))
+	header := false
+	// Line numbers, 0-based, in a and b at the start of each op.
+	ai, bi := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		ai[i+1], bi[i+1] = ai[i], bi[i]
+		if op.kind != '+' {
+			ai[i+1]++
+		}
+		if op.kind != '-' {
+			bi[i+1]++
+		}
+	}
+	span := func(start, n int) string {
+		if n == 1 {
+			return fmt.Sprint(start + 1)
+		}
+		if n == 0 {
+			return fmt.Sprintf("%d,0", start)
+		}
+		return fmt.Sprintf("%d,%d", start+1, n)
+	}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk runs from context lines before this change to context
+		// lines after the last change no more than 2*context lines on.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end, kept := i, 0
+		for end < len(ops) && kept <= 2*context {
+			if ops[end].kind == ' ' {
+				kept++
+			} else {
+				kept = 0
+			}
+			end++
+		}
+		end -= kept - context
+		if end > len(ops) {
+			end = len(ops)
+		}
+		if !header {
+			fmt.Fprintf(w, "--- %s\n+++ %s\n", name, name)
+			header = true
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", span(ai[start], ai[end]-ai[start]), span(bi[start], bi[end]-bi[start]))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(w, "%c%s", op.kind, op.line)
+			if len(op.line) == 0 || op.line[len(op.line)-1] != '\n' {
+				io.WriteString(w, "\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+}
+
+// golf:prelude end
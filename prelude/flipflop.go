@@ -0,0 +1,78 @@
+package prelude
+
+import (
+	"regexp"
+	"runtime"
+	"sync"
+)
+
+// This file is only embedded in the generated program when the script uses
+// Between or FlipFlop.
+
+// golf:prelude start
+
+// FlipFlop selects blocks of lines, from one matching a start regexp to the
+// next one matching an end regexp, inclusive, like perl's scalar ..
+// operator. See Between.
+type FlipFlop struct {
+	start, end *regexp.Regexp
+	on         bool
+}
+
+// NewFlipFlop returns a FlipFlop for blocks from startRE to endRE.
+func NewFlipFlop(startRE, endRE string) *FlipFlop {
+	f := &FlipFlop{}
+	var err error
+	if f.start, err = regexp.Compile(startRE); err != nil {
+		Die("FlipFlop: invalid start regexp: %v", err)
+	}
+	if f.end, err = regexp.Compile(endRE); err != nil {
+		Die("FlipFlop: invalid end regexp: %v", err)
+	}
+	return f
+}
+
+// Match reports whether line is in a block, given the lines passed before.
+// As with perl's .., a line matching both regexps is a block by itself.
+func (f *FlipFlop) Match(line string) bool {
+	if !f.on {
+		if !f.start.MatchString(line) {
+			return false
+		}
+		f.on = true
+	}
+	if f.end.MatchString(line) {
+		f.on = false
+	}
+	return true
+}
+
+// Reset ends the current block, if any.
+func (f *FlipFlop) Reset() {
+	f.on = false
+}
+
+var (
+	golfFlipFlopsMu sync.Mutex
+	golfFlipFlops   = map[uintptr]*FlipFlop{}
+)
+
+// Between reports whether Line is in a block from a line matching startRE to
+// the next one matching endRE, inclusive, like perl's scalar .. operator.
+// Each call of Between in the source keeps its own state, across input
+// files; use a FlipFlop to reset it, or to test other strings than Line.
+//
+//	golf -ne 'Between("BEGIN CERT", "END CERT") { Print(Line) }' cert.pem
+func Between(startRE, endRE string) bool {
+	pc, _, _, _ := runtime.Caller(1)
+	golfFlipFlopsMu.Lock()
+	f := golfFlipFlops[pc]
+	if f == nil {
+		f = NewFlipFlop(startRE, endRE)
+		golfFlipFlops[pc] = f
+	}
+	golfFlipFlopsMu.Unlock()
+	return f.Match(Line)
+}
+
+// golf:prelude end
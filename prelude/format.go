@@ -0,0 +1,17 @@
+package prelude
+
+// This file is only embedded in the generated program with --format
+// templates that must be executed at run time.
+
+// golf:prelude start
+
+// golfRecord is the data for --format templates.
+type golfRecord struct{}
+
+func (golfRecord) F(n int) string   { return Field(n) }
+func (golfRecord) Line() string     { return Line }
+func (golfRecord) LineNum() int     { return LineNum }
+func (golfRecord) Filename() string { return Filename }
+func (golfRecord) Fields() []string { return Fields }
+
+// golf:prelude end
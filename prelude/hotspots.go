@@ -0,0 +1,75 @@
+package prelude
+
+import (
+	"time"
+)
+
+// This file is only embedded in the generated program with --hotspots.
+
+// golf:prelude start
+
+// golfHotspots attributes the run time of the line loop to its phases, for
+// --hotspots. Only one line in golfHotEvery is timed, to keep the overhead of
+// calling time.Now low.
+type golfHotspots struct {
+	lines, samples int
+	sampled        bool
+	cur            int // phase being timed.
+	mark           time.Time
+	spent          [golfHotPhases]time.Duration
+}
+
+const (
+	golfHotNone = iota
+	golfHotPrint
+	golfHotRead
+	golfHotSplit
+	golfHotScript
+	golfHotPhases
+
+	golfHotEvery = 64
+)
+
+var golfHotNames = [golfHotPhases]string{"", "print", "read", "split", "script"}
+
+// line starts timing a new line loop iteration.
+// Time since the previous lap is charged to the script.
+func (h *golfHotspots) line() {
+	h.lap(golfHotNone)
+	h.lines++
+	if h.sampled = h.lines%golfHotEvery == 1; h.sampled {
+		h.samples++
+		h.cur, h.mark = golfHotPrint, time.Now()
+	}
+}
+
+// lap charges the time since the last lap to the current phase, and starts
+// timing the next one.
+func (h *golfHotspots) lap(next int) {
+	if !h.sampled {
+		return
+	}
+	now := time.Now()
+	h.spent[h.cur] += now.Sub(h.mark)
+	h.cur, h.mark = next, now
+}
+
+// report prints the share of time spent in each phase to stderr.
+func (h *golfHotspots) report() {
+	h.lap(golfHotNone)
+	h.sampled = false
+	var total time.Duration
+	for _, d := range h.spent[golfHotNone+1:] {
+		total += d
+	}
+	if total == 0 {
+		Warn("golf: hotspots: no lines sampled")
+		return
+	}
+	Warn("golf: hotspots (1 in %d lines sampled, %d samples):", golfHotEvery, h.samples)
+	for ph := golfHotNone + 1; ph < golfHotPhases; ph++ {
+		Warn("golf:   %-6s %5.1f%%", golfHotNames[ph], 100*float64(h.spent[ph])/float64(total))
+	}
+}
+
+// golf:prelude end
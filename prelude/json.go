@@ -0,0 +1,219 @@
+package prelude
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This file is only embedded in the generated program with -j, or when the
+// script uses the JSON output helpers.
+
+// golf:prelude start
+
+var (
+	// J is the current line parsed as a JSON object, with -j. It is nil if
+	// the line is not a JSON object. Numbers are json.Number, which print
+	// as they were written.
+	J map[string]interface{}
+
+	// golfJValue is the current line parsed as any JSON value, for JQ.
+	golfJValue interface{}
+)
+
+// golfParseJSON parses Line into J, for -j. Lines that aren't JSON leave J
+// nil, and count as warnings in -w mode.
+func golfParseJSON() {
+	J, golfJValue = nil, nil
+	d := json.NewDecoder(strings.NewReader(Line))
+	d.UseNumber()
+	if err := d.Decode(&golfJValue); err != nil {
+		golfJValue = nil
+		if Warnings {
+			golfNoteWarning("invalid JSON", err.Error())
+		}
+		return
+	}
+	J, _ = golfJValue.(map[string]interface{})
+}
+
+// JQ returns the value at path in the current line, with -j, or nil if
+// there is none. A path is a dotted list of object keys, each of which may
+// be followed by array indexes, negative ones counting from the end; "" is
+// the whole line.
+//
+//	golf -j -lne 'if JQ("level") == "error" { Print(JQ("items[0].id")) }' app.log
+func JQ(path string) interface{} {
+	v := golfJValue
+	for _, step := range golfJPath(path) {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			if step.index != nil {
+				return nil
+			}
+			v = x[step.key]
+		case []interface{}:
+			if step.index == nil {
+				return nil
+			}
+			i := *step.index
+			if i < 0 {
+				i += len(x)
+			}
+			if i < 0 || i >= len(x) {
+				return nil
+			}
+			v = x[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// JQS is JQ as a string: strings and numbers as they are, other values as
+// JSON, and nil as "".
+//
+//	golf -j -b 'sum := 0' -lne 'sum += GAtoi(JQS("bytes"))' -E 'Print(sum)' app.log
+func JQS(path string) string {
+	switch v := JQ(path).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return string(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// ToJSON returns v as one line of JSON. Unlike json.Marshal, it leaves <, >
+// and & as they are. Values that can't be encoded are fatal.
+//
+//	golf -F : -lne 'Print(ToJSON(map[string]string{"user": Field(1), "shell": Field(7)}))' /etc/passwd
+func ToJSON(v interface{}) string {
+	return golfToJSON(v, "")
+}
+
+// ToJSONPretty is ToJSON indented with two spaces, over several lines.
+func ToJSONPretty(v interface{}) string {
+	return golfToJSON(v, "  ")
+}
+
+// PrintJSON prints v as one line of JSON, such as a record of JSON lines.
+func PrintJSON(v interface{}) {
+	Print(golfJSONRecord(ToJSON(v)))
+}
+
+// PrintJSONPretty prints v as indented JSON.
+func PrintJSONPretty(v interface{}) {
+	Print(golfJSONRecord(ToJSONPretty(v)))
+}
+
+// FromJSON decodes the JSON document s into v, which must be a pointer,
+// such as to a struct describing a known payload. Numbers decoded into
+// interface{} values are json.Number, as in J. If s can't be decoded, golf
+// dies, or, in -w mode, counts a warning and sets *v to its zero value.
+//
+//	golf -b 'var ev struct{ User string; Ms int }' -lane 'FromJSON(Field(2), &ev); Print(ev.User, ev.Ms)' events.log
+func FromJSON(s string, v interface{}) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	err := d.Decode(v)
+	if err == nil {
+		if _, err = d.Token(); err == io.EOF {
+			return
+		} else if err == nil {
+			err = fmt.Errorf("more than one JSON value")
+		}
+	}
+	if Warnings && !Strict {
+		golfNoteWarning("FromJSON: invalid JSON", err.Error())
+		if p := reflect.ValueOf(v); p.Kind() == reflect.Ptr && !p.IsNil() {
+			p.Elem().Set(reflect.Zero(p.Elem().Type()))
+		}
+		return
+	}
+	if LineNum > 0 {
+		Die("%s:%d: FromJSON: %v", Filename, LineNum, err)
+	}
+	Die("FromJSON: %v", err)
+}
+
+// FromJSONMap decodes the JSON object s, as FromJSON does. It returns nil if
+// s is not an object.
+func FromJSONMap(s string) map[string]interface{} {
+	var m map[string]interface{}
+	FromJSON(s, &m)
+	return m
+}
+
+func golfToJSON(v interface{}, indent string) string {
+	// A buffer of its own, as -Pmap bodies encode concurrently.
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", indent)
+	if err := e.Encode(v); err != nil {
+		Die("golf: ToJSON: %v", err)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// golfJSONRecord returns s as PrintJSON prints it: ending with a newline
+// even without -l.
+func golfJSONRecord(s string) string {
+	if !GolfFlgL {
+		s += "\n"
+	}
+	return s
+}
+
+// golfJStep is a step of a JQ path: an object key, or an array index.
+type golfJStep struct {
+	key   string
+	index *int
+}
+
+// golfJPaths caches parsed JQ paths, which are usually constants.
+var golfJPaths sync.Map
+
+// golfJPath parses a JQ path.
+func golfJPath(path string) []golfJStep {
+	if v, ok := golfJPaths.Load(path); ok {
+		return v.([]golfJStep)
+	}
+	var steps []golfJStep
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			key = part[:i]
+		}
+		if key != "" {
+			steps = append(steps, golfJStep{key: key})
+		}
+		for rest := part[len(key):]; rest != ""; {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end < 0 {
+				Die("golf: JQ: bad path %q", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				Die("golf: JQ: bad index in path %q", path)
+			}
+			steps = append(steps, golfJStep{index: &i})
+			rest = rest[end+1:]
+		}
+	}
+	v, _ := golfJPaths.LoadOrStore(path, steps)
+	return v.([]golfJStep)
+}
+
+// golf:prelude end
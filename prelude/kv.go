@@ -0,0 +1,78 @@
+package prelude
+
+import (
+	"strconv"
+	"strings"
+)
+
+// This file is only embedded in the generated program when the script uses
+// KV.
+
+// golf:prelude start
+
+// KV parses a logfmt record, such as `level=info msg="user created" id=7`,
+// into a map of its keys to their values. Quoted values are unquoted, and
+// keys without a value map to "". It parses line, if given, or else Line.
+//
+//	golf -lne 'if kv := KV(); kv["level"] == "error" { Print(kv["ts"], kv["msg"]) }' app.log
+func KV(line ...string) map[string]string {
+	s := Line
+	if len(line) > 0 {
+		s = line[0]
+	}
+	m := map[string]string{}
+	for i := 0; i < len(s); {
+		if s[i] <= ' ' {
+			i++
+			continue
+		}
+		if s[i] == '"' {
+			// A quoted string where a key should be; skip it.
+			i = golfQuotedEnd(s, i)
+			continue
+		}
+		start := i
+		for i < len(s) && s[i] > ' ' && s[i] != '=' && s[i] != '"' {
+			i++
+		}
+		key, val := s[start:i], ""
+		if i < len(s) && s[i] == '=' {
+			i++
+			start = i
+			if i < len(s) && s[i] == '"' {
+				i = golfQuotedEnd(s, i)
+				val = s[start:i]
+				if u, err := strconv.Unquote(val); err == nil {
+					val = u
+				} else {
+					val = strings.Trim(val, `"`)
+				}
+			} else {
+				for i < len(s) && s[i] > ' ' {
+					i++
+				}
+				val = s[start:i]
+			}
+		}
+		if key != "" {
+			m[key] = val
+		}
+	}
+	return m
+}
+
+// golfQuotedEnd returns the index in s just past the double-quoted string
+// starting at i, or len(s) if it isn't closed.
+func golfQuotedEnd(s string, i int) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// golf:prelude end
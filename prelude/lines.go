@@ -0,0 +1,78 @@
+package prelude
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+)
+
+// This file is only embedded in the generated program with --lines.
+
+// golf:prelude start
+
+// golfLineRange is a --lines range of line numbers, inclusive. Negative
+// numbers count back from the last line, -1, and a To of 0 is the last line.
+type golfLineRange struct{ From, To int }
+
+// golfLines is the --lines selection for one input.
+type golfLines struct {
+	ranges []golfLineRange // With line numbers resolved.
+	last   int             // The last line selected.
+}
+
+// golfNewLines resolves ranges for an input of total lines. If ranges don't
+// count from the end, total may be -1, for unknown.
+func golfNewLines(ranges []golfLineRange, total int) *golfLines {
+	l := &golfLines{}
+	for _, r := range ranges {
+		if r.From < 0 {
+			r.From += total + 1
+		}
+		switch {
+		case r.To == 0 && total < 0:
+			r.To = math.MaxInt
+		case r.To == 0:
+			r.To = total
+		case r.To < 0:
+			r.To += total + 1
+		}
+		if r.To < r.From || r.To < 1 {
+			continue
+		}
+		l.ranges = append(l.ranges, r)
+		if r.To > l.last {
+			l.last = r.To
+		}
+	}
+	return l
+}
+
+// has reports whether line n is selected.
+func (l *golfLines) has(n int) bool {
+	for _, r := range l.ranges {
+		if n >= r.From && n <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// golfCountLines reads all of *r, and returns the number of lines in it,
+// replacing *r with a reader of the same data, for --lines ranges that count
+// from the end.
+func golfCountLines(r **bufio.Reader) int {
+	data, err := io.ReadAll(*r)
+	if err != nil {
+		Die("golf: %s: %v", Filename, err)
+	}
+	*r = bufio.NewReaderSize(bytes.NewReader(data), 64<<10)
+	n := 0
+	for len(data) > 0 {
+		data = data[golfLineLen(data, true):]
+		n++
+	}
+	return n
+}
+
+// golf:prelude end
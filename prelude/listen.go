@@ -0,0 +1,84 @@
+package prelude
+
+import (
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// This file is only embedded in the generated program with --listen.
+
+// golf:prelude start
+
+// golfListen listens on addr, for --listen: a unix socket if it is
+// unix:PATH, or else a TCP [HOST]:PORT. It returns a function that waits for
+// the next connection and opens it as an input named after the peer.
+// Connections are read one at a time; one that sends nothing for idle, if
+// not 0, ends, so that it doesn't hold up the next forever. The listener,
+// and any open connection, are closed when Ctx is done.
+func golfListen(addr string, idle time.Duration) func() (golfInput, func(), bool) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", addr[len("unix:"):]
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		Die("golf: %v", err)
+	}
+	Warn("golf: listening on %s", l.Addr())
+	go func() {
+		<-Ctx.Done()
+		l.Close()
+	}()
+	return func() (golfInput, func(), bool) {
+		// Show what was printed for the last connection while waiting.
+		Flush()
+		c, err := l.Accept()
+		if err != nil {
+			if Ctx.Err() != nil {
+				return golfInput{}, nil, false
+			}
+			Die("golf: %v", err)
+		}
+		name := c.RemoteAddr().String()
+		if network == "unix" {
+			// Unix peers are usually unnamed.
+			name = "unix:" + addr
+		}
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-Ctx.Done():
+				c.Close()
+			case <-done:
+			}
+		}()
+		return golfInput{Name: name, R: &golfIdleConn{c, name, idle}, Size: -1}, func() {
+			close(done)
+			c.Close()
+		}, true
+	}
+}
+
+// golfIdleConn reads from a connection, ending it at EOF once it has been
+// idle for idle, if not 0.
+type golfIdleConn struct {
+	net.Conn
+	name string
+	idle time.Duration
+}
+
+func (c *golfIdleConn) Read(p []byte) (int, error) {
+	if c.idle > 0 {
+		c.SetReadDeadline(time.Now().Add(c.idle))
+	}
+	n, err := c.Conn.Read(p)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		Warn("golf: %s: idle for %v, disconnecting", c.name, c.idle)
+		err = io.EOF
+	}
+	return n, err
+}
+
+// golf:prelude end
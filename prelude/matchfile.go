@@ -0,0 +1,63 @@
+package prelude
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// This file is only embedded in the generated program with --match-file or
+// --exclude-file.
+
+// golf:prelude start
+
+// golfPatterns is a record filter loaded from a --match-file or
+// --exclude-file list.
+type golfPatterns struct {
+	fixed *MM
+	res   []*regexp.Regexp
+}
+
+// golfLoadPatterns reads a pattern list, one pattern per line.
+// Lines of the form /pat/ are regexps, as in GSplit; other lines are fixed
+// strings. Blank lines are ignored.
+func golfLoadPatterns(name string) *golfPatterns {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		Die("golf: pattern list: %v", err)
+	}
+	p := &golfPatterns{}
+	var fixed []string
+	for _, pat := range strings.Split(string(data), "\n") {
+		pat = strings.TrimSuffix(pat, "\r")
+		switch {
+		case pat == "":
+			continue
+		case len(pat) > 1 && pat[0] == '/' && pat[len(pat)-1] == '/':
+			re, err := regexp.Compile(pat[1 : len(pat)-1])
+			if err != nil {
+				Die("golf: %s: invalid regexp: %v", name, err)
+			}
+			p.res = append(p.res, re)
+		default:
+			fixed = append(fixed, pat)
+		}
+	}
+	p.fixed = MultiMatcher(fixed)
+	return p
+}
+
+// match reports whether any pattern in the list occurs in s.
+func (p *golfPatterns) match(s string) bool {
+	if p.fixed.Match(s) {
+		return true
+	}
+	for _, re := range p.res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// golf:prelude end
@@ -0,0 +1,57 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package prelude
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// This file is only embedded in the generated program with -mmap.
+
+// golf:prelude start
+
+// golfMmap maps f into memory for -mmap, if it is a non-empty regular file.
+// Otherwise, for example for pipes, it returns nil, and f should be read
+// normally instead.
+func golfMmap(f *os.File) []byte {
+	st, err := f.Stat()
+	if err != nil || !st.Mode().IsRegular() || st.Size() == 0 || int64(int(st.Size())) != st.Size() {
+		return nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(st.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// golfMunmap unmaps data returned by golfMmap, if any.
+func golfMunmap(data []byte) {
+	if data != nil {
+		syscall.Munmap(data)
+	}
+}
+
+// golfSliceLine is golfReadLine for mapped input: it returns the next line
+// of *data, terminator included, and advances *data past it. The line points
+// into *data.
+func golfSliceLine(data *[]byte, max int) ([]byte, error) {
+	d := *data
+	if len(d) == 0 {
+		return nil, io.EOF
+	}
+	n := golfLineLen(d, true)
+	line := d[:n]
+	if max > 0 && len(bytes.TrimRight(line, "\r\n")) > max {
+		return nil, fmt.Errorf("line longer than -maxline %d bytes", max)
+	}
+	*data = d[n:]
+	return line, nil
+}
+
+// golf:prelude end
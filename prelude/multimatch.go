@@ -0,0 +1,129 @@
+package prelude
+
+// This file is only embedded in the generated program when the script uses
+// MultiMatcher.
+
+// golf:prelude start
+
+// MM is a multi-pattern matcher, built by MultiMatcher.
+//
+// It is an Aho-Corasick automaton: it finds occurrences of any number of
+// fixed strings in a single pass over the input, so classifying lines against
+// hundreds of keywords costs about as much as against one. Unlike a regexp
+// alternation, its size grows only with the total length of the patterns.
+type MM struct {
+	pats  []string
+	edges map[acEdge]int32 // trie transitions. Node 0 is the root.
+	fail  []int32          // longest proper suffix of a node that is in the trie.
+	out   []int32          // index in pats of the pattern ending at a node, or -1.
+	dict  []int32          // nearest node on the fail chain with out >= 0, or -1.
+}
+
+type acEdge struct {
+	node int32
+	b    byte
+}
+
+// MultiMatcher returns a matcher for the fixed strings in pats.
+// Empty patterns are ignored.
+//
+//	kw := MultiMatcher([]string{"ERROR", "FATAL", "panic:"})
+//	if kw.Match(Line) { Print() }
+func MultiMatcher(pats []string) *MM {
+	m := &MM{
+		pats:  pats,
+		edges: map[acEdge]int32{},
+		fail:  []int32{0},
+		out:   []int32{-1},
+		dict:  []int32{-1},
+	}
+	// Only needed while building.
+	kids := [][]int32{nil}
+	label := []byte{0}
+	for i, pat := range pats {
+		if pat == "" {
+			continue
+		}
+		n := int32(0)
+		for j := 0; j < len(pat); j++ {
+			e := acEdge{n, pat[j]}
+			c, ok := m.edges[e]
+			if !ok {
+				c = int32(len(m.fail))
+				m.edges[e] = c
+				m.fail = append(m.fail, 0)
+				m.out = append(m.out, -1)
+				m.dict = append(m.dict, -1)
+				kids = append(kids, nil)
+				label = append(label, pat[j])
+				kids[n] = append(kids[n], c)
+			}
+			n = c
+		}
+		if m.out[n] < 0 {
+			m.out[n] = int32(i)
+		}
+	}
+	// Breadth-first, so that the fail links of shallower nodes are known
+	// by the time we need them. The root's children fail to the root.
+	queue := append([]int32(nil), kids[0]...)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, c := range kids[n] {
+			m.fail[c] = m.step(m.fail[n], label[c])
+			if f := m.fail[c]; m.out[f] >= 0 {
+				m.dict[c] = f
+			} else {
+				m.dict[c] = m.dict[f]
+			}
+			queue = append(queue, c)
+		}
+	}
+	return m
+}
+
+// step returns the node reached from n on input byte b.
+func (m *MM) step(n int32, b byte) int32 {
+	for {
+		if c, ok := m.edges[acEdge{n, b}]; ok {
+			return c
+		}
+		if n == 0 {
+			return 0
+		}
+		n = m.fail[n]
+	}
+}
+
+// Match reports whether any of the patterns occurs in s.
+func (m *MM) Match(s string) bool {
+	n := int32(0)
+	for i := 0; i < len(s); i++ {
+		n = m.step(n, s[i])
+		if m.out[n] >= 0 || m.dict[n] >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// FindAll returns the patterns occurring in s, in order of where each
+// occurrence ends. Overlapping occurrences are all reported.
+func (m *MM) FindAll(s string) []string {
+	var res []string
+	n := int32(0)
+	for i := 0; i < len(s); i++ {
+		n = m.step(n, s[i])
+		k := n
+		if m.out[k] < 0 {
+			k = m.dict[k]
+		}
+		for ; k >= 0; k = m.dict[k] {
+			res = append(res, m.pats[m.out[k]])
+		}
+	}
+	return res
+}
+
+// golf:prelude end
@@ -0,0 +1,28 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package prelude
+
+import (
+	"os"
+	"syscall"
+)
+
+// This file is only embedded in the generated program with -i.
+
+// golf:prelude start
+
+func init() {
+	golfChown = golfChownUnix
+}
+
+// golfChownUnix gives f the owner and group in orig. Only root may give
+// files away, so failures are expected, and ignored: f then keeps the
+// user's own.
+func golfChownUnix(f *os.File, orig os.FileInfo) {
+	if st, ok := orig.Sys().(*syscall.Stat_t); ok {
+		f.Chown(int(st.Uid), int(st.Gid))
+	}
+}
+
+// golf:prelude end
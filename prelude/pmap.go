@@ -0,0 +1,44 @@
+package prelude
+
+import (
+	"bytes"
+	"sync"
+)
+
+// This file is only embedded in the generated program with -Pmap.
+
+// golf:prelude start
+
+// golfMapJob is a line queued for the -e body in -Pmap mode, with its own
+// copies of the per-line variables, and the output the body printed.
+type golfMapJob struct {
+	Filename     string
+	LineNum      int
+	TotalLineNum int
+	Line         string
+	LineEnding   string
+	Fields       []string
+	Out          bytes.Buffer
+}
+
+// golfMapRun calls body on each job, in up to workers goroutines.
+func golfMapRun(jobs []*golfMapJob, workers int, body func(*golfMapJob)) {
+	var wg sync.WaitGroup
+	next := make(chan *golfMapJob)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range next {
+				body(j)
+			}
+		}()
+	}
+	for _, j := range jobs {
+		next <- j
+	}
+	close(next)
+	wg.Wait()
+}
+
+// golf:prelude end
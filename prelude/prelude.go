@@ -6,15 +6,29 @@
 package prelude
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	// Required for go:embed.
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
 )
 
 // Code between these comments is embedded in the golf binary.
@@ -30,24 +44,79 @@ var (
 
 	// Filename is the current filename.
 	Filename string
-	// LineNum is the current line number, 1-based.
+	// LineNum is the current line number in the current file, 1-based, like
+	// awk's FNR.
 	LineNum int
+	// TotalLineNum is the current line number across all the inputs so far,
+	// 1-based, like awk's NR. With -P, each file counts on its own.
+	TotalLineNum int
 	// Line is the current line. It may be edited by the script.
 	// Its contents are automatically printed in -p mode.
 	Line string
+	// LineEnding is the terminator of the current line as read from the
+	// input: "\n", "\r\n", "\r", or "" if the last line had none. Without
+	// -l it is also included in Line.
+	LineEnding string
+
+	// ARGV holds the names of the input files line mode has yet to open,
+	// like perl's @ARGV. It starts as the command line's, and -b, -bf and
+	// -e code may change it to add, drop or reorder inputs. If it is empty
+	// when line mode starts, stdin is read.
+	ARGV []string
+	// ARGS holds the arguments given to golf after --, for the one-liner
+	// itself, as in golf -ne '...' FILE -- ARGS... If there is a --, they
+	// also replace the input files after the program name in os.Args, so
+	// package flag can parse them.
+	ARGS []string
 
 	// Fields is the Split field slice. See the convenience Field accessor.
-	// Updated automatically in -a mode.
+	// Updated automatically in -a mode. Its storage is reused from line to
+	// line if the script doesn't keep it. In scripts, NFields stands for
+	// len(Fields).
 	Fields []string
+	// Header holds the fields of the current file's first line, with -H,
+	// which names the columns for Col.
+	Header []string
+
+	// LineBytes is the current line in -bytes mode, which sets it instead
+	// of Line. It points into the input buffer and is only valid until the
+	// next line is read: copy it, or convert it to a string, to keep it.
+	LineBytes []byte
+	// FieldsBytes is the Split field slice in -bytes -a mode. Like
+	// LineBytes, its elements are only valid until the next line is read.
+	FieldsBytes [][]byte
 
 	// IFS is the input field separator used in -a mode. Overridden by -F.
 	IFS = " "
+	// AutoJoin makes Print with no arguments, and so -p, print Fields
+	// joined with OFS rather than Line, if the script changed Fields but not
+	// Line, like awk rebuilding $0 when a field is assigned to. Set by
+	// --autojoin. It has no effect under -Pmap.
+	AutoJoin = false
+	// MaxSplit, if positive, is the most fields -a mode splits a line into,
+	// the last one holding the rest of the line. Overridden by --maxsplit.
+	MaxSplit = 0
 	// OFS is the output field separator used by Field(0).
 	OFS = " "
-	// Warnings controls whether to print warnings. Overridden by -w.
+	// DefaultField is returned by Field for fields past the end of Fields.
+	// Overridden by --default-field.
+	DefaultField = ""
+	// ORS is the output record separator appended by Print in -l mode.
+	// Overridden by -l with an argument, e.g. -l0 for NUL.
+	ORS = "\n"
+	// Warnings controls whether to collect warnings about undefined fields,
+	// failed conversions and so on. They are summarized at exit; see
+	// WarningReport. Overridden by -w.
 	Warnings = false
+	// Strict makes conversion helpers such as GAtoi Die on input they can't
+	// convert, instead of returning zero. Set by -strict.
+	Strict = false
+	// GolfQuiet suppresses golf's own non-fatal diagnostics. Set by -q.
+	GolfQuiet = false
 	// GolfFlgL controls whether to strip/add newlines on I/O. Overridden by -l.
 	GolfFlgL = false
+	// GolfBytes reports whether we are in -bytes mode.
+	GolfBytes = false
 
 	// -i settings. Note that -i without argument is allowed, it means no backup.
 
@@ -56,12 +125,357 @@ var (
 	GolfInPlace = false
 	// GolfInPlaceBak is the file pattern for in-place edit backups.
 	GolfInPlaceBak string
+	// GolfOutPattern is set by -O, which is in-place edit mode writing to a
+	// new file instead, named by this pattern following the rules of
+	// BackupName, and leaving the input alone.
+	GolfOutPattern string
+	// GolfQuarantine is the file pattern, following the rules of BackupName,
+	// for the files that keep records rejected in in-place edit mode.
+	// Overridden by --quarantine.
+	GolfQuarantine = ".rejected"
+	// GolfLimitAbort makes files over --max-file-size or --per-file-timeout
+	// fatal, instead of skipped. Set by --on-limit=abort.
+	GolfLimitAbort = false
+	// GolfAtomicBatch reports whether in-place edits are staged, and only
+	// committed once every input was processed. Set by --atomic-batch.
+	GolfAtomicBatch = false
+	// GolfKeepMtime reports whether in-place edits keep the modification
+	// time of the files they replace. Set by --keep-mtime.
+	GolfKeepMtime = false
+	// GolfSymlinks says what in-place edit mode does with inputs that are
+	// symbolic links: "replace" them with a regular file, "follow" them and
+	// edit their target, or "refuse" to edit them. Set by --symlinks.
+	GolfSymlinks = "replace"
+
+	// golfQuarantineOut is the quarantine file of the current input, if
+	// any record of it was rejected.
+	golfQuarantineOut io.WriteCloser
+
+	// GolfErrors receives records rejected by --validate.
+	// Overridden by --errors-to.
+	GolfErrors io.Writer = os.Stderr
+
+	// Ctx is cancelled when the one-liner is asked to stop: when --timeout
+	// expires, and, if the script refers to Ctx at all, on SIGINT, SIGTERM
+	// or SIGHUP. Long-running calls should honor it, for example through
+	// http.NewRequestWithContext or exec.CommandContext.
+	Ctx = context.Background()
+
+	// golfStopSignal is the signal that cancelled Ctx, if any.
+	golfStopSignal   os.Signal
+	golfStopSignalMu sync.Mutex
+	// golfTimeoutCancel releases the --timeout timer.
+	golfTimeoutCancel context.CancelFunc = func() {}
+
+	// ExitCode is the status the program exits with when it ends normally,
+	// for example after the END block. See also Exit.
+	ExitCode = 0
+
+	// ErrCount is the number of errors made by GErr and reported with Warn
+	// so far, plus golf's own, such as failures to write. If it is not 0
+	// when the program ends normally, a count is printed to stderr, and an
+	// ExitCode of 0 becomes 1. An -E block may reset it.
+	ErrCount = 0
 
 	// CurOut is the default writer for Print and Printf.
 	// Overridden to each Filename in -i.
-	CurOut io.WriteCloser = os.Stdout
+	// It is buffered; see Flush.
+	CurOut io.WriteCloser = golfStdout
+
+	// GolfFlush controls whether Print and Printf flush CurOut after every
+	// call. It defaults to true when stdout is a terminal. Set by -flush.
+	GolfFlush = golfIsTerminal(os.Stdout)
+
+	golfStdout OutputSink = golfBuffered(os.Stdout)
+
+	// golfFileOut is the replacement for the current file in -i mode.
+	golfFileOut io.WriteCloser
+	// golfFileOutMtime is where to restore the modification time of the
+	// file golfFileOut writes to, for --keep-mtime.
+	golfFileOutMtime *golfMtime
+
+	// golfFileSinks are the sinks opened by FileSink, by name.
+	golfFileSinks = map[string]*golfBufOut{}
+
+	// GolfReuseFields is whether the storage of Fields may be reused from
+	// line to line, which golf sets when the script can't keep Fields past
+	// its line. Otherwise each line's Fields is allocated anew.
+	GolfReuseFields bool
+
+	// Storage for autosplit, reused from line to line if GolfReuseFields
+	// is set. Fields itself is only the view of it the script sees, so if
+	// the script points Fields at a slice of its own, golf won't write into
+	// it.
+	golfFieldsBuf      []string
+	golfFieldsBytesBuf [][]byte
 )
 
+// golfFieldsStorage returns storage for the next line's Fields: that of
+// the last line, if it may be reused, or none.
+func golfFieldsStorage() []string {
+	if !GolfReuseFields {
+		return nil
+	}
+	return golfFieldsBuf[:0]
+}
+
+// golfAutosplit splits the current line into Fields for -a mode.
+func golfAutosplit() {
+	if GolfBytes {
+		golfFieldsBytesBuf = golfSplitBytes(golfFieldsBytesBuf, IFS, LineBytes, MaxSplit)
+		FieldsBytes = golfFieldsBytesBuf
+		return
+	}
+	golfFieldsBuf = golfSplit(golfFieldsStorage(), IFS, Line, MaxSplit)
+	Fields = golfFieldsBuf
+}
+
+// golfBufOut is a buffered io.WriteCloser.
+type golfBufOut struct {
+	*bufio.Writer
+	f io.WriteCloser
+}
+
+func golfBuffered(f io.WriteCloser) *golfBufOut {
+	return &golfBufOut{bufio.NewWriterSize(f, 64<<10), f}
+}
+
+// golfNamedStdout is standard output redirected by -o to the file name, so
+// that errors writing it name the file rather than /dev/stdout.
+type golfNamedStdout struct {
+	*os.File
+	name string
+}
+
+func (o golfNamedStdout) Write(b []byte) (int, error) {
+	n, err := o.File.Write(b)
+	if pe, ok := err.(*os.PathError); ok {
+		pe.Path = o.name
+	}
+	return n, err
+}
+
+// golfNameStdout names standard output for -o, or for -oa, with appending,
+// where it is written a whole record at a time.
+func golfNameStdout(name string, appending bool) {
+	out := golfNamedStdout{os.Stdout, name}
+	if appending {
+		golfStdout = &golfRecordOut{f: out}
+	} else {
+		golfStdout = golfBuffered(out)
+	}
+	CurOut = golfStdout
+}
+
+// golfRecordOut is a buffered output that only writes whole records, up to
+// the end of the last ORS, or newline, buffered, except when flushed. Each
+// write to a file opened for appending goes at its end in one piece, so golf
+// runs adding to the same file with -oa at once don't split each other's
+// records.
+type golfRecordOut struct {
+	buf []byte
+	f   io.WriteCloser
+}
+
+func (o *golfRecordOut) Write(b []byte) (int, error) {
+	o.buf = append(o.buf, b...)
+	if len(o.buf) < 64<<10 {
+		return len(b), nil
+	}
+	end := byte('\n')
+	if GolfFlgL && ORS != "" {
+		end = ORS[len(ORS)-1]
+	}
+	if i := bytes.LastIndexByte(o.buf, end); i >= 0 {
+		if err := o.writeOut(i + 1); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// writeOut writes the first n bytes buffered.
+func (o *golfRecordOut) writeOut(n int) error {
+	_, err := o.f.Write(o.buf[:n])
+	o.buf = o.buf[:copy(o.buf, o.buf[n:])]
+	return err
+}
+
+// Flush writes all the buffered output.
+func (o *golfRecordOut) Flush() error {
+	if len(o.buf) == 0 {
+		return nil
+	}
+	return o.writeOut(len(o.buf))
+}
+
+// Close flushes buffered output and closes the underlying writer.
+func (o *golfRecordOut) Close() error {
+	if err := o.Flush(); err != nil {
+		o.f.Close()
+		return err
+	}
+	return o.f.Close()
+}
+
+// Close flushes buffered output and closes the underlying writer.
+func (o *golfBufOut) Close() error {
+	if err := o.Flush(); err != nil {
+		o.f.Close()
+		return err
+	}
+	return o.f.Close()
+}
+
+// golfGzipFile is an io.WriteCloser that compresses to f.
+type golfGzipFile struct {
+	*gzip.Writer
+	f io.WriteCloser
+}
+
+// Close finishes the compressed stream and closes the underlying file.
+func (g *golfGzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
+
+// golfIsGzip reports whether the input in r is gzip-compressed.
+func golfIsGzip(r *bufio.Reader) bool {
+	b, err := r.Peek(2)
+	return err == nil && b[0] == 0x1f && b[1] == 0x8b
+}
+
+// golfIsBinary reports whether r looks like binary data, as grep decides:
+// whether there's a NUL byte in its first block. Only what a single read
+// returns is looked at, so that a pipe that has yet to fill the buffer, such
+// as one a program writes to as it goes, doesn't hold up the first line.
+func golfIsBinary(r *bufio.Reader) bool {
+	r.Peek(1)
+	b, _ := r.Peek(r.Buffered())
+	return bytes.IndexByte(b, 0) >= 0
+}
+
+// golfGzipInPlace sets up in-place editing of the gzip-compressed Filename:
+// it returns a reader of its decompressed contents from r, and an output
+// that compresses to the file behind out, which must not have been written
+// to yet. The original gzip header, with its name and time, is kept.
+func golfGzipInPlace(r *bufio.Reader, out io.WriteCloser) (*bufio.Reader, io.WriteCloser) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		Die("golf: %s: %v", Filename, err)
+	}
+	f := out.(*golfBufOut).f
+	zw := gzip.NewWriter(f)
+	zw.Header = zr.Header
+	return bufio.NewReaderSize(zr, 64<<10), golfBuffered(&golfGzipFile{zw, f})
+}
+
+// OutputSink is a destination for Print and Printf. See SetSink.
+type OutputSink interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// SetSink flushes the current output, and sends the output of Print and
+// Printf to s from now on. In -i mode, this lasts until the next file.
+func SetSink(s OutputSink) {
+	Flush()
+	CurOut = s
+}
+
+// StdoutSink returns the buffered standard output, CurOut's initial value.
+func StdoutSink() OutputSink {
+	return golfStdout
+}
+
+// FileSink returns a buffered sink writing to the named file, which is
+// created, or truncated, the first time it is asked for. Later calls with
+// the same name return the same sink. Sinks from FileSink are flushed and
+// closed when the program exits.
+func FileSink(name string) OutputSink {
+	if s, ok := golfFileSinks[name]; ok {
+		return s
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		Die("golf: FileSink: %v", err)
+	}
+	s := golfBuffered(f)
+	golfFileSinks[name] = s
+	return s
+}
+
+// golfCloseSinks closes the sinks opened by FileSink.
+func golfCloseSinks() {
+	for name, s := range golfFileSinks {
+		if err := s.Close(); err != nil {
+			golfWarn("golf: %s: %v", name, err)
+		}
+		delete(golfFileSinks, name)
+	}
+}
+
+// golfCheckTerminal tells the user when line mode, with no files named, is
+// about to read a terminal, so that it doesn't seem to hang. With fail, it
+// dies instead.
+func golfCheckTerminal(fail bool) {
+	if !golfIsTerminal(os.Stdin) {
+		return
+	}
+	if fail {
+		Die("golf: no input files, and stdin is a terminal")
+	}
+	golfWarn("golf: reading from terminal; press Ctrl-D or pass filenames")
+}
+
+func golfIsTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// Flush writes any output buffered in CurOut.
+//
+// Output is flushed automatically when switching files in -i mode, at the end
+// of the program, and by Exit and Die. Call Flush yourself before calling
+// os.Exit, or when mixing Print with direct writes to os.Stdout.
+func Flush() {
+	if o, ok := CurOut.(interface{ Flush() error }); ok {
+		if err := o.Flush(); err != nil {
+			golfWarn("golf: flush: %v", err)
+		}
+	}
+}
+
+// golfAtExit cleans up before the program exits: it runs AtExit hooks,
+// flushes output, closes file sinks, summarizes warnings, discards
+// uncommitted --atomic-batch edits and stops the --timeout timer.
+func golfAtExit() {
+	golfRunExitHooks()
+	golfFlushAll()
+	golfCloseSinks()
+	golfWarningSummary()
+	golfDiscardStaged()
+	golfTimeoutCancel()
+}
+
+// golfFlushAll flushes CurOut, stdout and GolfErrors.
+func golfFlushAll() {
+	Flush()
+	for _, w := range []io.Writer{golfStdout, GolfErrors, golfQuarantineOut} {
+		if w == CurOut {
+			continue
+		}
+		if o, ok := w.(interface{ Flush() error }); ok {
+			if err := o.Flush(); err != nil {
+				golfWarn("golf: flush: %v", err)
+			}
+		}
+	}
+}
+
 var (
 	// Join is an alias for strings.Join.
 	Join = strings.Join
@@ -72,21 +486,91 @@ var (
 
 // Print prints a string to CurOut.
 //
-// With no arguments, the string to be printed defaults to Line.
+// With no arguments, the string to be printed defaults to Line, or to
+// LineBytes in -bytes mode. []byte arguments are printed as strings.
 //
-// In -l mode, a newline is appended to the string.
+// In -l mode, ORS (by default a newline) is appended to the string.
 //
 // In -i mode, the "current output" is the replacement for the current
 // Filename. Otherwise, it is os.Stdout.
 func Print(xs ...interface{}) {
-	if len(xs) == 0 {
-		xs = append(xs, Line)
+	golfPrintTo(CurOut, Line, xs)
+	if GolfFlush {
+		Flush()
+	}
+}
+
+// golfPrintTo implements Print, writing to w, with line as the default
+// string.
+func golfPrintTo(w io.Writer, line string, xs []interface{}) {
+	if !golfPrintOne(w, line, xs) {
+		// Arguments are converted in a copy of xs, which may be the
+		// caller's slice, as in Print(xs...).
+		copied := false
+		for i, x := range xs {
+			var y interface{}
+			switch x := x.(type) {
+			case []byte:
+				y = string(x)
+			case float64:
+				if golfPrecision < 0 {
+					continue
+				}
+				y = golfFixed(x)
+			case float32:
+				if golfPrecision < 0 {
+					continue
+				}
+				y = golfFixed(x)
+			default:
+				continue
+			}
+			if !copied {
+				xs, copied = append([]interface{}(nil), xs...), true
+			}
+			xs[i] = y
+		}
+		if GolfFlgL {
+			s := fmt.Sprintln(xs...)
+			io.WriteString(w, s[:len(s)-1])
+		} else {
+			fmt.Fprint(w, xs...)
+		}
 	}
 	if GolfFlgL {
-		fmt.Fprintln(CurOut, xs...)
-	} else {
-		fmt.Fprint(CurOut, xs...)
+		io.WriteString(w, ORS)
+	}
+}
+
+// golfPrintOne writes the only argument to Print, or its default, directly
+// to w if it is a string or []byte, skipping fmt. This keeps -p and
+// Print() free of allocations. It reports whether it did so.
+func golfPrintOne(w io.Writer, line string, xs []interface{}) bool {
+	switch {
+	case len(xs) == 0 && GolfBytes:
+		w.Write(LineBytes)
+		return true
+	case len(xs) == 0 && golfDefaultLine != nil:
+		io.WriteString(w, golfDefaultLine())
+		return true
+	case len(xs) == 0 && AutoJoin:
+		io.WriteString(w, golfAutoJoin(line))
+		return true
+	case len(xs) == 0:
+		io.WriteString(w, line)
+		return true
+	case len(xs) > 1:
+		return false
+	}
+	switch x := xs[0].(type) {
+	case string:
+		io.WriteString(w, x)
+	case []byte:
+		w.Write(x)
+	default:
+		return false
 	}
+	return true
 }
 
 // Printf prints a string to CurOut.
@@ -98,33 +582,274 @@ func Print(xs ...interface{}) {
 // as a default string.
 func Printf(format string, xs ...interface{}) {
 	fmt.Fprintf(CurOut, format, xs...)
+	if GolfFlush {
+		Flush()
+	}
+}
+
+// golfPrecision is the number of decimals Print shows of floats, or -1 for
+// as many as needed. See SetPrecision.
+var golfPrecision = -1
+
+// golfFixed is a float printed with golfPrecision decimals. It is not a
+// string, so that fmt.Print still spaces it from neighboring numbers.
+type golfFixed float64
+
+func (f golfFixed) String() string {
+	return strconv.FormatFloat(float64(f), 'f', golfPrecision, 64)
+}
+
+// SetPrecision makes Print show float64 and float32 values with n digits
+// after the decimal point, as with %.nf, instead of the shortest
+// representation, which may use an exponent. A negative n restores the
+// default. Like awk's OFMT, it only affects output: fmt.Sprint and strconv
+// conversions keep full precision, and Printf follows its format.
+func SetPrecision(n int) {
+	golfPrecision = n
+}
+
+// Round returns x rounded to n decimal places, half away from zero. A
+// negative n rounds to tens, hundreds, and so on. Like Floor and Ceil, it
+// goes by x as written in decimal, so Round(1.005, 2) is 1.01, though the
+// nearest float64 to 1.005 is a little less.
+func Round(x float64, n int) float64 {
+	return golfShift(math.Round(golfShift(x, n)), -n)
+}
+
+// Floor returns x rounded down to n decimal places.
+func Floor(x float64, n int) float64 {
+	return golfShift(math.Floor(golfShift(x, n)), -n)
+}
+
+// Ceil returns x rounded up to n decimal places.
+func Ceil(x float64, n int) float64 {
+	return golfShift(math.Ceil(golfShift(x, n)), -n)
+}
+
+// golfShift returns x times 10 to the n, to 15 significant digits, which
+// drops the error of the float64 product: 0.29 times 100 is 29, rather than
+// 28.999999999999996.
+func golfShift(x float64, n int) float64 {
+	if n >= 0 {
+		x *= math.Pow(10, float64(n))
+	} else {
+		x /= math.Pow(10, float64(-n))
+	}
+	y, _ := strconv.ParseFloat(strconv.FormatFloat(x, 'g', 15, 64), 64)
+	return y
 }
 
 // GAtoi calls strconv.Atoi on s, and issues an optional warning
-// if that returned an error.
+// if that returned an error. In -strict mode, it dies instead.
 func GAtoi(s string) int {
 	i, err := strconv.Atoi(s)
-	if err != nil && Warnings {
-		Warn(err)
+	if err != nil {
+		golfConvError(err)
 	}
 	return i // defaults to 0 on parse fail
 }
 
-// Die prints an error to stderr and exits the program with a failure status.
+// golfConvError reports a failed conversion: fatally in -strict mode,
+// otherwise as an optional warning.
+func golfConvError(err error) {
+	switch {
+	case Strict && LineNum > 0:
+		Die("%s:%d: %v", Filename, LineNum, err)
+	case Strict:
+		Die(err)
+	case Warnings:
+		if ne, ok := err.(*strconv.NumError); ok {
+			golfNoteWarning("strconv."+ne.Func+": "+ne.Err.Error(), strconv.Quote(ne.Num))
+		} else {
+			golfNoteWarning(err.Error(), "")
+		}
+	}
+}
+
+var (
+	golfLineHooks []func() bool
+	golfFileHooks []func()
+	golfExitHooks []func()
+)
+
+// OnLine registers fn to run on each record in line mode, after Line and
+// Fields are set and before the -e script. If fn returns false, the record is
+// skipped, like one failing --validate but without a report. Call it from -b,
+// to keep reusable behavior such as filtering or timing out of -e.
+//
+// Hooks registered with OnLine, OnFile and AtExit run in the order they were
+// registered.
+func OnLine(fn func() bool) {
+	golfLineHooks = append(golfLineHooks, fn)
+}
+
+// OnFile registers fn to run as each input file is opened in line mode,
+// before its first record. Filename is set, and in -i mode output already
+// goes to the new version of the file.
+func OnFile(fn func()) {
+	golfFileHooks = append(golfFileHooks, fn)
+}
+
+// AtExit registers fn to run when the program exits, after -E, or from Exit
+// or Die, before buffered output is flushed.
+func AtExit(fn func()) {
+	golfExitHooks = append(golfExitHooks, fn)
+}
+
+// golfRunLineHooks runs the OnLine hooks, and reports whether all of them
+// kept the record.
+func golfRunLineHooks() bool {
+	for _, fn := range golfLineHooks {
+		if !fn() {
+			return false
+		}
+	}
+	return true
+}
+
+// golfRunFileHooks runs the OnFile hooks.
+func golfRunFileHooks() {
+	for _, fn := range golfFileHooks {
+		fn()
+	}
+}
+
+// golfRunExitHooks runs the AtExit hooks, once, even if one of them calls
+// Exit or Die.
+func golfRunExitHooks() {
+	hooks := golfExitHooks
+	golfExitHooks = nil
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// Next skips the rest of the -e script for the current record, like awk's
+// next, or continue Line. With -p, the record is still printed, except under
+// -Pmap, where Next is return.
+//
+// Next, NextFile and Stop are statements in disguise: golf replaces their
+// calls in line mode scripts with the loop control they stand for, so they
+// can't be called from function literals, nor from -b and -E blocks.
+func Next() {
+	golfNoControl("Next")
+}
+
+// NextFile skips the rest of the current input file, like awk's nextfile,
+// or continue File. It is also available in -bf and -Ef blocks. With -i, the
+// rest of the file is dropped. See Next.
+func NextFile() {
+	golfNoControl("NextFile")
+}
+
+// Stop ends the processing of input, like break File, and goes on with the
+// -E blocks. It is also available in -bf and -Ef blocks. See Next.
+func Stop() {
+	golfNoControl("Stop")
+}
+
+// golfNoControl reports a call of Next, NextFile or Stop that golf didn't
+// replace.
+func golfNoControl(name string) {
+	Die("golf: %s can only be called from the line mode script itself, not here", name)
+}
+
+// Exit flushes buffered output and exits the program with status n.
+// Use it instead of os.Exit, which loses buffered output.
+func Exit(n int) {
+	golfAtExit()
+	golfStopSignalMu.Lock()
+	sig := golfStopSignal
+	golfStopSignalMu.Unlock()
+	if sig != nil {
+		// We stopped cleanly, but let our parent know why.
+		signal.Reset(sig)
+		if self, err := os.FindProcess(os.Getpid()); err == nil && self.Signal(sig) == nil {
+			time.Sleep(time.Second)
+		}
+		n = 128 + int(sig.(syscall.Signal))
+	}
+	os.Exit(n)
+}
+
+// golfCancelOnSignal makes the first SIGINT, SIGTERM or SIGHUP cancel Ctx,
+// instead of killing the program, so that it can stop cleanly. A second
+// one kills it.
+func golfCancelOnSignal() {
+	ctx, cancel := context.WithCancel(Ctx)
+	Ctx = ctx
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-ch
+		golfStopSignalMu.Lock()
+		golfStopSignal = sig
+		golfStopSignalMu.Unlock()
+		cancel()
+		sig = <-ch
+		signal.Reset()
+		if self, err := os.FindProcess(os.Getpid()); err == nil {
+			self.Signal(sig)
+		}
+	}()
+}
+
+// golfSetTimeout makes Ctx expire after d.
+func golfSetTimeout(d time.Duration) {
+	Ctx, golfTimeoutCancel = context.WithTimeout(Ctx, d)
+}
+
+// Die prints an error to stderr and exits the program with a failure status:
+// the exit status of the command, if one of the arguments is an error
+// wrapping an *exec.ExitError, and 1 otherwise. Buffered output is flushed
+// first.
 //
 // Arguments follow the semantics of Warn.
 func Die(xs ...interface{}) {
+	golfAtExit()
 	Warn(xs...)
+	for _, x := range xs {
+		// An *exec.ExitError, matched by method so as not to import
+		// os/exec into every one-liner.
+		var ee interface{ ExitCode() int }
+		if err, ok := x.(error); ok && errors.As(err, &ee) && ee.ExitCode() > 0 {
+			os.Exit(ee.ExitCode())
+		}
+	}
 	os.Exit(1)
 }
 
+// Dief is like Die, but exits with the given status code, so that scripts
+// can tell callers apart different kinds of failure. format is always taken
+// as a format string, and a newline is added if it lacks one.
+func Dief(code int, format string, xs ...interface{}) {
+	golfAtExit()
+	s := fmt.Sprintf(format, xs...)
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	fmt.Fprint(os.Stderr, s)
+	os.Exit(code)
+}
+
 // Warn prints an error to stderr.
 //
 // If no arguments are supplied, a generic message is printed.
 // If multiple arguments are provided and the first one is a string,
 // it is taken as a format string.
 // Otherwise everything is printed using default fmt formatting.
+//
+// Each argument that is an error made by GErr counts towards ErrCount;
+// other errors don't, so that warning about bad input and carrying on
+// doesn't fail the run. Errors wrapped with GErr print as their chain of
+// contexts, outermost first.
 func Warn(xs ...interface{}) {
+	golfCountErrors(xs, false)
+	golfPrintWarning(xs)
+}
+
+// golfPrintWarning prints xs to stderr, as Warn does.
+func golfPrintWarning(xs []interface{}) {
 	if len(xs) == 0 {
 		xs = []interface{}{"Something went wrong while golfing\n"}
 	}
@@ -132,12 +857,398 @@ func Warn(xs ...interface{}) {
 		if !strings.HasSuffix(s, "\n") {
 			s = s + "\n"
 		}
+		// Allow %w, out of habit from fmt.Errorf.
+		s = strings.ReplaceAll(s, "%w", "%v")
 		fmt.Fprintf(os.Stderr, s, xs[1:]...)
 		return
 	}
 	fmt.Fprintln(os.Stderr, xs...)
 }
 
+// golfCountErrors adds the non-nil errors among xs to ErrCount: all of
+// them, or only those made by GErr.
+func golfCountErrors(xs []interface{}, all bool) {
+	for _, x := range xs {
+		var g golfErr
+		if err, ok := x.(error); ok && err != nil && (all || errors.As(err, &g)) {
+			golfWarningsMu.Lock()
+			ErrCount++
+			golfWarningsMu.Unlock()
+		}
+	}
+}
+
+// GErr adds context to err, described by format and xs, as in
+// fmt.Errorf(format + ": %w", xs..., err). It returns nil if err is nil, so
+// results can be wrapped unconditionally:
+//
+//	data, err := os.ReadFile(name)
+//	if err = GErr(err, "config %s", name); err != nil {
+//		Die(err)
+//	}
+//
+// Warn counts the errors GErr makes towards ErrCount.
+func GErr(err error, format string, xs ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return golfErr{fmt.Errorf(format+": %w", append(xs, err)...)}
+}
+
+// golfErr is an error made by GErr, or one of golf's own that Warn should
+// count.
+type golfErr struct{ error }
+
+func (e golfErr) Unwrap() error { return e.error }
+
+// golfErrSummary prints the number of errors reported, if any, when the
+// program ends normally, and makes sure it exits with a failure status then.
+func golfErrSummary() {
+	golfWarningsMu.Lock()
+	n := ErrCount
+	golfWarningsMu.Unlock()
+	if n == 0 {
+		return
+	}
+	if n == 1 {
+		golfWarn("golf: 1 error")
+	} else {
+		golfWarn("golf: %d errors", n)
+	}
+	if ExitCode == 0 {
+		ExitCode = 1
+	}
+}
+
+// WarningCount is a kind of warning collected in -w mode.
+type WarningCount struct {
+	Msg   string // The warning, without details that vary between lines.
+	Count int
+	First string // Where it first occurred, with details.
+}
+
+var (
+	golfWarnings   = map[string]*WarningCount{}
+	golfWarningsMu sync.Mutex // -Pmap bodies may warn concurrently.
+)
+
+// golfNoteWarning counts a warning of kind msg. detail describes this
+// particular occurrence, and is kept for the first one.
+func golfNoteWarning(msg, detail string) {
+	golfWarningsMu.Lock()
+	defer golfWarningsMu.Unlock()
+	if w, ok := golfWarnings[msg]; ok {
+		w.Count++
+		return
+	}
+	first := detail
+	if LineNum > 0 {
+		first = fmt.Sprintf("%s:%d: %s", Filename, LineNum, detail)
+	}
+	golfWarnings[msg] = &WarningCount{Msg: msg, Count: 1, First: first}
+}
+
+// WarningReport returns the warnings collected so far in -w mode, most
+// frequent first. They are printed to stderr when the program exits, but
+// an -E block can also inspect them, for example to fail on bad data:
+//
+//	golf -w -b 'sum := 0' -ane 'sum += GAtoi(Field(3))' -E 'Print(sum); if len(WarningReport()) > 0 { ExitCode = 1 }'
+func WarningReport() []WarningCount {
+	golfWarningsMu.Lock()
+	defer golfWarningsMu.Unlock()
+	res := make([]WarningCount, 0, len(golfWarnings))
+	for _, w := range golfWarnings {
+		res = append(res, *w)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Msg < res[j].Msg
+	})
+	return res
+}
+
+// golfWarningSummary prints the collected warnings to stderr, once.
+func golfWarningSummary() {
+	report := WarningReport()
+	golfWarningsMu.Lock()
+	golfWarnings = map[string]*WarningCount{}
+	golfWarningsMu.Unlock()
+	if len(report) == 0 {
+		return
+	}
+	Warn("golf: warnings:")
+	for _, w := range report {
+		Warn("golf: %8d  %s (first at %s)", w.Count, w.Msg, w.First)
+	}
+}
+
+// golfStagedFile is an in-place output staged by --atomic-batch.
+type golfStagedFile struct{ tmp, name string }
+
+// golfChown gives f the owner and group in orig, where the system allows it.
+// Only set where ownership is supported.
+var golfChown func(f *os.File, orig os.FileInfo)
+
+type golfMtime struct {
+	name  string
+	mtime time.Time
+}
+
+// golfKeepAttrs gives f, the in-place output replacing a file whose
+// attributes were orig, the same permissions and, where possible, owner.
+// With --keep-mtime, its modification time is restored on close, by
+// golfCloseFileOut.
+func golfKeepAttrs(f *os.File, orig os.FileInfo) {
+	if golfChown != nil {
+		// Before Chmod, since chown clears the setuid and setgid bits.
+		golfChown(f, orig)
+	}
+	if err := f.Chmod(orig.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+		golfWarn("golf: %s: %v", Filename, err)
+	}
+	if GolfKeepMtime {
+		golfFileOutMtime = &golfMtime{f.Name(), orig.ModTime()}
+	}
+}
+
+// golfCloseFileOut closes the in-place output of the current file, and
+// renames it over the original, unless --atomic-batch leaves that for later.
+// If the output can't be written in full, the original is kept.
+func golfCloseFileOut() {
+	if err := golfFileOut.Close(); err != nil {
+		Die("golf: can't close current output: %v", err)
+	}
+	golfFileOut = nil
+	if m := golfFileOutMtime; m != nil {
+		if err := os.Chtimes(m.name, m.mtime, m.mtime); err != nil {
+			golfWarn("golf: --keep-mtime: %v", err)
+		}
+		golfFileOutMtime = nil
+	}
+	if !GolfAtomicBatch {
+		if err := golfCommit(golfStaged[0]); err != nil {
+			Die("golf: %v", err)
+		}
+		golfStaged = nil
+	}
+}
+
+// golfStaged holds the staged outputs that are pending commit.
+var golfStaged []golfStagedFile
+
+// golfInPlaceTarget returns the file that in-place edit mode replaces for
+// the input name, according to GolfSymlinks, or with -O, the file it writes,
+// creating its directory if needed.
+func golfInPlaceTarget(name string) (string, error) {
+	if GolfOutPattern != "" {
+		out := BackupName(name, GolfOutPattern)
+		if filepath.Clean(out) == filepath.Clean(name) {
+			return "", fmt.Errorf("golf: %s: -O %s would overwrite the input", name, GolfOutPattern)
+		}
+		if err := os.MkdirAll(filepath.Dir(out), 0777); err != nil {
+			return "", fmt.Errorf("golf: -O: %v", err)
+		}
+		return out, nil
+	}
+	fi, err := os.Lstat(name)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return name, nil
+	}
+	switch GolfSymlinks {
+	case "follow":
+		return filepath.EvalSymlinks(name)
+	case "refuse":
+		return "", fmt.Errorf("golf: %s: not editing a symbolic link (--symlinks=refuse)", name)
+	}
+	return name, nil
+}
+
+// golfStage creates a temporary output for name in its directory, to be
+// renamed over it by golfCommit.
+func golfStage(name string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".golf-*")
+	if err != nil {
+		return nil, err
+	}
+	golfStaged = append(golfStaged, golfStagedFile{f.Name(), name})
+	return f, nil
+}
+
+// golfPreview shows a staged output instead of committing it, for
+// --dry-run. Only set then.
+var golfPreview func(st golfStagedFile) error
+
+// golfCommit renames a staged output into place, making a backup first if
+// -I was given. An output identical to its input is discarded instead, so
+// that no-op edits leave files, their inodes and their mtimes alone.
+func golfCommit(st golfStagedFile) error {
+	if golfPreview != nil {
+		return golfPreview(st)
+	}
+	if same, err := golfSameContents(st.name, st.tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	} else if same {
+		return os.Remove(st.tmp)
+	}
+	if GolfInPlaceBak != "" {
+		bak := BackupName(st.name, GolfInPlaceBak)
+		if err := os.MkdirAll(filepath.Dir(bak), 0777); err != nil {
+			return fmt.Errorf("in-place backup: %v", err)
+		}
+		if err := os.Rename(st.name, bak); err != nil {
+			return fmt.Errorf("in-place backup: %v", err)
+		}
+	}
+	return os.Rename(st.tmp, st.name)
+}
+
+// golfSameContents reports whether the named files hold the same bytes.
+func golfSameContents(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+	sa, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	sb, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if sa.Size() != sb.Size() {
+		return false, nil
+	}
+	ba, bb := make([]byte, 64<<10), make([]byte, 64<<10)
+	for {
+		na, errA := io.ReadFull(fa, ba)
+		nb, errB := io.ReadFull(fb, bb)
+		if !bytes.Equal(ba[:na], bb[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return true, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// golfCommitStaged commits all the staged outputs, for --atomic-batch.
+func golfCommitStaged() {
+	n := len(golfStaged)
+	for len(golfStaged) > 0 {
+		if err := golfCommit(golfStaged[0]); err != nil {
+			Die("golf: --atomic-batch: %v (committed %d of %d staged files)", err, n-len(golfStaged), n)
+		}
+		golfStaged = golfStaged[1:]
+	}
+	if golfPreview == nil {
+		golfWarn("golf: --atomic-batch: committed %d of %d staged files", n, n)
+	}
+}
+
+// golfDiscardStaged removes the staged outputs that were not committed,
+// leaving their inputs untouched.
+func golfDiscardStaged() {
+	if len(golfStaged) == 0 {
+		return
+	}
+	for _, st := range golfStaged {
+		os.Remove(st.tmp)
+	}
+	if GolfAtomicBatch {
+		golfWarn("golf: --atomic-batch: discarded %d staged files; their inputs are unchanged", len(golfStaged))
+	} else {
+		golfWarn("golf: %s: unfinished in-place edit discarded; the file is unchanged", golfStaged[0].name)
+	}
+	golfStaged = nil
+}
+
+// golfOverLimit reports that the current file is over a --max-file-size or
+// --per-file-timeout limit, as explained by msg: fatally with
+// --on-limit=abort, and otherwise as a warning, as the file is skipped.
+func golfOverLimit(msg string) {
+	if GolfLimitAbort {
+		Die("golf: %s", msg)
+	}
+	golfWarn("golf: %s; skipped", msg)
+}
+
+// golfRecordText returns the current record, without its line terminator.
+func golfRecordText() string {
+	if GolfBytes {
+		return string(bytes.TrimSuffix(LineBytes, []byte(LineEnding)))
+	}
+	return strings.TrimSuffix(Line, LineEnding)
+}
+
+// golfReject reports the current record as rejected, for reason.
+// In -i mode, it also quarantines the record, since it won't make it to the
+// edited file. -O leaves the original, record included, alone.
+func golfReject(reason string) {
+	fmt.Fprintf(GolfErrors, "%s:%d: %s: %s\n", Filename, LineNum, reason, golfRecordText())
+	if GolfInPlace && GolfOutPattern == "" {
+		golfQuarantine()
+	}
+}
+
+// golfQuarantine saves the original of the current record to the quarantine
+// file of Filename, creating it on first use.
+func golfQuarantine() {
+	if golfPreview != nil {
+		// --dry-run: the original is left alone anyway.
+		return
+	}
+	if golfQuarantineOut == nil {
+		name := BackupName(Filename, GolfQuarantine)
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			Die("golf: quarantine: %v", err)
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			Die("golf: quarantine: %v", err)
+		}
+		golfQuarantineOut = golfBuffered(f)
+	}
+	io.WriteString(golfQuarantineOut, golfRecordText())
+	io.WriteString(golfQuarantineOut, LineEnding)
+}
+
+// golfCloseQuarantine closes the quarantine file of the current input, if
+// one was created.
+func golfCloseQuarantine() {
+	if golfQuarantineOut == nil {
+		return
+	}
+	if err := golfQuarantineOut.Close(); err != nil {
+		golfWarn("golf: can't close quarantine: %v", err)
+	}
+	golfQuarantineOut = nil
+}
+
+// golfWarn prints a non-fatal diagnostic from golf itself, unless -q. All
+// the errors in it count towards ErrCount, with -q too, so that -q doesn't
+// change the exit status.
+func golfWarn(xs ...interface{}) {
+	golfCountErrors(xs, true)
+	if !GolfQuiet {
+		golfPrintWarning(xs)
+	}
+}
+
 // GSplit splits an input string, with some golf affordances.
 // It is a bit more similar to Perl's split than it is to strings.Split.
 //
@@ -152,41 +1263,382 @@ func Warn(xs ...interface{}) {
 //
 // Otherwise, sep is taken as a literal for strings.Split.
 func GSplit(sep, input string) []string {
-	if sep == " " {
-		return strings.Fields(input)
+	return golfSplit(nil, sep, input, -1)
+}
+
+// GSplitN is like GSplit, but returns at most n fields if n > 0, the last
+// of them being the unsplit remainder, like strings.SplitN.
+func GSplitN(sep, input string, n int) []string {
+	return golfSplit(nil, sep, input, n)
+}
+
+// GSplitBytes is like GSplit, for byte slices.
+// The returned fields point into input.
+func GSplitBytes(sep string, input []byte) [][]byte {
+	return golfSplitBytes(nil, sep, input, -1)
+}
+
+// golfASCIISpace is the set of ASCII bytes strings.Fields considers spaces.
+var golfASCIISpace = [256]bool{'\t': true, '\n': true, '\v': true, '\f': true, '\r': true, ' ': true}
+
+// golfSplit implements GSplitN, appending the fields to dst[:0] so that the
+// line loop can reuse its storage from line to line. Splitting on
+// whitespace or on a single byte, the common cases, is done with simple byte
+// loops.
+func golfSplit(dst []string, sep, input string, n int) []string {
+	dst = dst[:0]
+	if n <= 0 {
+		n = -1
 	}
-	if len(sep) > 1 && sep[0] == '/' && sep[len(sep)-1] == '/' {
-		// Sure, we could memoize regexp compilation.
-		if re, err := regexp.Compile(sep[1 : len(sep)-1]); err != nil {
-			Die("Invalid GSplit regexp separator (check -F flag): %v", err)
-		} else {
-			return re.Split(input, -1)
+	switch {
+	case sep == " ":
+		for i := 0; i < len(input); {
+			for i < len(input) && golfASCIISpace[input[i]] {
+				i++
+			}
+			if len(dst) == n-1 && i < len(input) {
+				return append(dst, strings.TrimRightFunc(input[i:], unicode.IsSpace))
+			}
+			start := i
+			for ; i < len(input) && !golfASCIISpace[input[i]]; i++ {
+				if input[i] >= 0x80 {
+					// Unicode spaces are rare; let the stdlib handle them.
+					return append(dst[:0], golfFieldsN(input, n)...)
+				}
+			}
+			if i > start {
+				dst = append(dst, input[start:i])
+			}
+		}
+		return dst
+	case golfIsSplitRegexp(sep):
+		// This follows regexp.Split, but appends to dst.
+		if len(input) == 0 {
+			return append(dst, input)
+		}
+		beg, end := 0, 0
+		for _, m := range golfSplitRegexp(sep).FindAllStringIndex(input, n) {
+			if len(dst) == n-1 {
+				break
+			}
+			end = m[0]
+			if m[1] != 0 {
+				dst = append(dst, input[beg:end])
+			}
+			beg = m[1]
+		}
+		if end != len(input) {
+			dst = append(dst, input[beg:])
+		}
+		return dst
+	case len(sep) == 1:
+		for {
+			i := strings.IndexByte(input, sep[0])
+			if i < 0 || len(dst) == n-1 {
+				return append(dst, input)
+			}
+			dst = append(dst, input[:i])
+			input = input[i+1:]
 		}
+	case sep == "":
+		return append(dst, strings.SplitN(input, "", n)...)
 	}
-	return strings.Split(input, sep)
+	for {
+		i := strings.Index(input, sep)
+		if i < 0 || len(dst) == n-1 {
+			return append(dst, input)
+		}
+		dst = append(dst, input[:i])
+		input = input[i+len(sep):]
+	}
+}
+
+// golfIsSplitRegexp reports whether the separator sep has the form /pat/.
+func golfIsSplitRegexp(sep string) bool {
+	return len(sep) > 1 && sep[0] == '/' && sep[len(sep)-1] == '/'
+}
+
+// golfIFSRegexp is IFS compiled, if it is a /pat/ regexp, by
+// golfCompileIFS. It is set before the script runs, and not changed after,
+// so that splitting needn't look the regexp up on every line.
+var golfIFSRegexp struct {
+	sep string
+	re  *regexp.Regexp
+}
+
+// golfSplitREs caches the compiled regexps of other /pat/ separators.
+var golfSplitREs sync.Map
+
+// golfCompileIFS compiles IFS ahead of the line loop, if it is a regexp, so
+// that a bad -F dies at once.
+func golfCompileIFS() {
+	if golfIsSplitRegexp(IFS) {
+		golfIFSRegexp.re = golfSplitRegexp(IFS)
+		golfIFSRegexp.sep = IFS
+	}
+}
+
+// golfSplitRegexp returns the /pat/ separator sep compiled, compiling it
+// only the first time it is used.
+func golfSplitRegexp(sep string) *regexp.Regexp {
+	if sep == golfIFSRegexp.sep {
+		return golfIFSRegexp.re
+	}
+	if v, ok := golfSplitREs.Load(sep); ok {
+		return v.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(sep[1 : len(sep)-1])
+	if err != nil {
+		Die("Invalid GSplit regexp separator (check -F flag): %v", err)
+	}
+	v, _ := golfSplitREs.LoadOrStore(sep, re)
+	return v.(*regexp.Regexp)
+}
+
+// golfFieldsN is strings.Fields, but stops splitting after n-1 fields if
+// n > 0, the last field being the rest of s, less trailing space.
+func golfFieldsN(s string, n int) []string {
+	var fields []string
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		i := strings.IndexFunc(s, unicode.IsSpace)
+		switch {
+		case s == "":
+			return fields
+		case len(fields) == n-1:
+			return append(fields, strings.TrimRightFunc(s, unicode.IsSpace))
+		case i < 0:
+			return append(fields, s)
+		}
+		fields, s = append(fields, s[:i]), s[i:]
+	}
+}
+
+// golfFieldsBytesN is golfFieldsN for byte slices.
+func golfFieldsBytesN(s []byte, n int) [][]byte {
+	var fields [][]byte
+	for {
+		s = bytes.TrimLeftFunc(s, unicode.IsSpace)
+		i := bytes.IndexFunc(s, unicode.IsSpace)
+		switch {
+		case len(s) == 0:
+			return fields
+		case len(fields) == n-1:
+			return append(fields, bytes.TrimRightFunc(s, unicode.IsSpace))
+		case i < 0:
+			return append(fields, s)
+		}
+		fields, s = append(fields, s[:i]), s[i:]
+	}
+}
+
+// golfSplitBytes is golfSplit for byte slices.
+func golfSplitBytes(dst [][]byte, sep string, input []byte, n int) [][]byte {
+	dst = dst[:0]
+	if n <= 0 {
+		n = -1
+	}
+	switch {
+	case sep == " ":
+		for i := 0; i < len(input); {
+			for i < len(input) && golfASCIISpace[input[i]] {
+				i++
+			}
+			if len(dst) == n-1 && i < len(input) {
+				return append(dst, bytes.TrimRightFunc(input[i:], unicode.IsSpace))
+			}
+			start := i
+			for ; i < len(input) && !golfASCIISpace[input[i]]; i++ {
+				if input[i] >= 0x80 {
+					return append(dst[:0], golfFieldsBytesN(input, n)...)
+				}
+			}
+			if i > start {
+				dst = append(dst, input[start:i])
+			}
+		}
+		return dst
+	case golfIsSplitRegexp(sep):
+		// There is no regexp.Split for []byte. This follows its semantics.
+		if len(input) == 0 {
+			return append(dst, input)
+		}
+		beg, end := 0, 0
+		for _, m := range golfSplitRegexp(sep).FindAllIndex(input, n) {
+			if len(dst) == n-1 {
+				break
+			}
+			end = m[0]
+			if m[1] != 0 {
+				dst = append(dst, input[beg:end])
+			}
+			beg = m[1]
+		}
+		if end != len(input) {
+			dst = append(dst, input[beg:])
+		}
+		return dst
+	case len(sep) == 1:
+		for {
+			i := bytes.IndexByte(input, sep[0])
+			if i < 0 || len(dst) == n-1 {
+				return append(dst, input)
+			}
+			dst = append(dst, input[:i])
+			input = input[i+1:]
+		}
+	}
+	return append(dst, bytes.SplitN(input, []byte(sep), n)...)
 }
 
 // Field retrieves a split field.
-// Index 0 returns the entire line re-joined using the OFS.
+// Index 0 returns the entire line re-joined using the OFS, or as CSV or TSV
+// with --csv or --tsv.
 // Positive values are taken to be a 1-based index to Fields.
 // Negative values index from the end (so -1 is the last Fields element).
-// Indexes out of range silently return the empty string.
+// Indexes out of range silently return DefaultField, by default the empty
+// string.
 func Field(n int) string {
+	return golfField(Fields, n)
+}
+
+// SetField sets field n, numbered like Field does, to v, and rebuilds Line
+// from Fields, joined with OFS, like assigning to $3 in awk. Setting a field
+// past the end of Fields adds empty fields up to it.
+//
+//	golf -ape 'SetField(2, strings.ToUpper(Field(2)))'
+func SetField(n int, v string) {
+	Fields, Line = golfSetField(Fields, n, v, LineEnding)
+}
+
+// golfSetField implements SetField on fields, returning them and the line
+// rebuilt from them, ending with ending unless in -l mode.
+func golfSetField(fields []string, n int, v string, ending string) ([]string, string) {
+	switch {
+	case n == 0:
+		Die("golf: SetField: can't set field 0")
+	case n < 0 && -n > len(fields):
+		Die("golf: SetField: no field %d in %d fields", n, len(fields))
+	case n < 0:
+		n = len(fields) + n
+	default:
+		n--
+		for len(fields) <= n {
+			fields = append(fields, "")
+		}
+	}
+	fields[n] = v
+	line := golfJoin(fields)
+	if !GolfFlgL {
+		line += ending
+	}
+	return fields, line
+}
+
+// golfJoin joins fields with OFS, or as golfFieldJoin says.
+func golfJoin(fields []string) string {
+	if golfFieldJoin != nil {
+		return golfFieldJoin(fields)
+	}
+	return strings.Join(fields, OFS)
+}
+
+// golfFieldJoin and golfDefaultLine, if set, replace joining fields with OFS,
+// and Line as what Print prints by default. They are only set with --csv
+// or --tsv; see csv.go and tsv.go.
+var (
+	golfFieldJoin   func(fields []string) string
+	golfDefaultLine func() string
+)
+
+// Fields and Line as split, for AutoJoin to tell whether the script changed
+// them.
+var (
+	golfSplitFields []string
+	golfSplitLine   string
+)
+
+// golfNoteSplit remembers Fields and Line after splitting, for AutoJoin.
+func golfNoteSplit() {
+	golfSplitFields = append(golfSplitFields[:0], Fields...)
+	golfSplitLine = Line
+}
+
+// golfAutoJoin returns line, or, if the script changed Fields but not Line,
+// Fields joined.
+func golfAutoJoin(line string) string {
+	if Line != golfSplitLine || golfSameFields(Fields, golfSplitFields) {
+		return line
+	}
+	s := golfJoin(Fields)
+	if !GolfFlgL {
+		s += LineEnding
+	}
+	return s
+}
+
+// golfSameFields reports whether a and b hold the same fields.
+func golfSameFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// golfField implements Field on fields.
+func golfField(fields []string, n int) string {
+	i := n
 	switch {
 	case n == 0:
-		return strings.Join(Fields, OFS)
+		return golfJoin(fields)
 	case n < 0:
-		n = len(Fields) + n
+		n = len(fields) + n
 	case n > 0:
 		n--
 	}
-	if n < 0 || n > len(Fields)-1 {
+	if n < 0 || n > len(fields)-1 {
 		if Warnings {
-			Warn("undefined field: %d: %v", n, Fields)
+			golfNoteWarning(fmt.Sprintf("undefined field %d", i), fmt.Sprint(fields))
 		}
-		return ""
+		return DefaultField
+	}
+	return fields[n]
+}
+
+// golfHeaderCols maps the column names in Header to their indexes.
+var golfHeaderCols map[string]int
+
+// golfSetHeader sets Header to a copy of fields, for -H.
+func golfSetHeader(fields []string) {
+	Header = append([]string(nil), fields...)
+	golfHeaderCols = make(map[string]int, len(Header))
+	for i := len(Header) - 1; i >= 0; i-- {
+		// The first of columns of the same name wins.
+		golfHeaderCols[Header[i]] = i
+	}
+}
+
+// Col returns the field in the column that Header names name, with -H. It
+// returns DefaultField if the record is short of that column, like Field,
+// and dies if the header has no such column.
+//
+//	golf -H --csv -lane 'Print(Col("email"))' users.csv
+func Col(name string) string {
+	return golfCol(Fields, name)
+}
+
+// golfCol implements Col on fields.
+func golfCol(fields []string, name string) string {
+	i, ok := golfHeaderCols[name]
+	if !ok {
+		Die("golf: %s: no column %q in header", Filename, name)
 	}
-	return Fields[n]
+	return golfField(fields, i+1)
 }
 
 // BackupName returns the filename used as a backup in in-place edit mode.
@@ -194,29 +1646,532 @@ func Field(n int) string {
 // Replacement rules follow Perl -i:
 //   - if ext contains no '*' characters, it is appended to orig as a suffix.
 //   - otherwise, each * is replaced with orig.
+//
+// As an extension, an ext ending in a slash names a directory, and is
+// treated as ext + "*".
 func BackupName(orig, ext string) string {
+	if strings.HasSuffix(ext, "/") || strings.HasSuffix(ext, string(filepath.Separator)) {
+		ext += "*"
+	}
 	if strings.Contains(ext, "*") {
 		return strings.ReplaceAll(ext, "*", orig)
 	}
 	return orig + ext
 }
 
+// golfInput is an input of line mode: a file, or a member of an archive.
+type golfInput struct {
+	Name string
+	R    io.Reader
+	File *os.File // The file, if the input is a whole file.
+	Size int64    // -1 if unknown.
+}
+
+// golfSwitches holds the switches given with golf -s.
+var golfSwitches = map[string]string{}
+
+// Opt returns the value of the switch -name=value given with golf -s, like
+// perl -s, or "1" for a plain -name, or def if there was none:
+//
+//	golf -s -lne 'if strings.Contains(Line, Opt("word", "ERROR")) { Print(Line) }' -word=WARN FILE
+func Opt(name, def string) string {
+	if v, ok := golfSwitches[name]; ok {
+		return v
+	}
+	return def
+}
+
+// golfSplitArgs sets ARGV and ARGS from os.Args, where golf passes the
+// script arguments after the input files and a "--". If there is one,
+// os.Args is left with the script arguments only.
+func golfSplitArgs() {
+	ARGV, ARGS = os.Args[1:], nil
+	for i, arg := range ARGV {
+		if arg == "--" {
+			ARGV, ARGS = ARGV[:i:i], ARGV[i+1:]
+			os.Args = append(os.Args[:1:1], ARGS...)
+			break
+		}
+	}
+}
+
+// golfInputs iterates over the inputs of line mode, named by ARGV, taking
+// each name off it as it is opened. If archives is set, tar and zip archives
+// stand for their members.
+type golfInputs struct {
+	archives bool
+	member   func() (golfInput, bool) // Next member of the current archive.
+	closeCur func()                   // Closes the current file or archive.
+	// accept opens the next connection instead of the next of ARGV, for
+	// --listen; see listen.go.
+	accept func() (golfInput, func(), bool)
+}
+
+// next opens the next input. The previous one is closed.
+func (in *golfInputs) next() (golfInput, bool) {
+	for {
+		if in.member != nil {
+			if m, ok := in.member(); ok {
+				return m, true
+			}
+			in.member = nil
+		}
+		if in.closeCur != nil {
+			in.closeCur()
+			in.closeCur = nil
+		}
+		if in.accept != nil {
+			c, closeC, ok := in.accept()
+			in.closeCur = closeC
+			return c, ok
+		}
+		if len(ARGV) == 0 {
+			return golfInput{}, false
+		}
+		name := ARGV[0]
+		ARGV = ARGV[1:]
+		if name == "-" {
+			if GolfInPlace {
+				Die("golf: can't edit stdin in place")
+			}
+			return golfInput{Name: name, R: os.Stdin, Size: -1}, true
+		}
+		if fd, ok := golfFD(name); ok {
+			if GolfInPlace {
+				Die("golf: can't edit %s in place", name)
+			}
+			f := os.NewFile(uintptr(fd), name)
+			in.closeCur = func() { f.Close() }
+			return golfInput{Name: name, R: f, Size: -1}, true
+		}
+		if golfOpenURL != nil && golfIsURL(name) {
+			var u golfInput
+			u, in.closeCur = golfOpenURL(name)
+			return u, true
+		}
+		if in.archives && golfIsArchive(name) {
+			in.member, in.closeCur = golfOpenArchive(name)
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			Die(err)
+		}
+		in.closeCur = func() { f.Close() }
+		size := int64(-1)
+		if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+			size = st.Size()
+		}
+		if golfFollow != nil && len(ARGV) == 0 && size >= 0 {
+			// The last file, for -tail.
+			var r io.Reader
+			r, in.closeCur = golfFollow(f, name)
+			return golfInput{Name: name, R: r, Size: -1}, true
+		}
+		return golfInput{Name: name, R: f, File: f, Size: size}, true
+	}
+}
+
+// golfOpenURL opens an http or https URL named as an input, and returns a
+// function to close it. It is only set when some input is a URL; see url.go.
+var golfOpenURL func(url string) (golfInput, func())
+
+// golfFollow returns a reader that follows the regular file f, named name,
+// as it grows, and a function to close it. It is only set with -tail; see
+// tail.go.
+var golfFollow func(f *os.File, name string) (io.Reader, func())
+
+// golfIsURL reports whether name is an http or https URL.
+func golfIsURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// golfFD returns the file descriptor N that an input named fd:N refers to.
+func golfFD(name string) (int, bool) {
+	if !strings.HasPrefix(name, "fd:") {
+		return 0, false
+	}
+	fd, err := strconv.Atoi(name[len("fd:"):])
+	return fd, err == nil && fd >= 0
+}
+
+// golfIsArchive reports whether name looks like an archive that golf can
+// iterate over.
+func golfIsArchive(name string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// golfOpenArchive opens the archive name, and returns an iterator over its
+// regular files, which are named name::path, and a function to close it.
+func golfOpenArchive(name string) (func() (golfInput, bool), func()) {
+	if strings.HasSuffix(name, ".zip") {
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			Die("golf: %s: %v", name, err)
+		}
+		files, prev := zr.File, io.ReadCloser(nil)
+		member := func() (golfInput, bool) {
+			if prev != nil {
+				prev.Close()
+				prev = nil
+			}
+			for len(files) > 0 {
+				zf := files[0]
+				files = files[1:]
+				if !zf.Mode().IsRegular() {
+					continue
+				}
+				rc, err := zf.Open()
+				if err != nil {
+					Die("golf: %s::%s: %v", name, zf.Name, err)
+				}
+				prev = rc
+				return golfInput{Name: name + "::" + zf.Name, R: rc, Size: int64(zf.UncompressedSize64)}, true
+			}
+			return golfInput{}, false
+		}
+		return member, func() { zr.Close() }
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		Die(err)
+	}
+	var r io.Reader = f
+	if !strings.HasSuffix(name, ".tar") {
+		if r, err = gzip.NewReader(f); err != nil {
+			Die("golf: %s: %v", name, err)
+		}
+	}
+	tr := tar.NewReader(r)
+	member := func() (golfInput, bool) {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return golfInput{}, false
+			}
+			if err != nil {
+				Die("golf: %s: %v", name, err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				return golfInput{Name: name + "::" + hdr.Name, R: tr, Size: hdr.Size}, true
+			}
+		}
+	}
+	return member, func() { f.Close() }
+}
+
+// golfLineLen returns the length of the first line in b, terminator
+// included, or 0 if b doesn't hold a whole line. Lines end with "\n", "\r\n",
+// or a lone "\r" as in old Mac files, so files with mixed endings split as
+// they look. At EOF, the end of b ends a line too.
+func golfLineLen(b []byte, eof bool) int {
+	nl := bytes.IndexByte(b, '\n')
+	head := b
+	if nl >= 0 {
+		head = b[:nl]
+	}
+	if cr := bytes.IndexByte(head, '\r'); cr >= 0 && cr+1 < len(head) {
+		return cr + 1
+	}
+	switch {
+	case nl >= 0:
+		return nl + 1
+	case eof:
+		return len(b)
+	}
+	// Without a "\n", even a final "\r" may be the start of "\r\n".
+	return 0
+}
+
+// golfReadLine reads a line, terminator included. Lines that fit in r's
+// buffer are returned without copying, so the result is only valid until the
+// next read. There is no limit on line length unless max > 0, in which case
+// lines longer than max bytes (not counting the terminator) are an error.
+func golfReadLine(r *bufio.Reader, max int) ([]byte, error) {
+	tooLong := func(b []byte) bool {
+		return max > 0 && len(bytes.TrimRight(b, "\r\n")) > max
+	}
+	var long []byte // The start of a line longer than r's buffer.
+	for {
+		// Look at what's buffered first, so that a line already read from a
+		// terminal or pipe doesn't wait for the next one.
+		buf, _ := r.Peek(r.Buffered())
+		n := golfLineLen(buf, false)
+		var err error
+		for n == 0 && err == nil {
+			buf, err = r.Peek(len(buf) + 1)
+			n = golfLineLen(buf, err != nil && err != bufio.ErrBufferFull)
+		}
+		if n == 0 && err == bufio.ErrBufferFull {
+			// Keep a final "\r" buffered, in case a "\n" follows.
+			n = len(buf)
+			if buf[n-1] == '\r' {
+				n--
+			}
+			long = append(long, buf[:n]...)
+			r.Discard(n)
+			// Don't keep buffering a line we already know is too long.
+			if tooLong(long) {
+				break
+			}
+			continue
+		}
+		line := buf[:n]
+		r.Discard(n)
+		if n < len(buf) || err == bufio.ErrBufferFull {
+			err = nil
+		}
+		if long != nil {
+			long = append(long, line...)
+			line = long
+		}
+		if tooLong(line) {
+			break
+		}
+		return line, err
+	}
+	return nil, fmt.Errorf("line longer than -maxline %d bytes", max)
+}
+
+// golfLineEnding returns the terminator of a line read by golfReadLine.
+func golfLineEnding(line []byte) string {
+	switch {
+	case bytes.HasSuffix(line, []byte("\r\n")):
+		return "\r\n"
+	case bytes.HasSuffix(line, []byte("\n")):
+		return "\n"
+	case bytes.HasSuffix(line, []byte("\r")):
+		return "\r"
+	}
+	return ""
+}
+
+// golfEof reports whether the current input has no more lines. The
+// generated program sets it for each input file.
+var golfEof = func() bool { return true }
+
+// Eof reports whether the current line is the last one of the current input
+// file, in line mode, so that the last line can be told apart without
+// buffering one by hand:
+//
+//	golf -ne 'if Eof() { Print(Line) }' FILE  # tail -1
+//
+// It isn't available with -Pmap or --source.
+func Eof() bool {
+	return golfEof()
+}
+
+// golfNoEof is golfEof where the end of input can't be told in advance.
+func golfNoEof() bool {
+	Die("golf: Eof isn't available with -Pmap or --source")
+	return false
+}
+
+// golfReaderEof returns a golfEof for input read through *r.
+func golfReaderEof(r **bufio.Reader) func() bool {
+	return func() bool {
+		_, err := (*r).Peek(1)
+		return err != nil
+	}
+}
+
+// golfPatternREs caches the compiled regexps of -e '/regexp/ { ... }'
+// snippets.
+var golfPatternREs sync.Map
+
+// golfPatternRE returns re compiled, for a /regexp/ pattern.
+func golfPatternRE(re string) *regexp.Regexp {
+	if v, ok := golfPatternREs.Load(re); ok {
+		return v.(*regexp.Regexp)
+	}
+	v, _ := golfPatternREs.LoadOrStore(re, regexp.MustCompile(re))
+	return v.(*regexp.Regexp)
+}
+
 // golf:prelude end
 
-//go:embed prelude.go
-var golflibsrc []byte
+var (
+	//go:embed prelude.go
+	golflibsrc []byte
+	//go:embed mmap_unix.go
+	golfmmapsrc []byte
+	//go:embed accesslog.go
+	golfaccesslogsrc []byte
+	//go:embed url.go
+	golfurlsrc []byte
+	//go:embed since.go
+	golfsincesrc []byte
+	//go:embed source.go
+	golfsourcesrc []byte
+	//go:embed pmap.go
+	golfpmapsrc []byte
+	//go:embed owner_unix.go
+	golfownersrc []byte
+	//go:embed diff.go
+	golfdiffsrc []byte
+	//go:embed tail.go
+	golftailsrc []byte
+	//go:embed listen.go
+	golflistensrc []byte
+	//go:embed csv.go
+	golfcsvsrc []byte
+	//go:embed json.go
+	golfjsonsrc []byte
+	//go:embed tsv.go
+	golftsvsrc []byte
+	//go:embed syslog.go
+	golfsyslogsrc []byte
+	//go:embed xml.go
+	golfxmlsrc []byte
+	//go:embed yaml.go
+	golfyamlsrc []byte
+	//go:embed time.go
+	golftimesrc []byte
+	//go:embed kv.go
+	golfkvsrc []byte
+	//go:embed flipflop.go
+	golfflipflopsrc []byte
+	//go:embed sketch.go
+	golfsketchsrc []byte
+	//go:embed multimatch.go
+	golfmultimatchsrc []byte
+	//go:embed lines.go
+	golflinessrc []byte
+	//go:embed columns.go
+	golfcolumnssrc []byte
+	//go:embed format.go
+	golfformatsrc []byte
+	//go:embed hotspots.go
+	golfhotspotssrc []byte
+	//go:embed matchfile.go
+	golfmatchfilesrc []byte
+)
 
 // Source returns the source code of the prelude.
 func Source() []byte {
+	return section("prelude.go", golflibsrc)
+}
+
+// A feature is an optional part of the prelude, kept in a file of its own,
+// which is only embedded in the generated program when it is needed. The
+// prelude proper is always embedded, and features may use it, and the
+// features they list in deps, but not others.
+type feature struct {
+	file    string
+	src     []byte
+	imports []string // Packages the feature needs beyond the prelude's own.
+	deps    []string // Features the feature uses.
+}
+
+var features = map[string]feature{
+	"accesslog":  {"accesslog.go", golfaccesslogsrc, nil, nil},
+	"columns":    {"columns.go", golfcolumnssrc, nil, nil},
+	"csv":        {"csv.go", golfcsvsrc, []string{"encoding/csv", "unicode/utf8"}, nil},
+	"diff":       {"diff.go", golfdiffsrc, nil, nil},
+	"flipflop":   {"flipflop.go", golfflipflopsrc, []string{"runtime"}, nil},
+	"format":     {"format.go", golfformatsrc, nil, nil},
+	"hotspots":   {"hotspots.go", golfhotspotssrc, nil, nil},
+	"json":       {"json.go", golfjsonsrc, []string{"encoding/json", "reflect"}, nil},
+	"kv":         {"kv.go", golfkvsrc, nil, nil},
+	"lines":      {"lines.go", golflinessrc, nil, nil},
+	"listen":     {"listen.go", golflistensrc, []string{"net"}, nil},
+	"matchfile":  {"matchfile.go", golfmatchfilesrc, nil, []string{"multimatch"}},
+	"mmap":       {"mmap_unix.go", golfmmapsrc, nil, nil},
+	"multimatch": {"multimatch.go", golfmultimatchsrc, nil, nil},
+	"owner":      {"owner_unix.go", golfownersrc, nil, nil},
+	"pmap":       {"pmap.go", golfpmapsrc, nil, nil},
+	"since":      {"since.go", golfsincesrc, []string{"encoding/json", "hash/fnv"}, nil},
+	"sketch":     {"sketch.go", golfsketchsrc, []string{"math/bits"}, nil},
+	"source":     {"source.go", golfsourcesrc, []string{"encoding/json"}, nil},
+	"syslog":     {"syslog.go", golfsyslogsrc, nil, []string{"time"}},
+	"tail":       {"tail.go", golftailsrc, nil, nil},
+	"time":       {"time.go", golftimesrc, nil, nil},
+	"tsv":        {"tsv.go", golftsvsrc, nil, nil},
+	"url":        {"url.go", golfurlsrc, []string{"net/http"}, nil},
+	"xml":        {"xml.go", golfxmlsrc, []string{"encoding/xml"}, []string{"source"}},
+	"yaml":       {"yaml.go", golfyamlsrc, []string{"encoding/json", "reflect", "gopkg.in/yaml.v3"}, []string{"json", "source"}},
+}
+
+// Features returns the names of the optional parts of the prelude.
+func Features() []string {
+	var names []string
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FeatureSource returns the source code of the named optional part of the
+// prelude, and the packages it needs imported beyond the prelude's own.
+func FeatureSource(name string) ([]byte, []string, error) {
+	f, ok := features[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown prelude feature %q; have %s", name, strings.Join(Features(), ", "))
+	}
+	return section(f.file, f.src), f.imports, nil
+}
+
+// ResolveFeatures returns the named optional parts of the prelude together
+// with the ones they use, in turn, sorted and without duplicates.
+func ResolveFeatures(names []string) ([]string, error) {
+	seen := map[string]bool{}
+	var add func(name string) error
+	add = func(name string) error {
+		if seen[name] {
+			return nil
+		}
+		f, ok := features[name]
+		if !ok {
+			return fmt.Errorf("unknown prelude feature %q; have %s", name, strings.Join(Features(), ", "))
+		}
+		seen[name] = true
+		for _, d := range f.deps {
+			if err := add(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var res []string
+	for _, name := range names {
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+	for name := range seen {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// InputFD returns the file descriptor N that an input named fd:N refers to.
+func InputFD(name string) (int, bool) {
+	return golfFD(name)
+}
+
+// IsURL reports whether an input name is a URL, which golf fetches.
+func IsURL(name string) bool {
+	return golfIsURL(name)
+}
+
+// section returns the part of src between the golf:prelude markers.
+func section(name string, src []byte) []byte {
 	var (
-		start = bytes.Index(golflibsrc, []byte("// golf:prelude start\n"))
-		end   = bytes.Index(golflibsrc, []byte("// golf:prelude end\n"))
+		start = bytes.Index(src, []byte("// golf:prelude start\n"))
+		end   = bytes.Index(src, []byte("// golf:prelude end\n"))
 	)
 	if start < 0 {
-		Die("prelude.go missing start marker")
+		Die("%s missing start marker", name)
 	}
 	if end < 0 {
-		return golflibsrc[start:]
+		return src[start:]
 	}
-	return golflibsrc[start:end]
+	return src[start:end:end]
 }
@@ -1,9 +1,21 @@
 package prelude
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestField(t *testing.T) {
@@ -35,3 +47,986 @@ func TestField(t *testing.T) {
 		}
 	}
 }
+
+func TestSetField(t *testing.T) {
+	defer func() { GolfFlgL, LineEnding = false, "" }()
+	for _, d := range []struct {
+		in       []string
+		n        int
+		flgL     bool
+		wantLine string
+	}{
+		{[]string{"a", "b", "c"}, 2, true, "a x c"},
+		{[]string{"a", "b", "c"}, -1, true, "a b x"},
+		{[]string{"a"}, 3, true, "a  x"},
+		{[]string{"a", "b"}, 1, false, "x b\n"},
+	} {
+		Fields, GolfFlgL, LineEnding = append([]string(nil), d.in...), d.flgL, "\n"
+		SetField(d.n, "x")
+		if Line != d.wantLine {
+			t.Errorf("Fields = %q, SetField(%d, \"x\"): Line = %q, want %q", d.in, d.n, Line, d.wantLine)
+		}
+	}
+}
+
+func TestAutoJoin(t *testing.T) {
+	defer func() { GolfFlgL, Line, Fields = false, "", nil }()
+	GolfFlgL = true
+	for _, d := range []struct {
+		edit func()
+		want string
+	}{
+		{func() {}, "a  b"},
+		{func() { Fields[1] = "c" }, "a c"},
+		{func() { Fields = append(Fields, "c") }, "a b c"},
+		{func() { Fields[1] = "c"; Line = "x" }, "x"},
+	} {
+		Line, Fields = "a  b", []string{"a", "b"}
+		golfNoteSplit()
+		d.edit()
+		if got := golfAutoJoin(Line); got != d.want {
+			t.Errorf("golfAutoJoin = %q, want %q", got, d.want)
+		}
+	}
+}
+
+func TestJQ(t *testing.T) {
+	defer func() { Line = "" }()
+	Line = `{"a": {"b": [1, {"c": "x"}]}, "n": 2.50}` + "\n"
+	golfParseJSON()
+	for _, d := range []struct {
+		path, want string
+	}{
+		{"a.b[1].c", "x"},
+		{"a.b[-2]", "1"},
+		{"n", "2.50"},
+		{"a.b", `[1,{"c":"x"}]`},
+		{"a.b[2]", ""},
+		{"a.x.y", ""},
+		{"n[0]", ""},
+	} {
+		if got := JQS(d.path); got != d.want {
+			t.Errorf("JQS(%q) = %q, want %q", d.path, got, d.want)
+		}
+	}
+	if J["n"] == nil {
+		t.Errorf("J = %v, want a map with n", J)
+	}
+	Line = "not json"
+	golfParseJSON()
+	if J != nil || JQ("") != nil {
+		t.Errorf("not JSON: J = %v, JQ(\"\") = %v, want nil", J, JQ(""))
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	v := map[string]interface{}{"a": []int{1, 2}, "b": "<&>"}
+	if got, want := ToJSON(v), `{"a":[1,2],"b":"<&>"}`; got != want {
+		t.Errorf("ToJSON(%v) = %q, want %q", v, got, want)
+	}
+	if got, want := ToJSONPretty(v), "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": \"<&>\"\n}"; got != want {
+		t.Errorf("ToJSONPretty(%v) = %q, want %q", v, got, want)
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	var ev struct {
+		User string
+		Ms   int
+		Tags []interface{}
+	}
+	FromJSON(`{"user": "ann", "ms": 12, "tags": [1.50]}`, &ev)
+	if ev.User != "ann" || ev.Ms != 12 || len(ev.Tags) != 1 || ev.Tags[0] != json.Number("1.50") {
+		t.Errorf("FromJSON: got %+v", ev)
+	}
+	if m := FromJSONMap(`{"a": [1]}`); m == nil || m["a"] == nil {
+		t.Errorf("FromJSONMap = %v, want a map with a", m)
+	}
+
+	defer func(w bool) { Warnings, golfWarnings = w, map[string]*WarningCount{} }(Warnings)
+	Warnings = true
+	for _, s := range []string{`{"user": "bob", "ms": "x"}`, `{"user": "bob"} {}`, `[`} {
+		FromJSON(s, &ev)
+		if ev.User != "" || ev.Ms != 0 || ev.Tags != nil {
+			t.Errorf("FromJSON(%q) under -w: got %+v, want the zero value", s, ev)
+		}
+		ev.User = "x"
+	}
+	if w := golfWarnings["FromJSON: invalid JSON"]; w == nil || w.Count != 3 {
+		t.Errorf("warnings = %v, want 3 FromJSON ones", WarningReport())
+	}
+}
+
+func TestYAML(t *testing.T) {
+	src := &golfYAMLSource{}
+	src.Open("-", strings.NewReader("a: 1\nb: [x, 2.50]\n---\n1: true\n"))
+	var got []string
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, string(rec))
+	}
+	if want := []string{`{"a":1,"b":["x",2.5]}`, `{"1":true}`}; !cmp.Equal(got, want) {
+		t.Errorf("golfYAMLSource records = %q, want %q", got, want)
+	}
+
+	v := map[string]interface{}{"c": json.Number("3"), "f": []interface{}{json.Number("2.50")}, "s": "3"}
+	if got, want := ToYAML(v), "c: 3\nf:\n  - 2.50\ns: \"3\""; got != want {
+		t.Errorf("ToYAML(%v) = %q, want %q", v, got, want)
+	}
+	var d struct {
+		Kind     string
+		Metadata struct{ Name string }
+	}
+	FromYAML("kind: Pod\nmetadata:\n  name: web\n", &d)
+	if d.Kind != "Pod" || d.Metadata.Name != "web" {
+		t.Errorf("FromYAML: got %+v", d)
+	}
+}
+
+func TestYAMLKeepsDoc(t *testing.T) {
+	defer func() { golfYAMLDoc, J, golfJValue, Line = nil, nil, nil, "" }()
+	src := &golfYAMLSource{}
+	src.Open("-", strings.NewReader("# web\nkind: Deployment # kind\nmetadata:\n  name: web\n  labels: {app: web}\nspec:\n  replicas: 1\n"))
+	rec, err := src.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	Line = string(rec)
+	golfParseJSON()
+	J["spec"].(map[string]interface{})["replicas"] = json.Number("3")
+	delete(J["metadata"].(map[string]interface{}), "labels")
+	J["apiVersion"] = "apps/v1"
+	want := "# web\nkind: Deployment # kind\nmetadata:\n  name: web\nspec:\n  replicas: 3\napiVersion: apps/v1"
+	if got := ToYAML(J); got != want {
+		t.Errorf("ToYAML(J) = %q, want %q", got, want)
+	}
+	// Other maps are written afresh, with sorted keys.
+	if got, want := ToYAML(map[string]interface{}{"kind": "Pod", "a": "b"}), "a: b\nkind: Pod"; got != want {
+		t.Errorf("ToYAML(map) = %q, want %q", got, want)
+	}
+}
+
+func TestXMLSource(t *testing.T) {
+	defer func() { X = nil }()
+	src := &golfXMLSource{elem: "item"}
+	src.Open("-", strings.NewReader(`<?xml version="1.0"?>
+<feed>
+  <item id="1"><t>A &amp; B</t><tag>x</tag><tag>y</tag></item>
+  <x:item xmlns:x="urn:x">leaf</x:item>
+</feed>`))
+	for _, want := range []struct {
+		raw string
+		x   map[string]interface{}
+	}{
+		{`<item id="1"><t>A &amp; B</t><tag>x</tag><tag>y</tag></item>`,
+			map[string]interface{}{"@id": "1", "t": "A & B", "tag": []interface{}{"x", "y"}}},
+		{`<x:item xmlns:x="urn:x">leaf</x:item>`,
+			map[string]interface{}{"#text": "leaf"}},
+	} {
+		rec, err := src.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if string(rec) != want.raw || !cmp.Equal(X, want.x) {
+			t.Errorf("Next = %q, X = %v; want %q, %v", rec, X, want.raw, want.x)
+		}
+	}
+	if rec, err := src.Next(); err != io.EOF {
+		t.Errorf("Next = %q, %v; want io.EOF", rec, err)
+	}
+}
+
+func TestKV(t *testing.T) {
+	defer func() { Line = "" }()
+	for _, d := range []struct {
+		in   string
+		want map[string]string
+	}{
+		{`level=info msg="user \"ann\" created" id=7` + "\n",
+			map[string]string{"level": "info", "msg": `user "ann" created`, "id": "7"}},
+		{`a= b c=1=2 "junk" =x d="open`,
+			map[string]string{"a": "", "b": "", "c": "1=2", "d": "open"}},
+		{"", map[string]string{}},
+	} {
+		if got := KV(d.in); !cmp.Equal(got, d.want) {
+			t.Errorf("KV(%q) = %v, want %v", d.in, got, d.want)
+		}
+		Line = d.in
+		if got := KV(); !cmp.Equal(got, d.want) {
+			t.Errorf("Line = %q, KV() = %v, want %v", d.in, got, d.want)
+		}
+	}
+}
+
+func TestParseAccessLog(t *testing.T) {
+	for _, d := range []struct {
+		line string
+		want *AccessLog
+	}{
+		{`203.0.113.9 - ann [10/Oct/2000:13:55:36 -0700] "GET /a?b=1 HTTP/1.1" 200 2326 "http://ex.com/" "curl/8.0 \"x\""` + "\n",
+			&AccessLog{IP: "203.0.113.9", Ident: "-", User: "ann", Time: time.Date(2000, 10, 10, 20, 55, 36, 0, time.UTC),
+				Method: "GET", Path: "/a?b=1", Proto: "HTTP/1.1", Status: 200, Bytes: 2326,
+				Referer: "http://ex.com/", UserAgent: `curl/8.0 \"x\"`}},
+		{`::1 - - [10/Oct/2000:13:55:36 +0000] "\x16\x03" 400 -`,
+			&AccessLog{IP: "::1", Ident: "-", User: "-", Time: time.Date(2000, 10, 10, 13, 55, 36, 0, time.UTC),
+				Path: `\x16\x03`, Status: 400}},
+		{`not a log line`, nil},
+		{`::1 - - [yesterday] "GET / HTTP/1.0" 200 1`, nil},
+	} {
+		got := ParseAccessLog(d.line)
+		if !cmp.Equal(got, d.want, cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })) {
+			t.Errorf("ParseAccessLog(%q) = %+v, want %+v", d.line, got, d.want)
+		}
+	}
+}
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Now()
+	for _, d := range []struct {
+		line string
+		want *Syslog
+	}{
+		{"<34>1 2003-10-11T22:14:15.003Z mymachine su - ID47 [a x=\"]\\]\"][b] \ufeff'su root' failed\n",
+			&Syslog{Priority: 34, Facility: 4, Severity: 2, Time: time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC),
+				Host: "mymachine", Tag: "su", MsgID: "ID47", Data: `[a x="]\]"][b]`, Msg: "'su root' failed"}},
+		{"<165>1 - - - - - -",
+			&Syslog{Priority: 165, Facility: 20, Severity: 5}},
+		{"2024-01-02T03:04:05.5+01:00 web nginx[12]: started\n",
+			&Syslog{Priority: -1, Facility: -1, Severity: -1, Time: time.Date(2024, 1, 2, 2, 4, 5, 5e8, time.UTC),
+				Host: "web", Tag: "nginx", PID: "12", Msg: "started"}},
+		{"<13>" + now.Format(time.Stamp) + " box kernel: usb 1-1: new device",
+			&Syslog{Priority: 13, Facility: 1, Severity: 5, Time: now.Truncate(time.Second),
+				Host: "box", Tag: "kernel", Msg: "usb 1-1: new device"}},
+		{now.Format(time.Stamp) + " box -- MARK --",
+			&Syslog{Priority: -1, Facility: -1, Severity: -1, Time: now.Truncate(time.Second),
+				Host: "box", Msg: "-- MARK --"}},
+		{"not syslog", nil},
+		{"<200>1 - - - - - -", nil},
+		{"<34>1 2003-10-11T22:14:15Z h a p m [unclosed", nil},
+	} {
+		got := ParseSyslog(d.line)
+		if !cmp.Equal(got, d.want, cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })) {
+			t.Errorf("ParseSyslog(%q) = %+v, want %+v", d.line, got, d.want)
+		}
+	}
+}
+
+func TestCol(t *testing.T) {
+	defer golfSetHeader(nil)
+	golfSetHeader([]string{"id", "name", "id"})
+	for _, d := range []struct {
+		in   []string
+		name string
+		want string
+	}{
+		{[]string{"1", "a", "2"}, "id", "1"},
+		{[]string{"1", "a", "2"}, "name", "a"},
+		{[]string{"1"}, "name", ""},
+	} {
+		Fields = d.in
+		if have := Col(d.name); have != d.want {
+			t.Errorf("Fields = %q, Col(%q) = %q, want %q", d.in, d.name, have, d.want)
+		}
+	}
+}
+
+func TestMultiMatcher(t *testing.T) {
+	m := MultiMatcher([]string{"he", "she", "his", "hers", "", "she"})
+	for _, d := range []struct {
+		in    string
+		match bool
+		all   []string
+	}{
+		{"", false, nil},
+		{"xyz", false, nil},
+		{"ushers", true, []string{"she", "he", "hers"}},
+		{"this", true, []string{"his"}},
+		{"hishe", true, []string{"his", "she", "he"}},
+	} {
+		if have := m.Match(d.in); have != d.match {
+			t.Errorf("Match(%q) = %v, want %v", d.in, have, d.match)
+		}
+		if diff := cmp.Diff(d.all, m.FindAll(d.in)); diff != "" {
+			t.Errorf("FindAll(%q) diff(-want,+got):\n%s", d.in, diff)
+		}
+	}
+}
+
+func TestGolfReadLine(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	for _, d := range []struct {
+		in      string
+		max     int
+		want    []string
+		wantErr bool
+	}{
+		{"a\nb\r\nc", 0, []string{"a\n", "b\r\n", "c"}, false},
+		{long + "\n" + long, 0, []string{long + "\n", long}, false},
+		{"abc\r\nde\n", 3, []string{"abc\r\n", "de\n"}, false},
+		{"abc\nabcd\n", 3, []string{"abc\n"}, true},
+		{"ok\n" + long + "\n", 4096, []string{"ok\n"}, true},
+		{"mac\rmixed\r\nunix\n\r", 0, []string{"mac\r", "mixed\r\n", "unix\n", "\r"}, false},
+		// "\r\n" split across buffer fills.
+		{"123456789012345\r\nx\r", 0, []string{"123456789012345\r\n", "x\r"}, false},
+		{long + "\r" + long + "\r\n", 0, []string{long + "\r", long + "\r\n"}, false},
+	} {
+		// Small buffer so that lines span several ReadSlice calls.
+		r := bufio.NewReaderSize(strings.NewReader(d.in), 16)
+		var have []string
+		var err error
+		for {
+			var line []byte
+			if line, err = golfReadLine(r, d.max); err != nil && err != io.EOF {
+				break
+			}
+			if len(line) == 0 {
+				err = nil
+				break
+			}
+			have = append(have, string(line))
+		}
+		if (err != nil) != d.wantErr {
+			t.Errorf("golfReadLine(%.10q, %d): err = %v, want error %v", d.in, d.max, err, d.wantErr)
+		}
+		if diff := cmp.Diff(d.want, have); diff != "" {
+			t.Errorf("golfReadLine(%.10q, %d) diff(-want,+got):\n%s", d.in, d.max, diff)
+		}
+	}
+}
+
+func TestGolfIsBinaryPipe(t *testing.T) {
+	// A pipe whose writer has only written part of a block, and is still
+	// open, must not hold up the check.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("text\x00"))
+	done := make(chan bool)
+	go func() { done <- golfIsBinary(bufio.NewReader(pr)) }()
+	select {
+	case binary := <-done:
+		if !binary {
+			t.Error("golfIsBinary = false, want true")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("golfIsBinary blocked on a partly written pipe")
+	}
+}
+
+func TestCSVRecord(t *testing.T) {
+	// A small buffer, so that reading on overwrites the first line.
+	r := bufio.NewReaderSize(strings.NewReader("a,\"b\nc\"\"\n\"\nd\n"), 16)
+	var have []string
+	for {
+		line, err := golfReadLine(r, 0)
+		if line, err = golfCSVRecord(r, 0, line, err); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if len(line) == 0 {
+			break
+		}
+		have = append(have, string(line))
+	}
+	if diff := cmp.Diff([]string{"a,\"b\nc\"\"\n\"\n", "d\n"}, have); diff != "" {
+		t.Errorf("golfCSVRecord diff(-want,+got):\n%s", diff)
+	}
+}
+
+func TestBloom(t *testing.T) {
+	const n = 10000
+	b := Bloom(n, 0.01)
+	for i := 0; i < n; i++ {
+		if b.Add(strconv.Itoa(i)) && i < 100 {
+			// A few early false positives are possible but unlikely.
+			t.Logf("Add(%d) reported already present", i)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if !b.Has(strconv.Itoa(i)) {
+			t.Fatalf("Has(%d) = false after Add", i)
+		}
+	}
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if b.Has(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / n; rate > 0.02 {
+		t.Errorf("false positive rate %v, want about 0.01", rate)
+	}
+}
+
+func TestGSplitBytes(t *testing.T) {
+	for _, d := range []struct {
+		sep, in string
+		n       int
+	}{
+		{" ", "  a b\tc  ", 0},
+		{":", "a::b:", 0},
+		{"/,\\s*/", "a, b,c,", 0},
+		{"/x*/", "abc", 0},
+		{"/,/", "", 0},
+		{" ", "  a b\tc  ", 2},
+		{" ", " a\u00a0b c ", 2},
+		{":", "a::b:", 3},
+		{"::", "a::b::c", 2},
+		{"/,\\s*/", "a, b,c,", 2},
+	} {
+		want := GSplitN(d.sep, d.in, d.n)
+		var have []string
+		for _, f := range golfSplitBytes(nil, d.sep, []byte(d.in), d.n) {
+			have = append(have, string(f))
+		}
+		if len(want) == 0 {
+			want = nil
+		}
+		if diff := cmp.Diff(want, have); diff != "" {
+			t.Errorf("golfSplitBytes(%q, %q, %d) diff(-GSplitN,+golfSplitBytes):\n%s", d.sep, d.in, d.n, diff)
+		}
+	}
+}
+
+func TestTopK(t *testing.T) {
+	tk := TopK(10)
+	// a:50 b:30 c:20, plus 40 singletons.
+	for i := 0; i < 50; i++ {
+		tk.Add("a")
+		if i < 30 {
+			tk.Add("b")
+		}
+		if i < 20 {
+			tk.Add("c")
+		}
+		if i < 40 {
+			tk.Add("x" + strconv.Itoa(i))
+		}
+	}
+	top := tk.Top()
+	if len(top) != 10 {
+		t.Fatalf("Top() = %v, want 10 items", top)
+	}
+	// Anything seen more than N/k = 14 times must be reported.
+	if top[0].Key != "a" || top[1].Key != "b" || top[2].Key != "c" {
+		t.Errorf("Top() = %v, want a, b, c first", top)
+	}
+	for _, it := range top {
+		if it.Count-it.Err > 50 || it.Count < it.Err {
+			t.Errorf("inconsistent item %+v", it)
+		}
+	}
+}
+
+func TestHLL(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 10000, 200000} {
+		h := HLL()
+		for i := 0; i < n; i++ {
+			h.Add(strconv.Itoa(i))
+			h.Add(strconv.Itoa(i)) // duplicates don't count.
+		}
+		have := h.Count()
+		if diff := have - n; diff*diff > (n/30+1)*(n/30+1) {
+			t.Errorf("HLL with %d distinct: Count() = %d", n, have)
+		}
+	}
+}
+
+func TestGolfSplit(t *testing.T) {
+	var dst []string
+	for _, d := range []struct {
+		sep, in string
+		n       int
+		want    []string
+	}{
+		{" ", "", 0, nil},
+		{" ", "   ", 0, nil},
+		{" ", "  a b\t\tc \n", 0, []string{"a", "b", "c"}},
+		{" ", "a\u00a0b\u2003c d", 0, []string{"a", "b", "c", "d"}},
+		{" ", "héllo wörld", 0, []string{"héllo", "wörld"}},
+		{":", "", 0, []string{""}},
+		{":", "a::b:", 0, []string{"a", "", "b", ""}},
+		{"::", "a::b:::c", 0, []string{"a", "b", ":c"}},
+		{"", "abc", 0, []string{"a", "b", "c"}},
+		{"/:+/", "a::b:c", 0, []string{"a", "b", "c"}},
+		{" ", "  a b\t\tc \n", 2, []string{"a", "b\t\tc"}},
+		{" ", "a\u00a0b\u2003c d ", 2, []string{"a", "b\u2003c d"}},
+		{" ", "a b", 5, []string{"a", "b"}},
+		{":", "a::b:", 2, []string{"a", ":b:"}},
+		{":", "a:b", 1, []string{"a:b"}},
+		{"::", "a::b:::c", 2, []string{"a", "b:::c"}},
+		{"", "abc", 2, []string{"a", "bc"}},
+		{"/:+/", "a::b:c", 2, []string{"a", "b:c"}},
+	} {
+		// Reuse dst across cases, like the line loop does.
+		dst = golfSplit(dst, d.sep, d.in, d.n)
+		if len(d.want) == 0 && len(dst) == 0 {
+			continue
+		}
+		if diff := cmp.Diff(d.want, dst); diff != "" {
+			t.Errorf("golfSplit(%q, %q, %d) diff(-want,+got):\n%s", d.sep, d.in, d.n, diff)
+		}
+	}
+}
+
+var benchLine = "127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] \"GET /apache_pb.gif HTTP/1.0\" 200 2326 \"-\" \"Mozilla/4.08\""
+
+func BenchmarkSplitFields(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = strings.Fields(benchLine)
+	}
+}
+
+func BenchmarkGolfSplitSpace(b *testing.B) {
+	var dst []string
+	for i := 0; i < b.N; i++ {
+		dst = golfSplit(dst, " ", benchLine, -1)
+	}
+}
+
+func BenchmarkSplitByte(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = strings.Split(benchLine, "/")
+	}
+}
+
+func BenchmarkGolfSplitByte(b *testing.B) {
+	var dst []string
+	for i := 0; i < b.N; i++ {
+		dst = golfSplit(dst, "/", benchLine, -1)
+	}
+}
+
+func BenchmarkGolfSplitRegexp(b *testing.B) {
+	var dst []string
+	for i := 0; i < b.N; i++ {
+		dst = golfSplit(dst, "/[ /]+/", benchLine, -1)
+	}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// TestAllocs guards the per-line work golf does in line mode against
+// allocations. See the line loop in the golf command.
+func TestAllocs(t *testing.T) {
+	defer func(out io.WriteCloser, line string, l, reuse bool) {
+		CurOut, Line, GolfFlgL, GolfReuseFields = out, line, l, reuse
+	}(CurOut, Line, GolfFlgL, GolfReuseFields)
+	CurOut = golfBuffered(nopCloser{io.Discard})
+	GolfReuseFields = true
+	Line = benchLine
+	r := bufio.NewReader(strings.NewReader(strings.Repeat(benchLine+"\n", 1000)))
+	for _, d := range []struct {
+		desc string
+		f    func()
+	}{
+		{"golfReadLine", func() { golfReadLine(r, 0) }},
+		{"golfLineEnding", func() { golfLineEnding([]byte("x\r\n")) }},
+		{"golfAutosplit", golfAutosplit},
+		{"Print()", func() { Print() }},
+		{"-l Print()", func() { GolfFlgL = true; Print() }},
+	} {
+		if n := testing.AllocsPerRun(100, d.f); n != 0 {
+			t.Errorf("%s: %v allocs per run, want 0", d.desc, n)
+		}
+	}
+}
+
+func TestGolfPrintToKeepsArgs(t *testing.T) {
+	defer func(p int, l bool) { golfPrecision, GolfFlgL = p, l }(golfPrecision, GolfFlgL)
+	golfPrecision, GolfFlgL = 2, false
+	xs := []interface{}{[]byte("a"), 1.5, "b"}
+	var b strings.Builder
+	golfPrintTo(&b, "", xs)
+	if got, want := b.String(), "a1.50b"; got != want {
+		t.Errorf("golfPrintTo wrote %q, want %q", got, want)
+	}
+	if diff := cmp.Diff([]interface{}{[]byte("a"), 1.5, "b"}, xs); diff != "" {
+		t.Errorf("golfPrintTo changed its arguments. diff(-want,+got):\n%s", diff)
+	}
+}
+
+func TestReuseFields(t *testing.T) {
+	defer func(line string, reuse bool) { Line, GolfReuseFields = line, reuse }(Line, GolfReuseFields)
+	GolfReuseFields = false
+	Line = "a b c"
+	golfAutosplit()
+	kept := Fields
+	Line = "d e f"
+	golfAutosplit()
+	if diff := cmp.Diff([]string{"a", "b", "c"}, kept); diff != "" {
+		t.Errorf("kept fields overwritten. diff(-want,+got):\n%s", diff)
+	}
+	GolfReuseFields = true
+	mine := []string{"x", "y", "z"}
+	Fields = mine
+	Line = "g h i"
+	golfAutosplit()
+	if diff := cmp.Diff([]string{"x", "y", "z"}, mine); diff != "" {
+		t.Errorf("script's own Fields slice overwritten. diff(-want,+got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"g", "h", "i"}, Fields); diff != "" {
+		t.Errorf("Fields diff(-want,+got):\n%s", diff)
+	}
+}
+
+func TestGTime(t *testing.T) {
+	utc := func(y int, mo time.Month, d, h, mi, sec, ns int) time.Time {
+		return time.Date(y, mo, d, h, mi, sec, ns, time.UTC)
+	}
+	local := func(y int, mo time.Month, d, h, mi, sec, ns int) time.Time {
+		return time.Date(y, mo, d, h, mi, sec, ns, time.Local)
+	}
+	now := time.Now().Truncate(time.Second)
+	for _, d := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"2024-01-02T03:04:05Z", utc(2024, 1, 2, 3, 4, 5, 0)},
+		{"2024-01-02T03:04:05.25+01:00", utc(2024, 1, 2, 2, 4, 5, 25e7)},
+		{"2024-01-02 03:04:05+01:00", utc(2024, 1, 2, 2, 4, 5, 0)},
+		{"2024-01-02 03:04:05,123", local(2024, 1, 2, 3, 4, 5, 123e6)},
+		{"2024-01-02T03:04:05", local(2024, 1, 2, 3, 4, 5, 0)},
+		{"2024/01/02 03:04:05", local(2024, 1, 2, 3, 4, 5, 0)},
+		{"10/Oct/2000:13:55:36 -0700", utc(2000, 10, 10, 20, 55, 36, 0)},
+		{now.Format(time.Stamp), now},
+		{"Tue, 02 Jan 2024 03:04:05 GMT", utc(2024, 1, 2, 3, 4, 5, 0)},
+		{"Tue Jan  2 03:04:05 UTC 2024", utc(2024, 1, 2, 3, 4, 5, 0)},
+		{" 2024-01-02\n", local(2024, 1, 2, 0, 0, 0, 0)},
+		{"1704164645", utc(2024, 1, 2, 3, 4, 5, 0)},
+		{"1704164645.5", utc(2024, 1, 2, 3, 4, 5, 5e8)},
+		{"1704164645123", utc(2024, 1, 2, 3, 4, 5, 123e6)},
+		{"1704164645123456", utc(2024, 1, 2, 3, 4, 5, 123456e3)},
+		{"1704164645123456789", utc(2024, 1, 2, 3, 4, 5, 123456789)},
+		{"20240102", local(2024, 1, 2, 0, 0, 0, 0)},
+		{"2024", local(2024, 1, 1, 0, 0, 0, 0)},
+		{"123", time.Time{}},
+		{"yesterday", time.Time{}},
+		{"", time.Time{}},
+	} {
+		if got := GTime(d.in); !got.Equal(d.want) {
+			t.Errorf("GTime(%q) = %v, want %v", d.in, got, d.want)
+		}
+	}
+	if got := FromEpoch(Epoch(now)); !got.Equal(now) {
+		t.Errorf("FromEpoch(Epoch(%v)) = %v", now, got)
+	}
+}
+
+func TestWarningReport(t *testing.T) {
+	defer func() {
+		Warnings, Fields, Filename, LineNum = false, nil, "", 0
+		golfWarnings = map[string]*WarningCount{}
+	}()
+	Warnings = true
+	Filename, Fields = "f1", []string{"a"}
+	for LineNum = 1; LineNum <= 3; LineNum++ {
+		Field(2)
+		GAtoi("N/A")
+	}
+	GAtoi("x")
+	Field(-3)
+	want := []WarningCount{
+		{"strconv.Atoi: invalid syntax", 4, `f1:1: "N/A"`},
+		{"undefined field 2", 3, "f1:1: [a]"},
+		{"undefined field -3", 1, "f1:4: [a]"},
+	}
+	if diff := cmp.Diff(want, WarningReport()); diff != "" {
+		t.Errorf("WarningReport() diff(-want,+got):\n%s", diff)
+	}
+}
+
+func TestGolfDiff(t *testing.T) {
+	for _, d := range []struct {
+		desc, a, b, want string
+	}{
+		{"same", "a\nb\n", "a\nb\n", ""},
+		{"change", "1\n2\n3\n4\n5\n6\n7\n8\n9\n", "1\n2\n3\n4\nV\n6\n7\n8\n9\n",
+			"--- f\n+++ f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+V\n 6\n 7\n 8\n"},
+		{"two hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "x\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"--- f\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -7,4 +7,3 @@\n 7\n 8\n 9\n-10\n"},
+		{"from empty", "", "a\n", "--- f\n+++ f\n@@ -0,0 +1 @@\n+a\n"},
+		{"no newline", "a\nb", "a\nb\n", "--- f\n+++ f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+	} {
+		var out bytes.Buffer
+		golfDiff(&out, "f", []byte(d.a), []byte(d.b))
+		if diff := cmp.Diff(d.want, out.String()); diff != "" {
+			t.Errorf("%s: diff(-want,+got):\n%s", d.desc, diff)
+		}
+	}
+}
+
+func TestGolfDiffScript(t *testing.T) {
+	// Check that the edit scripts for random inputs turn a into b, and are
+	// as short as the ones found by the textbook dynamic programming.
+	r := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		var l []string
+		for i := r.Intn(12); i > 0; i-- {
+			l = append(l, string(rune('a'+r.Intn(3))))
+		}
+		return l
+	}
+	for i := 0; i < 1000; i++ {
+		a, b := lines(), lines()
+		var gotA, gotB []string
+		edits := 0
+		for _, op := range golfDiffScript(a, b) {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if !cmp.Equal(a, gotA, cmpopts.EquateEmpty()) || !cmp.Equal(b, gotB, cmpopts.EquateEmpty()) {
+			t.Fatalf("golfDiffScript(%q, %q) gives %q -> %q", a, b, gotA, gotB)
+		}
+		// dp[i][j] is the shortest edit distance from a[i:] to b[j:].
+		dp := make([][]int, len(a)+1)
+		for i := range dp {
+			dp[i] = make([]int, len(b)+1)
+		}
+		for i := len(a); i >= 0; i-- {
+			for j := len(b); j >= 0; j-- {
+				switch {
+				case i == len(a):
+					dp[i][j] = len(b) - j
+				case j == len(b):
+					dp[i][j] = len(a) - i
+				case a[i] == b[j]:
+					dp[i][j] = dp[i+1][j+1]
+				case dp[i+1][j] < dp[i][j+1]:
+					dp[i][j] = 1 + dp[i+1][j]
+				default:
+					dp[i][j] = 1 + dp[i][j+1]
+				}
+			}
+		}
+		if edits != dp[0][0] {
+			t.Fatalf("golfDiffScript(%q, %q): %d edits, want %d", a, b, edits, dp[0][0])
+		}
+	}
+}
+
+func TestGErr(t *testing.T) {
+	if err := GErr(nil, "ctx"); err != nil {
+		t.Errorf("GErr(nil) = %v, want nil", err)
+	}
+	base := io.ErrUnexpectedEOF
+	err := GErr(GErr(base, "reading %s", "f"), "config")
+	if got, want := err.Error(), "config: reading f: unexpected EOF"; got != want {
+		t.Errorf("GErr chain = %q, want %q", got, want)
+	}
+	if !errors.Is(err, base) {
+		t.Errorf("errors.Is(%v, %v) = false, want true", err, base)
+	}
+}
+
+func TestWarnErrCount(t *testing.T) {
+	defer func(stderr *os.File, n int) { os.Stderr, ErrCount = stderr, n }(os.Stderr, ErrCount)
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	os.Stderr, ErrCount = null, 0
+	Warn(errors.New("bad record"))
+	if ErrCount != 0 {
+		t.Errorf("ErrCount = %d after Warn of a plain error, want 0", ErrCount)
+	}
+	Warn(GErr(errors.New("oops"), "f"))
+	Warn("line %d: %v", 3, GErr(errors.New("oops"), "g"))
+	if ErrCount != 2 {
+		t.Errorf("ErrCount = %d after Warn of two GErr errors, want 2", ErrCount)
+	}
+}
+
+func TestRound(t *testing.T) {
+	for _, d := range []struct {
+		f    func(float64, int) float64
+		name string
+		x    float64
+		n    int
+		want float64
+	}{
+		{Round, "Round", 2.5, 0, 3},
+		{Round, "Round", -2.5, 0, -3},
+		{Round, "Round", 1.23456, 2, 1.23},
+		{Round, "Round", 1250, -2, 1300},
+		{Floor, "Floor", 1.239, 2, 1.23},
+		{Floor, "Floor", -1.231, 2, -1.24},
+		{Ceil, "Ceil", 1.231, 2, 1.24},
+		{Ceil, "Ceil", 1201, -2, 1300},
+		{Round, "Round", 1.005, 2, 1.01},
+		{Round, "Round", 0.285, 2, 0.29},
+		{Floor, "Floor", 0.29, 2, 0.29},
+		{Floor, "Floor", 4.35, 2, 4.35},
+		{Floor, "Floor", -0.29, 2, -0.29},
+		{Ceil, "Ceil", 0.57, 2, 0.57},
+		{Ceil, "Ceil", 1.1, 1, 1.1},
+		{Round, "Round", 0.15, 1, 0.2},
+	} {
+		if got := d.f(d.x, d.n); got != d.want {
+			t.Errorf("%s(%v, %d) = %v, want %v", d.name, d.x, d.n, got, d.want)
+		}
+	}
+}
+
+func TestGolfWarnQuiet(t *testing.T) {
+	defer func(q bool, n int) { GolfQuiet, ErrCount = q, n }(GolfQuiet, ErrCount)
+	GolfQuiet, ErrCount = true, 0
+	golfWarn("golf: %s: %v", "f", errors.New("oops"))
+	if ErrCount != 1 {
+		t.Errorf("ErrCount = %d after golfWarn under -q, want 1", ErrCount)
+	}
+}
+
+func TestResolveFeatures(t *testing.T) {
+	got, err := ResolveFeatures([]string{"yaml", "syslog", "json", "syslog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"json", "source", "syslog", "time", "yaml"}, got); diff != "" {
+		t.Errorf("ResolveFeatures diff(-want,+got):\n%s", diff)
+	}
+	if _, err := ResolveFeatures([]string{"nonesuch"}); err == nil {
+		t.Error("ResolveFeatures of an unknown feature succeeded")
+	}
+	for name, f := range features {
+		for _, d := range f.deps {
+			if _, ok := features[d]; !ok {
+				t.Errorf("feature %s depends on unknown feature %s", name, d)
+			}
+		}
+	}
+}
+
+// writeLog records the writes made to it.
+type writeLog struct{ writes []string }
+
+func (w *writeLog) Write(b []byte) (int, error) {
+	w.writes = append(w.writes, string(b))
+	return len(b), nil
+}
+
+func (w *writeLog) Close() error { return nil }
+
+func TestGolfRecordOut(t *testing.T) {
+	log := &writeLog{}
+	o := &golfRecordOut{f: log}
+	line := strings.Repeat("x", 999) + "\n"
+	for i := 0; i < 200; i++ {
+		o.Write([]byte(line[:500]))
+		o.Write([]byte(line[500:]))
+	}
+	o.Write([]byte("tail"))
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.writes) < 3 {
+		t.Fatalf("%d writes, want output written as it's buffered", len(log.writes))
+	}
+	for i, w := range log.writes[:len(log.writes)-1] {
+		if !strings.HasSuffix(w, "\n") || len(w)%len(line) != 0 {
+			t.Errorf("write %d: %d bytes, ending %q, want whole lines", i, len(w), w[len(w)-1:])
+		}
+	}
+	if got, want := strings.Join(log.writes, ""), strings.Repeat(line, 200)+"tail"; got != want {
+		t.Errorf("wrote %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestGolfNewLines(t *testing.T) {
+	for _, d := range []struct {
+		ranges []golfLineRange
+		total  int
+		want   []int // Lines selected, from 1 to 10.
+		last   int
+	}{
+		{[]golfLineRange{{2, 4}}, -1, []int{2, 3, 4}, 4},
+		{[]golfLineRange{{9, 0}}, -1, []int{9, 10}, math.MaxInt},
+		{[]golfLineRange{{1, 1}, {-2, 0}}, 6, []int{1, 5, 6}, 6},
+		{[]golfLineRange{{2, -2}}, 5, []int{2, 3, 4}, 4},
+		{[]golfLineRange{{-20, 2}}, 5, []int{1, 2}, 2},
+		{[]golfLineRange{{-3, -1}}, 0, nil, 0},
+	} {
+		l := golfNewLines(d.ranges, d.total)
+		var got []int
+		for n := 1; n <= 10; n++ {
+			if l.has(n) {
+				got = append(got, n)
+			}
+		}
+		if diff := cmp.Diff(d.want, got); diff != "" || l.last != d.last {
+			t.Errorf("golfNewLines(%v, %d): last %d, want %d; diff(-want,+got):\n%v", d.ranges, d.total, l.last, d.last, diff)
+		}
+	}
+}
+
+func TestFlipFlop(t *testing.T) {
+	lines := []string{"a", "BEGIN", "b", "END", "c", "BEGIN END", "d", "BEGIN", "e"}
+	f := NewFlipFlop("BEGIN", "END")
+	var got []string
+	for _, l := range lines {
+		if f.Match(l) {
+			got = append(got, l)
+		}
+	}
+	want := []string{"BEGIN", "b", "END", "BEGIN END", "BEGIN", "e"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FlipFlop: diff(-want,+got):\n%v", diff)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	defer func(l string) { Line = l }(Line)
+	var a, b []string
+	for _, Line = range []string{"1", "x", "2", "y", "3"} {
+		if Between("x", "y") {
+			a = append(a, Line)
+		}
+		if Between("2", "3") {
+			b = append(b, Line)
+		}
+	}
+	if diff := cmp.Diff([]string{"x", "2", "y"}, a); diff != "" {
+		t.Errorf("Between(x, y): diff(-want,+got):\n%v", diff)
+	}
+	if diff := cmp.Diff([]string{"2", "y", "3"}, b); diff != "" {
+		t.Errorf("Between(2, 3): diff(-want,+got):\n%v", diff)
+	}
+}
+
+func TestGolfSplitArgs(t *testing.T) {
+	defer func(args []string) { os.Args, ARGV, ARGS = args, nil, nil }(os.Args)
+	for _, d := range []struct {
+		args            []string
+		argv, argsAfter []string
+		osArgs          []string
+	}{
+		{[]string{"golfing", "f1", "f2"}, []string{"f1", "f2"}, nil, []string{"golfing", "f1", "f2"}},
+		{[]string{"golfing", "f1", "--", "-n", "--"}, []string{"f1"}, []string{"-n", "--"}, []string{"golfing", "-n", "--"}},
+		{[]string{"golfing", "--", "x"}, []string{}, []string{"x"}, []string{"golfing", "x"}},
+	} {
+		os.Args = d.args
+		golfSplitArgs()
+		if diff := cmp.Diff(d.argv, ARGV, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%q: ARGV diff(-want,+got):\n%v", d.args, diff)
+		}
+		if diff := cmp.Diff(d.argsAfter, ARGS, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%q: ARGS diff(-want,+got):\n%v", d.args, diff)
+		}
+		if diff := cmp.Diff(d.osArgs, os.Args); diff != "" {
+			t.Errorf("%q: os.Args diff(-want,+got):\n%v", d.args, diff)
+		}
+	}
+}
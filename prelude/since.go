@@ -0,0 +1,113 @@
+package prelude
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// This file is only embedded in the generated program with --since-last.
+
+// golf:prelude start
+
+// golfSinceFile is the --since-last state of an input file.
+type golfSinceFile struct {
+	Offset int64  // Bytes already processed.
+	Lines  int    // Lines already processed.
+	Head   uint64 // Hash of the start of the file, to notice it was replaced.
+}
+
+var (
+	golfSincePath string
+	golfSince     = map[string]*golfSinceFile{}
+)
+
+// golfSinceLoad loads the --since-last state of job, kept in $GOLF_STATE_DIR,
+// or in golf's user cache directory.
+func golfSinceLoad(job string) {
+	dir := os.Getenv("GOLF_STATE_DIR")
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			Die("golf: --since-last: %v", err)
+		}
+		dir = filepath.Join(cache, "golf")
+	}
+	golfSincePath = filepath.Join(dir, "since-last", job+".json")
+	data, err := os.ReadFile(golfSincePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &golfSince)
+	}
+	if err != nil {
+		Die("golf: --since-last: %v", err)
+	}
+}
+
+// golfSinceHead hashes the first bytes of f, up to n, with FNV-1a.
+func golfSinceHead(f *os.File, n int64) uint64 {
+	if n > 4096 {
+		n = 4096
+	}
+	buf := make([]byte, n)
+	n2, _ := f.ReadAt(buf, 0)
+	h := fnv.New64a()
+	h.Write(buf[:n2])
+	return h.Sum64()
+}
+
+// golfSinceStart skips the part of f, opened as Filename, that the last run
+// processed, unless the file was truncated or replaced since. It returns
+// the state to keep up to date as lines are read.
+func golfSinceStart(f *os.File) *golfSinceFile {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		// Pipes and such can't be resumed. Don't track them.
+		return &golfSinceFile{}
+	}
+	name, err := filepath.Abs(Filename)
+	if err != nil {
+		Die("golf: --since-last: %v", err)
+	}
+	st, ok := golfSince[name]
+	if !ok {
+		st = &golfSinceFile{}
+		golfSince[name] = st
+	}
+	if fi.Size() < st.Offset || golfSinceHead(f, st.Offset) != st.Head {
+		st.Offset, st.Lines = 0, 0
+	}
+	if _, err := f.Seek(st.Offset, io.SeekStart); err != nil {
+		st.Offset, st.Lines = 0, 0
+	}
+	return st
+}
+
+// golfSinceSave saves the --since-last state for the next run.
+func golfSinceSave() {
+	for name, st := range golfSince {
+		if f, err := os.Open(name); err == nil {
+			st.Head = golfSinceHead(f, st.Offset)
+			f.Close()
+		}
+	}
+	data, err := json.MarshalIndent(golfSince, "", "\t")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(golfSincePath), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(golfSincePath+".tmp", data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(golfSincePath+".tmp", golfSincePath)
+	}
+	if err != nil {
+		Die("golf: --since-last: %v", err)
+	}
+}
+
+// golf:prelude end
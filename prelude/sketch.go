@@ -0,0 +1,233 @@
+package prelude
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// This file is only embedded in the generated program when the script uses
+// Bloom, TopK or HLL, or with a feature that does.
+
+// golf:prelude start
+
+// BloomFilter is an approximate set of strings, built by Bloom.
+//
+// It uses a fixed amount of memory regardless of how many strings are added,
+// at the cost of occasional false positives: Has may report true for a string
+// that was never added. It never reports false for one that was.
+type BloomFilter struct {
+	bits []uint64
+	m    uint64 // number of bits.
+	k    int    // number of hash functions.
+}
+
+// Bloom returns a BloomFilter sized for expectedN strings with a false
+// positive rate of about fpRate once that many have been added.
+//
+// Useful for approximate dedup of streams too large to keep in a map:
+//
+//	golf -b 'seen := Bloom(1e8, 0.001)' -ne 'if !seen.Add(Line) { Print() }'
+func Bloom(expectedN int, fpRate float64) *BloomFilter {
+	if expectedN < 1 {
+		expectedN = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		Die("Bloom: false positive rate must be between 0 and 1, got %v", fpRate)
+	}
+	n := float64(expectedN)
+	m := math.Ceil(-n * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / n * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	words := (uint64(m) + 63) / 64
+	return &BloomFilter{bits: make([]uint64, words), m: words * 64, k: k}
+}
+
+// bloomHash returns two independent-enough hashes of s: FNV-1a, and a
+// splitmix64 finalization of it, made odd so it can serve as a stride.
+func bloomHash(s string) (uint64, uint64) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	h2 := h + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h, h2 | 1
+}
+
+// Add adds s to the filter. It reports whether s was (probably) already
+// present, so that it can be used directly for dedup.
+func (b *BloomFilter) Add(s string) bool {
+	h1, h2 := bloomHash(s)
+	present := true
+	for i := 0; i < b.k; i++ {
+		bit := (h1 + uint64(i)*h2) % b.m
+		w, mask := bit/64, uint64(1)<<(bit%64)
+		if b.bits[w]&mask == 0 {
+			present = false
+			b.bits[w] |= mask
+		}
+	}
+	return present
+}
+
+// Has reports whether s was (probably) added to the filter.
+func (b *BloomFilter) Has(s string) bool {
+	h1, h2 := bloomHash(s)
+	for i := 0; i < b.k; i++ {
+		bit := (h1 + uint64(i)*h2) % b.m
+		if b.bits[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TopKCounter approximately tracks the most frequent strings in a stream,
+// using memory proportional to k. Built by TopK.
+type TopKCounter struct {
+	k    int
+	heap []*TopKItem // min-heap on Count.
+	pos  map[string]int
+}
+
+// TopKItem is an entry reported by TopKCounter.
+type TopKItem struct {
+	Key string
+	// Count is an upper bound on the number of times Key was seen.
+	// It overestimates by at most Err.
+	Count, Err int
+}
+
+// TopK returns a counter for the k most frequent strings in a stream.
+//
+// It implements the Space-Saving algorithm: any string seen more than N/k
+// times in a stream of N strings is guaranteed to be reported.
+//
+//	golf -b 'tk := TopK(10)' -ane 'tk.Add(Field(1))' -E 'for _, t := range tk.Top() { Printf("%d %s\n", t.Count, t.Key) }'
+func TopK(k int) *TopKCounter {
+	if k < 1 {
+		Die("TopK: k must be positive, got %d", k)
+	}
+	return &TopKCounter{k: k, pos: map[string]int{}}
+}
+
+// Add counts one occurrence of s.
+func (t *TopKCounter) Add(s string) {
+	if i, ok := t.pos[s]; ok {
+		t.heap[i].Count++
+		t.down(i)
+		return
+	}
+	if len(t.heap) < t.k {
+		t.heap = append(t.heap, &TopKItem{Key: s, Count: 1})
+		t.pos[s] = len(t.heap) - 1
+		t.up(len(t.heap) - 1)
+		return
+	}
+	// Evict the least frequent item, and let s inherit its count.
+	min := t.heap[0]
+	delete(t.pos, min.Key)
+	min.Key, min.Err = s, min.Count
+	min.Count++
+	t.pos[s] = 0
+	t.down(0)
+}
+
+// Top returns the tracked items, most frequent first.
+func (t *TopKCounter) Top() []TopKItem {
+	res := make([]TopKItem, len(t.heap))
+	for i, it := range t.heap {
+		res[i] = *it
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+func (t *TopKCounter) swap(i, j int) {
+	t.heap[i], t.heap[j] = t.heap[j], t.heap[i]
+	t.pos[t.heap[i].Key] = i
+	t.pos[t.heap[j].Key] = j
+}
+
+func (t *TopKCounter) up(i int) {
+	for i > 0 {
+		p := (i - 1) / 2
+		if t.heap[p].Count <= t.heap[i].Count {
+			return
+		}
+		t.swap(i, p)
+		i = p
+	}
+}
+
+func (t *TopKCounter) down(i int) {
+	for {
+		min := i
+		for _, c := range []int{2*i + 1, 2*i + 2} {
+			if c < len(t.heap) && t.heap[c].Count < t.heap[min].Count {
+				min = c
+			}
+		}
+		if min == i {
+			return
+		}
+		t.swap(i, min)
+		i = min
+	}
+}
+
+// HyperLogLog approximately counts distinct strings, using a fixed 16KiB of
+// memory. Built by HLL.
+type HyperLogLog struct {
+	reg [1 << hllPrecision]uint8
+}
+
+const hllPrecision = 14 // standard error is about 1.04/sqrt(2^14), under 1%.
+
+// HLL returns an approximate distinct counter.
+//
+//	golf -b 'ips := HLL()' -ane 'ips.Add(Field(1))' -E 'Print(ips.Count())' access.log
+func HLL() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// Add adds s to the set being counted.
+func (h *HyperLogLog) Add(s string) {
+	_, x := bloomHash(s)
+	i := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.reg[i] {
+		h.reg[i] = rank
+	}
+}
+
+// Count returns the estimated number of distinct strings added.
+func (h *HyperLogLog) Count() int {
+	const m = float64(len(h.reg))
+	sum, zeros := 0.0, 0
+	for _, r := range h.reg {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// Small range correction: linear counting.
+		est = m * math.Log(m/float64(zeros))
+	}
+	return int(est + 0.5)
+}
+
+// golf:prelude end
@@ -0,0 +1,157 @@
+package prelude
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// This file is only embedded in the generated program with --source, or when
+// the script refers to record sources.
+
+// golf:prelude start
+
+// RecordSource splits input into records for line mode, in place of lines.
+// Select one by name with --source; register more with RegisterSource from a
+// -b block or a -M package.
+type RecordSource interface {
+	// Open starts reading the next input. name is also in Filename.
+	Open(name string, r io.Reader) error
+	// Next returns the next record, without its terminator. It returns
+	// io.EOF at the end of the input.
+	Next() ([]byte, error)
+	// Close is called once all inputs were read.
+	Close() error
+}
+
+var golfSources = map[string]func() RecordSource{
+	"nul":  func() RecordSource { return SplitSource(golfScanNul) },
+	"json": func() RecordSource { return &golfJSONSource{} },
+}
+
+// RegisterSource makes a record source available to --source under name.
+func RegisterSource(name string, newSource func() RecordSource) {
+	golfSources[name] = newSource
+}
+
+// SplitSource returns a RecordSource that splits its inputs with split, as
+// bufio.Scanner does. For example, to read words as records:
+//
+//	golf -b 'RegisterSource("words", func() RecordSource { return SplitSource(bufio.ScanWords) })' --source words -lne 'Print(Line)'
+func SplitSource(split bufio.SplitFunc) RecordSource {
+	return &golfSplitSource{split: split}
+}
+
+type golfSplitSource struct {
+	split bufio.SplitFunc
+	sc    *bufio.Scanner
+}
+
+func (s *golfSplitSource) Open(name string, r io.Reader) error {
+	s.sc = bufio.NewScanner(r)
+	s.sc.Buffer(nil, math.MaxInt32)
+	s.sc.Split(s.split)
+	return nil
+}
+
+func (s *golfSplitSource) Next() ([]byte, error) {
+	if s.sc.Scan() {
+		return s.sc.Bytes(), nil
+	}
+	if err := s.sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (s *golfSplitSource) Close() error { return nil }
+
+// golfScanNul splits NUL-terminated records, as written by find -print0.
+func golfScanNul(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// golfJSONSource reads JSON documents, for --json-doc. If the input is an
+// array, its elements are records, read as a stream, so that the array
+// needn't fit in memory at once; otherwise, each document in it is one.
+// Records are compacted to a single line.
+type golfJSONSource struct {
+	dec     *json.Decoder
+	inArray bool
+	buf     bytes.Buffer
+}
+
+func (s *golfJSONSource) Open(name string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	s.dec = json.NewDecoder(br)
+	s.inArray = false
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil // Empty: no records.
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		br.UnreadByte()
+		if c == '[' {
+			s.dec.Token()
+			s.inArray = true
+		}
+		return nil
+	}
+}
+
+func (s *golfJSONSource) Next() ([]byte, error) {
+	if s.inArray && !s.dec.More() {
+		// The closing ]. Anything after is read as documents.
+		if _, err := s.dec.Token(); err != nil {
+			return nil, err
+		}
+		s.inArray = false
+	}
+	var raw json.RawMessage
+	if err := s.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return s.compact(raw)
+}
+
+func (s *golfJSONSource) compact(raw []byte) ([]byte, error) {
+	s.buf.Reset()
+	if err := json.Compact(&s.buf, raw); err != nil {
+		return nil, err
+	}
+	return s.buf.Bytes(), nil
+}
+
+func (s *golfJSONSource) Close() error { return nil }
+
+func golfSource(name string) RecordSource {
+	newSource, ok := golfSources[name]
+	if !ok {
+		Die("golf: --source: unknown record source %q", name)
+	}
+	return newSource()
+}
+
+// golfSourceNext reads a record from src in the form golfReadLine would,
+// newline terminated.
+func golfSourceNext(src RecordSource) ([]byte, error) {
+	rec, err := src.Next()
+	if err != nil {
+		return nil, err
+	}
+	return append(rec[:len(rec):len(rec)], '\n'), nil
+}
+
+// golf:prelude end
@@ -0,0 +1,166 @@
+package prelude
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file is only embedded in the generated program when the script uses
+// ParseSyslog.
+
+// golf:prelude start
+
+// Syslog is a syslog message, in the BSD format of RFC 3164, as in the files
+// in /var/log, or in the format of RFC 5424. Fields that are missing, or
+// "-" in RFC 5424, are empty.
+type Syslog struct {
+	// Priority is the <PRI> part, which /var/log files usually leave out,
+	// or -1. Facility and Severity are derived from it, or -1 too.
+	Priority, Facility, Severity int
+	Time                         time.Time
+	Host                         string
+	Tag                          string // The program, or APP-NAME.
+	PID                          string // Or PROCID.
+	MsgID                        string // RFC 5424 only.
+	Data                         string // RFC 5424 STRUCTURED-DATA, as written.
+	Msg                          string
+}
+
+// ParseSyslog parses line as a syslog message. BSD timestamps have no year,
+// so they are taken to be within the last year, in local time; ones in
+// RFC 3339 form, as rsyslog writes, are also understood. It returns nil if
+// line is not a syslog message, which counts as a warning in -w mode.
+//
+//	golf -lne 'if m := ParseSyslog(Line); m != nil && m.Tag == "sshd" { Print(m.Time.Format(time.Stamp), m.Msg) }' /var/log/auth.log
+func ParseSyslog(line string) *Syslog {
+	m := &Syslog{Priority: -1, Facility: -1, Severity: -1}
+	s := strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(s, "<") {
+		end, pri := strings.IndexByte(s, '>'), -1
+		if end > 0 {
+			if n, err := strconv.Atoi(s[1:end]); err == nil {
+				pri = n
+			}
+		}
+		if pri < 0 || pri > 191 {
+			golfSyslogWarning(line)
+			return nil
+		}
+		m.Priority, m.Facility, m.Severity = pri, pri/8, pri%8
+		s = s[end+1:]
+		if len(s) > 1 && s[0] >= '1' && s[0] <= '9' && s[1] == ' ' {
+			if !golfSyslog5424(m, s[2:]) {
+				golfSyslogWarning(line)
+				return nil
+			}
+			return m
+		}
+	}
+	if !golfSyslog3164(m, s) {
+		golfSyslogWarning(line)
+		return nil
+	}
+	return m
+}
+
+// golfSyslog5424 parses s, what follows <PRI>VERSION in an RFC 5424
+// message, into m.
+func golfSyslog5424(m *Syslog, s string) bool {
+	parts := strings.SplitN(s, " ", 6)
+	if len(parts) < 6 {
+		return false
+	}
+	if parts[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, parts[0])
+		if err != nil {
+			return false
+		}
+		m.Time = t
+	}
+	for i, f := range []*string{&m.Host, &m.Tag, &m.PID, &m.MsgID} {
+		if parts[i+1] != "-" {
+			*f = parts[i+1]
+		}
+	}
+	rest := parts[5]
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		// SD-ELEMENTs, whose quoted values may hold escapes and ].
+		i := 0
+		for i < len(rest) && rest[i] == '[' {
+			quoted := false
+			for i++; i < len(rest) && (quoted || rest[i] != ']'); i++ {
+				switch rest[i] {
+				case '\\':
+					i++
+				case '"':
+					quoted = !quoted
+				}
+			}
+			if i >= len(rest) {
+				return false
+			}
+			i++
+		}
+		if i == 0 {
+			return false
+		}
+		m.Data, rest = rest[:i], rest[i:]
+	}
+	rest = strings.TrimPrefix(rest, " ")
+	m.Msg = strings.TrimPrefix(rest, "\ufeff") // A BOM marks UTF-8.
+	return true
+}
+
+// golfSyslog3164 parses s, an RFC 3164 message without its <PRI>, into m:
+// TIMESTAMP HOST TAG[PID]: MSG.
+func golfSyslog3164(m *Syslog, s string) bool {
+	if t, err := time.ParseInLocation(time.Stamp, golfPrefix(s, len(time.Stamp)), time.Local); err == nil {
+		m.Time, s = golfStampYear(t), s[len(time.Stamp):]
+	} else {
+		ts := s
+		if i := strings.IndexByte(s, ' '); i >= 0 {
+			ts = s[:i]
+		}
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return false
+		}
+		m.Time, s = t, s[len(ts):]
+	}
+	s = strings.TrimPrefix(s, " ")
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		m.Host = s
+		return true
+	}
+	m.Host, s = s[:i], s[i+1:]
+	// The tag ends at a colon, before any blank; without one, it's all
+	// message.
+	if i := strings.IndexAny(s, ": "); i > 0 && s[i] == ':' {
+		m.Tag, s = s[:i], strings.TrimPrefix(s[i+1:], " ")
+		if j := strings.IndexByte(m.Tag, '['); j > 0 && strings.HasSuffix(m.Tag, "]") {
+			m.Tag, m.PID = m.Tag[:j], m.Tag[j+1:len(m.Tag)-1]
+		}
+	}
+	m.Msg = s
+	return true
+}
+
+// golfPrefix returns the first n bytes of s, or s if it is shorter.
+func golfPrefix(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
+
+func golfSyslogWarning(line string) {
+	if Warnings {
+		golfNoteWarning("not a syslog line", strconv.Quote(strings.TrimSuffix(line, LineEnding)))
+	}
+}
+
+// golf:prelude end
@@ -0,0 +1,72 @@
+package prelude
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// This file is only embedded in the generated program with -tail.
+
+// golf:prelude start
+
+func init() {
+	golfFollow = golfTail
+}
+
+// golfTailPoll is how often -tail checks a file for more data.
+const golfTailPoll = 250 * time.Millisecond
+
+// golfTailReader reads a file as it grows, like tail -F: at its end, it
+// waits for more data, switching to a new file of the same name if the file
+// was rotated, and starting over if it was truncated. It ends when Ctx is
+// done.
+type golfTailReader struct {
+	name string
+	f    *os.File
+	next *os.File // The file that replaced f, once f is read to its end.
+}
+
+// golfTail returns a reader of f, named name, for -tail, and a function to
+// close it.
+func golfTail(f *os.File, name string) (io.Reader, func()) {
+	t := &golfTailReader{name: name, f: f}
+	return t, func() { t.f.Close() }
+}
+
+func (t *golfTailReader) Read(b []byte) (int, error) {
+	for {
+		n, err := t.f.Read(b)
+		if n > 0 || err != nil && err != io.EOF {
+			return n, err
+		}
+		if t.next != nil {
+			t.f.Close()
+			t.f, t.next = t.next, nil
+			continue
+		}
+		// Show what was printed so far while waiting.
+		Flush()
+		cur, err := t.f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if fi, err := os.Stat(t.name); err == nil && !os.SameFile(fi, cur) {
+			if t.next, err = os.Open(t.name); err == nil {
+				// Read what was written to the old file meanwhile first.
+				continue
+			}
+		}
+		if pos, err := t.f.Seek(0, io.SeekCurrent); err == nil && cur.Size() < pos {
+			if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		select {
+		case <-Ctx.Done():
+			return 0, io.EOF
+		case <-time.After(golfTailPoll):
+		}
+	}
+}
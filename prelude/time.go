@@ -0,0 +1,127 @@
+package prelude
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file is only embedded in the generated program when the script uses
+// GTime, Epoch or FromEpoch, or with a feature that does.
+
+// golf:prelude start
+
+// golfTimeLayouts are the layouts GTime tries, most common first. Those
+// without a zone are in local time.
+var golfTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006/01/02 15:04:05.999999999",
+	"02/Jan/2006:15:04:05 -0700", // Common log format.
+	"Jan _2 15:04:05.999999999",  // Syslog, without a year.
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+	time.ANSIC,
+	time.RubyDate,
+	time.RFC850,
+	"2006-01-02",
+	"20060102",
+	"2006",
+}
+
+// GTime parses s as a timestamp in any of many common forms: RFC 3339 and
+// ISO 8601 variants, with a space or a T, common log and syslog times, which
+// are taken to be within the last year, RFC 1123 and date(1) output, dates
+// such as 20240102 and years, and epoch seconds, milliseconds, microseconds
+// or nanoseconds, of 9 digits or more, told apart by their size. Timestamps without a zone are in local time. If s is none of
+// these, GTime returns the zero time, and issues an optional warning, or in
+// -strict mode dies.
+//
+//	golf -lane 'if time.Since(GTime(Field(1))) < time.Hour { Print() }' app.log
+func GTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if t, ok := golfEpochTime(s); ok {
+		return t
+	}
+	for _, layout := range golfTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			if t.Year() == 0 {
+				t = golfStampYear(t)
+			}
+			return t
+		}
+	}
+	switch {
+	case Strict:
+		golfConvError(fmt.Errorf("GTime: unknown time format %q", s))
+	case Warnings:
+		golfNoteWarning("GTime: unknown time format", strconv.Quote(s))
+	}
+	return time.Time{}
+}
+
+// golfEpochTime parses s as a time since the Unix epoch, in seconds, with
+// an optional fraction, or if it is too large for that to be a recent time,
+// in milliseconds, microseconds or nanoseconds. Shorter numbers than 9
+// digits, before 1973 as seconds, are more likely dates or years.
+func golfEpochTime(s string) (time.Time, bool) {
+	whole := s
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole = s[:i]
+	}
+	if len(whole) < 9 || strings.Trim(s, "0123456789.") != "" {
+		return time.Time{}, false
+	}
+	if strings.IndexByte(s, '.') >= 0 {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec := math.Floor(f)
+		return time.Unix(int64(sec), int64((f-sec)*1e9)), true
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch {
+	case n < 1e11:
+		return time.Unix(n, 0), true
+	case n < 1e14:
+		return time.UnixMilli(n), true
+	case n < 1e17:
+		return time.UnixMicro(n), true
+	default:
+		return time.Unix(0, n), true
+	}
+}
+
+// golfStampYear sets the year of t, parsed from a timestamp without one, as
+// in syslog, to the one that puts it within the last year. Up to a day
+// ahead is allowed for clock skew.
+func golfStampYear(t time.Time) time.Time {
+	now := time.Now()
+	t = t.AddDate(now.Year()-t.Year(), 0, 0)
+	if t.After(now.AddDate(0, 0, 1)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// Epoch returns t as seconds since the Unix epoch.
+func Epoch(t time.Time) int64 {
+	return t.Unix()
+}
+
+// FromEpoch returns the local time n seconds after the Unix epoch.
+func FromEpoch(n int64) time.Time {
+	return time.Unix(n, 0)
+}
+
+// golf:prelude end
@@ -0,0 +1,62 @@
+package prelude
+
+import (
+	"strings"
+)
+
+// This file is only embedded in the generated program with --tsv.
+
+// golf:prelude start
+
+var (
+	golfTSVFields []string // Fields as split, to tell whether the script changed them.
+
+	golfTSVUnescaper = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\r`, "\r", `\\`, `\`)
+	golfTSVEscaper   = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`, `\`, `\\`)
+)
+
+// golfTSVSplit splits Line into Fields at tabs, for --tsv, and unescapes
+// them: \t, \n, \r and \\ stand for a tab, a newline, a carriage return and
+// a backslash.
+func golfTSVSplit() {
+	line := strings.TrimSuffix(Line, LineEnding)
+	golfTSVFields = golfSplit(golfTSVFields, "\t", line, -1)
+	if line == "" {
+		golfTSVFields = golfTSVFields[:0] // A blank line.
+	}
+	golfFieldsBuf = append(golfFieldsStorage(), golfTSVFields...)
+	Fields = golfFieldsBuf
+	for i, f := range Fields {
+		if strings.IndexByte(f, '\\') >= 0 {
+			Fields[i] = golfTSVUnescaper.Replace(f)
+			golfTSVFields[i] = Fields[i]
+		}
+	}
+}
+
+// golfTSVJoin escapes fields, and joins them with tabs.
+func golfTSVJoin(fields []string) string {
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		golfTSVEscaper.WriteString(&b, f)
+	}
+	return b.String()
+}
+
+// golfTSVLine returns the record that Print prints by default: Line, unless
+// the script changed Fields, in which case they are escaped and joined anew.
+func golfTSVLine() string {
+	if golfSameFields(Fields, golfTSVFields) {
+		return Line
+	}
+	s := golfTSVJoin(Fields)
+	if !GolfFlgL {
+		s += LineEnding
+	}
+	return s
+}
+
+// golf:prelude end
@@ -0,0 +1,33 @@
+package prelude
+
+import (
+	"net/http"
+)
+
+// This file is only embedded in the generated program when an input is a URL.
+
+// golf:prelude start
+
+func init() {
+	golfOpenURL = golfFetch
+}
+
+// golfFetch fetches url as an input of line mode. The request is cancelled
+// with Ctx.
+func golfFetch(url string) (golfInput, func()) {
+	req, err := http.NewRequestWithContext(Ctx, "GET", url, nil)
+	if err != nil {
+		Die("golf: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		Die("golf: %v", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		Die("golf: %s: %s", url, resp.Status)
+	}
+	return golfInput{Name: url, R: resp.Body, Size: resp.ContentLength}, func() { resp.Body.Close() }
+}
+
+// golf:prelude end
@@ -0,0 +1,136 @@
+package prelude
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// This file is only embedded in the generated program with --xml.
+
+// golf:prelude start
+
+// X is the current record decoded, with --xml. Attributes are under "@"
+// and their name, and child elements under their name: a string if they
+// only hold text, a map like X otherwise, or a []interface{} of those if
+// there are several. Text beside attributes or elements is under "#text".
+var X map[string]interface{}
+
+// golfXMLSource reads the elements named elem in XML inputs as records, for
+// --xml, and decodes each into X. The input is read as a stream, so it
+// needn't fit in memory; only the element being read is kept.
+type golfXMLSource struct {
+	elem string
+	in   *golfXMLReader
+	dec  *xml.Decoder
+}
+
+func (s *golfXMLSource) Open(name string, r io.Reader) error {
+	s.in = &golfXMLReader{r: bufio.NewReader(r)}
+	s.dec = xml.NewDecoder(s.in)
+	return nil
+}
+
+func (s *golfXMLSource) Next() ([]byte, error) {
+	for {
+		// Each token starts where the previous one ended.
+		start := s.dec.InputOffset()
+		s.in.discard(start)
+		tok, err := s.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != s.elem {
+			continue
+		}
+		var n golfXMLNode
+		if err := s.dec.DecodeElement(&n, &se); err != nil {
+			return nil, err
+		}
+		switch v := n.value().(type) {
+		case map[string]interface{}:
+			X = v
+		default:
+			X = map[string]interface{}{"#text": v}
+		}
+		return s.in.since(start, s.dec.InputOffset()), nil
+	}
+}
+
+func (s *golfXMLSource) Close() error { return nil }
+
+// golfXMLReader keeps what the xml.Decoder reads through it, from base on,
+// so that golfXMLSource can return elements as they were written.
+type golfXMLReader struct {
+	r    *bufio.Reader
+	buf  []byte
+	base int64 // Offset in the input of buf[0].
+}
+
+// ReadByte is what the decoder uses, as it is an io.ByteReader.
+func (r *golfXMLReader) ReadByte() (byte, error) {
+	c, err := r.r.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, c)
+	}
+	return c, err
+}
+
+func (r *golfXMLReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+// discard forgets what was read before offset.
+func (r *golfXMLReader) discard(offset int64) {
+	r.buf = append(r.buf[:0], r.buf[offset-r.base:]...)
+	r.base = offset
+}
+
+// since returns what was read between the offsets start and end.
+func (r *golfXMLReader) since(start, end int64) []byte {
+	return r.buf[start-r.base : end-r.base]
+}
+
+// golfXMLNode is any XML element, as decoded for X.
+type golfXMLNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr    `xml:",any,attr"`
+	Text    string        `xml:",chardata"`
+	Nodes   []golfXMLNode `xml:",any"`
+}
+
+// value returns n as it goes in X.
+func (n *golfXMLNode) value() interface{} {
+	text := strings.TrimSpace(n.Text)
+	m := make(map[string]interface{}, len(n.Attrs)+len(n.Nodes)+1)
+	for _, a := range n.Attrs {
+		if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+			continue // Namespace declarations.
+		}
+		m["@"+a.Name.Local] = a.Value
+	}
+	for i := range n.Nodes {
+		k, v := n.Nodes[i].XMLName.Local, n.Nodes[i].value()
+		switch old := m[k].(type) {
+		case nil:
+			m[k] = v
+		case []interface{}:
+			m[k] = append(old, v)
+		default:
+			m[k] = []interface{}{old, v}
+		}
+	}
+	if len(m) == 0 {
+		return text
+	}
+	if text != "" {
+		m["#text"] = text
+	}
+	return m
+}
+
+// golf:prelude end
@@ -0,0 +1,246 @@
+package prelude
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// This file is only embedded in the generated program with --yaml, or when
+// the script uses the YAML helpers.
+
+// golf:prelude start
+
+// golfYAMLSource reads the documents of YAML streams, for --yaml. Each is a
+// record, converted to JSON on a single line, so that -j sets J from it.
+type golfYAMLSource struct {
+	dec *yaml.Decoder
+	buf bytes.Buffer
+}
+
+// golfYAMLDoc is the current record as --yaml read it, which ToYAML follows
+// when given J, so that its comments, key order and styles are kept.
+var golfYAMLDoc *yaml.Node
+
+func (s *golfYAMLSource) Open(name string, r io.Reader) error {
+	s.dec = yaml.NewDecoder(r)
+	return nil
+}
+
+func (s *golfYAMLSource) Next() ([]byte, error) {
+	var doc yaml.Node
+	if err := s.dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := doc.Decode(&v); err != nil {
+		return nil, err
+	}
+	golfYAMLDoc = &doc
+	s.buf.Reset()
+	e := json.NewEncoder(&s.buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(golfYAMLToJSON(v)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(s.buf.Bytes(), []byte("\n")), nil
+}
+
+func (s *golfYAMLSource) Close() error { return nil }
+
+// golfYAMLToJSON returns v, as decoded from YAML, in a form JSON can encode:
+// mappings with keys other than strings have them formatted as strings.
+func golfYAMLToJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = golfYAMLToJSON(e)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[fmt.Sprint(k)] = golfYAMLToJSON(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range x {
+			x[i] = golfYAMLToJSON(e)
+		}
+	}
+	return v
+}
+
+// golfJSONToYAML returns v with the json.Numbers in it, such as from J, as
+// YAML numbers, rather than the strings they would otherwise encode as.
+func golfJSONToYAML(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(string(x), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: string(x)}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = golfJSONToYAML(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(x))
+		for i, e := range x {
+			s[i] = golfJSONToYAML(e)
+		}
+		return s
+	}
+	return v
+}
+
+// FromYAML decodes the YAML document s into v, which must be a pointer. If
+// s can't be decoded, golf dies, or, in -w mode, counts a warning and sets
+// *v to its zero value.
+//
+//	golf --yaml -b 'var d struct{ Kind string; Metadata struct{ Name string } }' -lne 'FromYAML(Line, &d); Print(d.Kind, d.Metadata.Name)' manifests.yaml
+func FromYAML(s string, v interface{}) {
+	err := yaml.Unmarshal([]byte(s), v)
+	if err == nil {
+		return
+	}
+	if Warnings && !Strict {
+		golfNoteWarning("FromYAML: invalid YAML", err.Error())
+		if p := reflect.ValueOf(v); p.Kind() == reflect.Ptr && !p.IsNil() {
+			p.Elem().Set(reflect.Zero(p.Elem().Type()))
+		}
+		return
+	}
+	if LineNum > 0 {
+		Die("%s:%d: FromYAML: %v", Filename, LineNum, err)
+	}
+	Die("FromYAML: %v", err)
+}
+
+// ToYAML returns v as a YAML document, indented with two spaces, without a
+// final newline. With --yaml, J is written like the record it was read
+// from, comments and key order included, but for what the script changed;
+// keys it added come last. Values that can't be encoded are fatal.
+func ToYAML(v interface{}) string {
+	out := golfJSONToYAML(v)
+	if m, ok := v.(map[string]interface{}); ok && golfYAMLDoc != nil && golfIsJ(m) {
+		out = golfYAMLMerge(golfYAMLDoc, v)
+	}
+	var b strings.Builder
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	if err := e.Encode(out); err != nil {
+		Die("golf: ToYAML: %v", err)
+	}
+	e.Close()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// golfIsJ reports whether m is J itself, rather than a copy or another map.
+func golfIsJ(m map[string]interface{}) bool {
+	return m != nil && J != nil && reflect.ValueOf(m).Pointer() == reflect.ValueOf(J).Pointer()
+}
+
+// golfYAMLMerge returns v, as from J, as a YAML node that reuses the parts
+// of n, the node it was read from, that still hold the same values. n may
+// be nil, for values the script added.
+func golfYAMLMerge(n *yaml.Node, v interface{}) *yaml.Node {
+	if n != nil {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			if len(n.Content) == 1 {
+				d := *n
+				d.Content = []*yaml.Node{golfYAMLMerge(n.Content[0], v)}
+				return &d
+			}
+		case yaml.MappingNode:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				break
+			}
+			c := *n
+			c.Content = nil
+			seen := make(map[string]bool, len(m))
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				k := n.Content[i].Value
+				e, ok := m[k]
+				if !ok || seen[k] {
+					continue // Deleted by the script.
+				}
+				seen[k] = true
+				c.Content = append(c.Content, n.Content[i], golfYAMLMerge(n.Content[i+1], e))
+			}
+			var added []string
+			for k := range m {
+				if !seen[k] {
+					added = append(added, k)
+				}
+			}
+			sort.Strings(added)
+			for _, k := range added {
+				key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}
+				c.Content = append(c.Content, key, golfYAMLMerge(nil, m[k]))
+			}
+			return &c
+		case yaml.SequenceNode:
+			s, ok := v.([]interface{})
+			if !ok {
+				break
+			}
+			c := *n
+			c.Content = make([]*yaml.Node, len(s))
+			for i, e := range s {
+				var old *yaml.Node
+				if i < len(n.Content) {
+					old = n.Content[i]
+				}
+				c.Content[i] = golfYAMLMerge(old, e)
+			}
+			return &c
+		}
+	}
+	fresh := &yaml.Node{}
+	if err := fresh.Encode(golfJSONToYAML(v)); err != nil {
+		Die("golf: ToYAML: %v", err)
+	}
+	if n != nil && golfYAMLSame(n, fresh) {
+		return n
+	}
+	return fresh
+}
+
+// golfYAMLSame reports whether the nodes a and b hold the same value.
+func golfYAMLSame(a, b *yaml.Node) bool {
+	var x, y interface{}
+	if a.Decode(&x) != nil || b.Decode(&y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// PrintYAML prints v as a document of a YAML stream: ToYAML, after a ---
+// line, and ending with a newline even without -l.
+//
+//	golf --yaml -lne 'if JQS("kind") == "Deployment" { PrintYAML(J) }' manifests.yaml
+func PrintYAML(v interface{}) {
+	Print(golfYAMLRecord(v))
+}
+
+// golfYAMLRecord returns v as PrintYAML prints it.
+func golfYAMLRecord(v interface{}) string {
+	s := "---\n" + ToYAML(v)
+	if !GolfFlgL {
+		s += "\n"
+	}
+	return s
+}
+
+// golf:prelude end
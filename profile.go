@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gaal/golf/prelude"
+)
+
+// safeDenied lists the packages the safe profile doesn't allow -M to import,
+// and its reason for each.
+var safeDenied = map[string]string{
+	"os/exec":  "runs commands",
+	"syscall":  "makes raw system calls",
+	"unsafe":   "defeats type safety",
+	"plugin":   "loads code",
+	"net":      "uses the network",
+	"net/http": "uses the network",
+	"net/rpc":  "uses the network",
+	"net/smtp": "uses the network",
+}
+
+// profile returns the effective --profile: GOLF_PROFILE, if set, overrides
+// the flag, so that wrapper scripts can enforce it.
+func profile(flagValue string) string {
+	if env := os.Getenv("GOLF_PROFILE"); env != "" {
+		return env
+	}
+	return flagValue
+}
+
+// checkProfile reports an error if the run described by p, with the -M
+// modules given, is not allowed under the named profile.
+//
+// The safe profile is for shared environments where operators run one-liners
+// over data they must not damage by accident. It rules out in-place editing,
+// fetching URLs, goimports, which may pull in any package, running elsewhere
+// with --in-container or golf remote, accepting input from the network with
+// --listen, and imports of packages in safeDenied: with -M, or, for those the
+// generated program always imports, uses of them in the script. It is not a
+// sandbox: the one-liner can still, for example, call os.Remove.
+func checkProfile(name string, p *prog, modules []string) error {
+	switch name {
+	case "":
+		return nil
+	case "safe":
+	default:
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if p.InPlace {
+		return fmt.Errorf("safe profile: in-place editing is disabled")
+	}
+	if p.Goimports {
+		return fmt.Errorf("safe profile: -g is disabled")
+	}
+	if p.Container != "" || p.Remote != "" {
+		return fmt.Errorf("safe profile: --in-container and golf remote are disabled")
+	}
+	if p.Listen != "" {
+		return fmt.Errorf("safe profile: --listen is disabled")
+	}
+	for _, m := range modules {
+		if why, ok := safeDenied[m]; ok {
+			return fmt.Errorf("safe profile: can't import %s, which %s", m, why)
+		}
+	}
+	var blocks []string
+	for _, b := range [][]string{p.BeginSrc, p.BeginFileSrc, p.RawSrc, p.EndFileSrc, p.EndSrc, {p.FormatSrc, p.Validate}} {
+		blocks = append(blocks, b...)
+	}
+	script := strings.Join(blocks, "\n")
+	for _, imp := range p.Imports {
+		if why, ok := safeDenied[imp]; ok && usesIdent(script, path.Base(imp)) {
+			return fmt.Errorf("safe profile: can't use %s, which %s", imp, why)
+		}
+	}
+	for _, arg := range p.RawArgs {
+		if prelude.IsURL(arg) {
+			return fmt.Errorf("safe profile: can't fetch %s", arg)
+		}
+	}
+	return nil
+}
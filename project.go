@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// compileProjection turns a --project spec such as "3,1,5-" into a Go
+// statement that rearranges Fields accordingly.
+//
+// The spec is a comma-separated list of 1-based field numbers and ranges,
+// like cut -f: N, N-M, N- (to the last field), and -M (from the first).
+// A field number beyond the end of the record yields an empty field, so that
+// ragged input produces rectangular output; ranges stop at the last field.
+func compileProjection(spec string) (string, error) {
+	var spans []string
+	for _, item := range strings.Split(spec, ",") {
+		from, to := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			from, to = item[:i], item[i+1:]
+			if from == "" {
+				from = "1"
+			}
+			if to == "" {
+				to = "-1" // to the end.
+			}
+		}
+		f, err := strconv.Atoi(from)
+		if err != nil || f < 1 {
+			return "", fmt.Errorf("bad field %q in %q", item, spec)
+		}
+		t, err := strconv.Atoi(to)
+		if err != nil || (t < f && t != -1) {
+			return "", fmt.Errorf("bad field range %q in %q", item, spec)
+		}
+		spans = append(spans, fmt.Sprintf("{%d, %d}", f, t))
+	}
+	return fmt.Sprintf("golfProject([]golfSpan{%s})", strings.Join(spans, ", ")), nil
+}
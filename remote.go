@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gaal/golf/prelude"
+)
+
+// remoteHost returns HOST from a "golf remote HOST ..." command line, and
+// takes the subcommand out of os.Args, so the rest is parsed as usual. It
+// returns "" for other command lines.
+func remoteHost() string {
+	if len(os.Args) < 3 || os.Args[1] != "remote" {
+		return ""
+	}
+	host := os.Args[2]
+	os.Args = append(os.Args[:1], os.Args[3:]...)
+	return host
+}
+
+// checkRemote returns an error if the golf command line can't be run
+// remotely: input must come from files on the remote host, named as they are
+// there, since stdin carries the one-liner over.
+func checkRemote(args []string, lineMode, recursive bool, parallel int) error {
+	if lineMode && len(args) == 0 {
+		return fmt.Errorf("name the input files; stdin is not forwarded")
+	}
+	for _, arg := range args {
+		if _, ok := prelude.InputFD(arg); ok || arg == "-" {
+			return fmt.Errorf("%s: stdin and inherited descriptors are not forwarded", arg)
+		}
+	}
+	if recursive || parallel > 1 {
+		return fmt.Errorf("-r and -P are not supported")
+	}
+	return nil
+}
+
+// remoteTarget asks host for its operating system and architecture, as
+// GOOS and GOARCH.
+func remoteTarget(host string) (goos, goarch string, err error) {
+	out, err := exec.Command("ssh", host, "uname -sm").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("ssh %s: %v", host, err)
+	}
+	return goTarget(string(out))
+}
+
+// goTarget translates the output of uname -sm to GOOS and GOARCH.
+func goTarget(uname string) (goos, goarch string, err error) {
+	f := strings.Fields(uname)
+	if len(f) != 2 {
+		return "", "", fmt.Errorf("unexpected uname output %q", uname)
+	}
+	oses := map[string]string{"Linux": "linux", "Darwin": "darwin", "FreeBSD": "freebsd", "OpenBSD": "openbsd", "NetBSD": "netbsd"}
+	arches := map[string]string{
+		"x86_64": "amd64", "amd64": "amd64",
+		"aarch64": "arm64", "arm64": "arm64",
+		"armv6l": "arm", "armv7l": "arm",
+		"i386": "386", "i686": "386",
+		"ppc64le": "ppc64le", "s390x": "s390x", "riscv64": "riscv64",
+	}
+	goos, goarch = oses[f[0]], arches[f[1]]
+	if goos == "" || goarch == "" {
+		return "", "", fmt.Errorf("unsupported platform %q", strings.TrimSpace(uname))
+	}
+	return goos, goarch, nil
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runRemote copies the one-liner binary bin to p.Remote over ssh, and runs
+// it there, in the login directory, with the input files named on the
+// command line. Its output and exit status come back through ssh. The copy
+// is removed when it finishes.
+func (p *prog) runRemote(bin string) int {
+	f, err := os.Open(bin)
+	if err != nil {
+		prelude.Warn("golf remote: %v", err)
+		return 1
+	}
+	defer f.Close()
+	script := `f=$(mktemp) || exit 1; cat >"$f" && chmod 700 "$f" && "$f"`
+	for _, arg := range p.binArgs(p.RawArgs) {
+		script += " " + shellQuote(arg)
+	}
+	script += `; s=$?; rm -f "$f"; exit $s`
+	cmd := exec.Command("ssh", p.Remote, script)
+	cmd.Stdin = f
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := relay.run(cmd); err != nil {
+		return p.exitStatus(err)
+	}
+	return 0
+}
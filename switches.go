@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// takeSwitches implements -s: if -s is among the golf flags at the start of
+// args, the other -name and -name=value arguments there, which aren't golf
+// flags, are taken out as switches for the one-liner, like perl -s does. A
+// switch without a value is "1". The golf flags end at the first argument
+// that is neither a flag nor a flag's value, or at --.
+func takeSwitches(args []string) ([]string, map[string]string) {
+	var rest []string
+	switches := map[string]string{}
+	on := false
+	for i := 0; i < len(args); i++ {
+		v := args[i]
+		if v == "--" || v == "" || v == "-" || v[0] != '-' || v[1] >= '0' && v[1] <= '9' {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value := strings.TrimLeft(v, "-"), "1"
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value = name[:eq], name[eq+1:]
+		}
+		names, needsValue := golfFlagNames(name)
+		if names == nil {
+			switches[name] = value
+			continue
+		}
+		for _, n := range names {
+			on = on || n == "s"
+		}
+		rest = append(rest, v)
+		if needsValue && !strings.Contains(v, "=") && i+1 < len(args) {
+			i++
+			rest = append(rest, args[i])
+		}
+	}
+	if !on {
+		return args, nil
+	}
+	return rest, switches
+}
+
+// golfFlagNames returns the golf flags that the argument -name stands for:
+// name itself, or a cluster of short flags, as decluster splits them. It
+// also reports whether the last of them takes the next argument as its
+// value. It returns nil if name isn't a golf flag.
+func golfFlagNames(name string) (names []string, needsValue bool) {
+	if f := flag.Lookup(name); f != nil {
+		return []string{name}, !isBoolFlag(f)
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i : i+1]
+		f := flag.Lookup(c)
+		if f == nil {
+			return nil, false
+		}
+		names = append(names, c)
+		switch {
+		case c == "l" && i+1 < len(name) && isOctal(name[i+1:i+2]):
+			// -l012: octal digits are the ORS.
+			for i+1 < len(name) && isOctal(name[i+1:i+2]) {
+				i++
+			}
+		case c == "i" && i+1 < len(name) && !isCluster(strings.Split(name[i+1:], "")):
+			return names, false // -i.bak
+		case i == len(name)-1:
+			return names, !isBoolFlag(f)
+		case !isBoolFlag(f):
+			return nil, false
+		}
+	}
+	return names, false
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gaal/golf/prelude"
+)
+
+// expandDirs replaces the directories among args with the regular files
+// under them, in lexical order, for -r. Files found this way are kept if
+// their base name matches one of include, or include is empty, and none of
+// exclude. exclude also prunes directories. Other arguments are kept as they
+// are.
+func expandDirs(args, include, exclude []string) ([]string, error) {
+	for _, pat := range append(include, exclude...) {
+		if _, err := filepath.Match(pat, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q", pat)
+		}
+	}
+	matches := func(pats []string, name string) bool {
+		for _, pat := range pats {
+			if ok, _ := filepath.Match(pat, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	var res []string
+	for _, arg := range args {
+		if prelude.IsURL(arg) {
+			res = append(res, arg)
+			continue
+		}
+		if fi, err := os.Stat(arg); err != nil || !fi.IsDir() {
+			// Leave errors for the one-liner to report, in order.
+			res = append(res, arg)
+			continue
+		}
+		err := filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != arg && matches(exclude, d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// Symlinks are not followed, as with grep -r.
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if len(include) == 0 || matches(include, d.Name()) {
+				res = append(res, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// globArgs expands the glob patterns among args, for Windows, where the
+// shell leaves that to programs. Arguments that name existing files, or
+// match nothing, are kept as they are.
+func globArgs(args []string) []string {
+	var res []string
+	for _, arg := range args {
+		if !strings.ContainsAny(arg, "*?[") || prelude.IsURL(arg) {
+			res = append(res, arg)
+			continue
+		}
+		if _, err := os.Stat(arg); err == nil {
+			res = append(res, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil || len(matches) == 0 {
+			res = append(res, arg)
+			continue
+		}
+		res = append(res, matches...)
+	}
+	return res
+}
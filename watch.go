@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gaal/golf/prelude"
+)
+
+// watchInterval is how often --watch checks its inputs for changes.
+var watchInterval = 250 * time.Millisecond
+
+// checkWatch returns an error if the golf command line can't be rerun on
+// changes to its inputs.
+func checkWatch(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("needs input files to watch")
+	}
+	for _, arg := range args {
+		if arg == "-" {
+			return fmt.Errorf("standard input can't be watched")
+		}
+		if _, ok := prelude.InputFD(arg); ok {
+			return fmt.Errorf("%s: inherited descriptors can't be watched", arg)
+		}
+		if prelude.IsURL(arg) {
+			return fmt.Errorf("%s: URLs can't be watched", arg)
+		}
+	}
+	return nil
+}
+
+// watchState is what --watch remembers of an input, to notice it changed.
+type watchState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func statInputs(names []string) []watchState {
+	states := make([]watchState, len(names))
+	for i, name := range names {
+		if fi, err := os.Stat(name); err == nil {
+			states[i] = watchState{true, fi.Size(), fi.ModTime()}
+		}
+	}
+	return states
+}
+
+// changedInputs returns the names whose state differs between old and cur.
+func changedInputs(names []string, old, cur []watchState) []string {
+	var changed []string
+	for i, name := range names {
+		if old[i] != cur[i] {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// watch calls run, and then again each time one of p.RawArgs changes, until
+// golf is interrupted. A separator line on standard error tells the runs
+// apart. It returns the status of the last run.
+func (p *prog) watch(run func() int) int {
+	states := statInputs(p.RawArgs)
+	status := run()
+	for !relay.interrupted() && p.reraise == nil {
+		time.Sleep(watchInterval)
+		cur := statInputs(p.RawArgs)
+		changed := changedInputs(p.RawArgs, states, cur)
+		if len(changed) == 0 {
+			continue
+		}
+		// Let a burst of writes, as from an editor saving, settle.
+		time.Sleep(watchInterval)
+		states = statInputs(p.RawArgs)
+		if relay.interrupted() {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "--- golf: %s changed, %s ---\n", strings.Join(changed, ", "), time.Now().Format("15:04:05"))
+		status = run()
+	}
+	return status
+}